@@ -0,0 +1,215 @@
+// Package client holds the connection settings shared by greeter_client and loadtest_client.
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/prune998/goHelloGrpcStream/helloworld/compression"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+)
+
+// NameHeader is the metadata key the clients announce their name with, server.ClientNameHeader on the server side
+const NameHeader = "x-client-name"
+
+// Options configures how a client connects and authenticates to the server
+type Options struct {
+	// TLS enables TLS, otherwise the connection is plain HTTP/2
+	TLS bool
+	// InsecureSkipVerify disables the server certificate checks
+	InsecureSkipVerify bool
+	// CAFile verifies the server certificate against this CA instead of the system pool
+	CAFile string
+	// CertFile and KeyFile are the client certificate used for mTLS
+	CertFile string
+	KeyFile  string
+
+	// APIKey is sent in the x-api-key metadata of every call
+	APIKey string
+	// Token is sent as a bearer token in the authorization metadata of every call
+	Token string
+	// TokenFile is read before every call, like a Kubernetes projected token, and takes precedence over Token
+	TokenFile string
+
+	// Headers are sent with every call, as metadata or HTTP headers
+	Headers Headers
+	// Authority overrides the :authority, or Host, of the calls, the target by default
+	Authority string
+	// SNI overrides the TLS server name, which is also the name the server certificate is verified against.
+	// gRPC uses the Authority when empty.
+	SNI string
+
+	// Retry configures the retries or hedging of unary calls
+	Retry RetryOptions
+
+	// Compression is the compressor used by every call: gzip, zstd or snappy. Empty for none.
+	Compression string
+
+	// Protocol is used by ConnectGreeterClient: ProtocolGRPCWeb or ProtocolConnect
+	Protocol string
+	// HTTP1 makes ConnectGreeterClient use HTTP/1.1, where SayHelloStream is not available
+	HTTP1 bool
+
+	// Keepalive sends HTTP/2 pings after this duration without activity, to detect broken connections. 0 disables it.
+	// gRPC does not ping more often than every 10s, and the server must allow it, see greeter_server -keepalivemintime.
+	Keepalive time.Duration
+	// KeepaliveTimeout is how long to wait for the ping answer before closing the connection, 20s when 0
+	KeepaliveTimeout time.Duration
+
+	// ProxyHeader sends a PROXY protocol header, ProxyHeaderV1 or ProxyHeaderV2, at the start of every connection
+	ProxyHeader string
+	// ProxySource is the client address announced in the PROXY header, like 203.0.113.7:4242.
+	// The local address of the connection is used when empty.
+	ProxySource string
+}
+
+// DialOptions returns the transport and per-RPC credentials, and the retry setup, matching the options
+func (o Options) DialOptions() ([]grpc.DialOption, error) {
+	opts := o.Retry.dialOptions()
+
+	if err := compression.Validate(o.Compression); err != nil {
+		return nil, err
+	}
+	if o.Compression != "" {
+		opts = append(opts, grpc.WithDefaultCallOptions(grpc.UseCompressor(o.Compression)))
+	}
+
+	if err := o.validProxyHeader(); err != nil {
+		return nil, err
+	}
+	if o.ProxyHeader != "" {
+		opts = append(opts, grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			// addr is the target itself for unix sockets
+			return o.DialContext(addr)(ctx, "tcp", addr)
+		}))
+	}
+
+	if len(o.Headers) > 0 {
+		opts = append(opts, o.headerInterceptors()...)
+	}
+	if o.Authority != "" {
+		opts = append(opts, grpc.WithAuthority(o.Authority))
+	}
+
+	if o.Keepalive > 0 {
+		opts = append(opts, grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                o.Keepalive,
+			Timeout:             o.KeepaliveTimeout,
+			PermitWithoutStream: true,
+		}))
+	}
+
+	if o.TLS {
+		cfg, err := o.TLSConfig()
+		if err != nil {
+			return nil, err
+		}
+		creds := credentials.NewTLS(cfg)
+		if o.SNI != "" && o.Authority != "" {
+			// gRPC refuses a server name different from the authority
+			creds = sniCredentials{creds}
+		}
+		opts = append(opts, grpc.WithTransportCredentials(creds))
+	} else {
+		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	}
+
+	if o.APIKey != "" || o.Token != "" || o.TokenFile != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(&rpcCredentials{
+			apiKey:    o.APIKey,
+			token:     o.Token,
+			tokenFile: o.TokenFile,
+		}))
+	}
+	return opts, nil
+}
+
+// TLSConfig returns the TLS settings of the options: the CA, the client certificate, the SNI and InsecureSkipVerify
+func (o Options) TLSConfig() (*tls.Config, error) {
+	cfg := &tls.Config{InsecureSkipVerify: o.InsecureSkipVerify, ServerName: o.SNI}
+
+	if o.CAFile != "" {
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", o.CAFile)
+		}
+	}
+
+	if o.CertFile != "" || o.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// CredentialHeaders returns the API key, bearer token and Headers as HTTP headers,
+// for the transports that are not gRPC. The Authority is in the Host header.
+func (o Options) CredentialHeaders() (http.Header, error) {
+	creds := &rpcCredentials{apiKey: o.APIKey, token: o.Token, tokenFile: o.TokenFile}
+	md, err := creds.GetRequestMetadata(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	h := http.Header{}
+	for key, values := range o.Headers {
+		for _, v := range values {
+			h.Add(key, v)
+		}
+	}
+	for key, v := range md {
+		h.Set(key, v)
+	}
+	if o.Authority != "" {
+		h.Set("Host", o.Authority)
+	}
+	return h, nil
+}
+
+// rpcCredentials attaches the API key and bearer token to each call
+type rpcCredentials struct {
+	apiKey    string
+	token     string
+	tokenFile string
+}
+
+func (c *rpcCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	md := map[string]string{}
+	if c.apiKey != "" {
+		md["x-api-key"] = c.apiKey
+	}
+
+	token := c.token
+	if c.tokenFile != "" {
+		b, err := os.ReadFile(c.tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read token: %w", err)
+		}
+		token = strings.TrimSpace(string(b))
+	}
+	if token != "" {
+		md["authorization"] = "Bearer " + token
+	}
+	return md, nil
+}
+
+// RequireTransportSecurity is false as the TLS is often done by a sidecar in front of us
+func (c *rpcCredentials) RequireTransportSecurity() bool {
+	return false
+}
@@ -0,0 +1,65 @@
+package client
+
+import (
+	"sync/atomic"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/stats"
+)
+
+// Bytes is a snapshot of the message bytes counted by a ByteCounter
+type Bytes struct {
+	// Sent and Received are the uncompressed message sizes
+	Sent     int64
+	Received int64
+	// SentWire and ReceivedWire are the sizes after compression, gRPC framing included
+	SentWire     int64
+	ReceivedWire int64
+}
+
+// Sub returns the bytes counted since before
+func (b Bytes) Sub(before Bytes) Bytes {
+	return Bytes{
+		Sent:         b.Sent - before.Sent,
+		Received:     b.Received - before.Received,
+		SentWire:     b.SentWire - before.SentWire,
+		ReceivedWire: b.ReceivedWire - before.ReceivedWire,
+	}
+}
+
+// ByteCounter is a stats.Handler counting the bytes of all the messages of a connection.
+// Add it with grpc.WithStatsHandler.
+type ByteCounter struct {
+	sent, received, sentWire, receivedWire int64
+}
+
+// Snapshot returns the bytes counted so far
+func (c *ByteCounter) Snapshot() Bytes {
+	return Bytes{
+		Sent:         atomic.LoadInt64(&c.sent),
+		Received:     atomic.LoadInt64(&c.received),
+		SentWire:     atomic.LoadInt64(&c.sentWire),
+		ReceivedWire: atomic.LoadInt64(&c.receivedWire),
+	}
+}
+
+func (c *ByteCounter) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (c *ByteCounter) HandleRPC(_ context.Context, s stats.RPCStats) {
+	switch p := s.(type) {
+	case *stats.OutPayload:
+		atomic.AddInt64(&c.sent, int64(p.Length))
+		atomic.AddInt64(&c.sentWire, int64(p.WireLength))
+	case *stats.InPayload:
+		atomic.AddInt64(&c.received, int64(p.Length))
+		atomic.AddInt64(&c.receivedWire, int64(p.WireLength))
+	}
+}
+
+func (c *ByteCounter) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (c *ByteCounter) HandleConn(context.Context, stats.ConnStats) {}
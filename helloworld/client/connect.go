@@ -0,0 +1,305 @@
+package client
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"connectrpc.com/connect"
+	"github.com/prune998/goHelloGrpcStream/helloworld/compression"
+	pb "github.com/prune998/goHelloGrpcStream/helloworld/helloworld"
+	"github.com/prune998/goHelloGrpcStream/helloworld/helloworld/helloworldconnect"
+	"golang.org/x/net/context"
+	"golang.org/x/net/http2"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// Protocols a Greeter client can speak
+const (
+	ProtocolGRPC    = "grpc"
+	ProtocolGRPCWeb = "grpcweb"
+	ProtocolConnect = "connect"
+)
+
+// ConnectGreeterClient returns a Greeter client speaking gRPC-Web or Connect, as set by o.Protocol,
+// to the server HTTP port at addr, like localhost:7789 or unix:///run/greeter.sock.
+// Retries and hedging are not supported, and gzip is the only compressor.
+func (o Options) ConnectGreeterClient(addr string) (pb.GreeterClient, error) {
+	var opts []connect.ClientOption
+	switch o.Protocol {
+	case ProtocolGRPCWeb:
+		opts = append(opts, connect.WithGRPCWeb())
+	case ProtocolConnect:
+	default:
+		return nil, fmt.Errorf("unknown protocol %q, use %s or %s", o.Protocol, ProtocolGRPCWeb, ProtocolConnect)
+	}
+	switch o.Compression {
+	case "":
+	case compression.Gzip:
+		opts = append(opts, connect.WithSendGzip())
+	default:
+		return nil, fmt.Errorf("compressor %q is not supported with %s, use gzip", o.Compression, o.Protocol)
+	}
+
+	httpClient, err := o.HTTPClient(addr)
+	if err != nil {
+		return nil, err
+	}
+	scheme := "http://"
+	if o.TLS {
+		scheme = "https://"
+	}
+	return &connectClient{
+		client: helloworldconnect.NewGreeterClient(httpClient, scheme+HTTPHost(addr), opts...),
+		creds: &rpcCredentials{
+			apiKey:    o.APIKey,
+			token:     o.Token,
+			tokenFile: o.TokenFile,
+		},
+		headers: o.Headers,
+	}, nil
+}
+
+// HTTPClient returns a client for the server HTTP port at target, with the TLS settings of the options.
+// It speaks HTTP/2, in clear text when TLS is disabled, unless o.HTTP1 is set.
+// When target is a unix socket, all the requests go to it whatever their URL.
+func (o Options) HTTPClient(target string) (*http.Client, error) {
+	if err := o.validProxyHeader(); err != nil {
+		return nil, err
+	}
+	var cfg *tls.Config
+	if o.TLS {
+		var err error
+		if cfg, err = o.TLSConfig(); err != nil {
+			return nil, err
+		}
+	}
+
+	dial := o.DialContext(target)
+	if o.HTTP1 {
+		return o.httpClient(&http.Transport{TLSClientConfig: cfg, DialContext: dial}), nil
+	}
+	transport := &http2.Transport{TLSClientConfig: cfg}
+	if !o.TLS {
+		transport.AllowHTTP = true
+		transport.DialTLSContext = func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return dial(ctx, network, addr)
+		}
+	} else {
+		// dial through the options, for the unix sockets and the PROXY header, and do the handshake on top
+		transport.DialTLSContext = func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+			conn, err := dial(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			tlsConn := tls.Client(conn, cfg)
+			if err := tlsConn.HandshakeContext(ctx); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			return tlsConn, nil
+		}
+	}
+	return o.httpClient(transport), nil
+}
+
+// httpClient returns a client using transport, with the Authority of the options
+func (o Options) httpClient(transport http.RoundTripper) *http.Client {
+	if o.Authority != "" {
+		transport = hostTransport{RoundTripper: transport, host: o.Authority}
+	}
+	return &http.Client{Transport: transport}
+}
+
+// connectClient adapts the Connect client to pb.GreeterClient, so the same code drives all the protocols.
+// Errors are converted back to gRPC status errors and the call options are ignored.
+type connectClient struct {
+	client  helloworldconnect.GreeterClient
+	creds   *rpcCredentials
+	headers Headers
+}
+
+// header sets the headers of the options, the outgoing metadata of ctx and the credentials in the request headers
+func (c *connectClient) header(ctx context.Context, h http.Header) error {
+	countAttempt(ctx)
+	md, _ := metadata.FromOutgoingContext(ctx)
+	md = metadata.Join(metadata.MD(c.headers), md)
+	for key, values := range md {
+		for _, v := range values {
+			h.Add(key, v)
+		}
+	}
+	creds, err := c.creds.GetRequestMetadata(ctx)
+	if err != nil {
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+	for key, v := range creds {
+		h.Set(key, v)
+	}
+	return nil
+}
+
+func (c *connectClient) SayHello(ctx context.Context, in *pb.HelloRequest, _ ...grpc.CallOption) (*pb.HelloReply, error) {
+	req := connect.NewRequest(in)
+	if err := c.header(ctx, req.Header()); err != nil {
+		return nil, err
+	}
+	resp, err := c.client.SayHello(ctx, req)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return resp.Msg, nil
+}
+
+func (c *connectClient) SayHelloStream(ctx context.Context, _ ...grpc.CallOption) (pb.Greeter_SayHelloStreamClient, error) {
+	stream := c.client.SayHelloStream(ctx)
+	if err := c.header(ctx, stream.RequestHeader()); err != nil {
+		return nil, err
+	}
+	return &connectBidiStream{ctx: ctx, stream: stream}, nil
+}
+
+func (c *connectClient) SayHelloServerStream(ctx context.Context, in *pb.HelloStreamRequest, _ ...grpc.CallOption) (pb.Greeter_SayHelloServerStreamClient, error) {
+	req := connect.NewRequest(in)
+	if err := c.header(ctx, req.Header()); err != nil {
+		return nil, err
+	}
+	stream, err := c.client.SayHelloServerStream(ctx, req)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &connectServerStream{ctx: ctx, stream: stream}, nil
+}
+
+// connectBidiStream adapts a Connect bidi stream to pb.Greeter_SayHelloStreamClient
+type connectBidiStream struct {
+	ctx    context.Context
+	stream *connect.BidiStreamForClient[pb.HelloRequest, pb.HelloReply]
+}
+
+func (s *connectBidiStream) Send(m *pb.HelloRequest) error {
+	if err := s.stream.Send(m); err != nil && !errors.Is(err, io.EOF) {
+		return statusError(err)
+	}
+	return nil
+}
+
+func (s *connectBidiStream) Recv() (*pb.HelloReply, error) {
+	m, err := s.stream.Receive()
+	if errors.Is(err, io.EOF) {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return m, nil
+}
+
+func (s *connectBidiStream) Header() (metadata.MD, error) {
+	return headerMetadata(s.stream.ResponseHeader()), nil
+}
+
+func (s *connectBidiStream) Trailer() metadata.MD {
+	return headerMetadata(s.stream.ResponseTrailer())
+}
+
+func (s *connectBidiStream) CloseSend() error {
+	return s.stream.CloseRequest()
+}
+
+func (s *connectBidiStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *connectBidiStream) SendMsg(m interface{}) error {
+	return s.Send(m.(*pb.HelloRequest))
+}
+
+func (s *connectBidiStream) RecvMsg(m interface{}) error {
+	return recvMsg(m, func() (proto.Message, error) { return s.Recv() })
+}
+
+// connectServerStream adapts a Connect server stream to pb.Greeter_SayHelloServerStreamClient
+type connectServerStream struct {
+	ctx    context.Context
+	stream *connect.ServerStreamForClient[pb.HelloReply]
+}
+
+func (s *connectServerStream) Recv() (*pb.HelloReply, error) {
+	if s.stream.Receive() {
+		return s.stream.Msg(), nil
+	}
+	defer s.stream.Close()
+	if err := s.stream.Err(); err != nil {
+		return nil, statusError(err)
+	}
+	return nil, io.EOF
+}
+
+func (s *connectServerStream) Header() (metadata.MD, error) {
+	return headerMetadata(s.stream.ResponseHeader()), nil
+}
+
+func (s *connectServerStream) Trailer() metadata.MD {
+	return headerMetadata(s.stream.ResponseTrailer())
+}
+
+func (s *connectServerStream) CloseSend() error {
+	return nil
+}
+
+func (s *connectServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *connectServerStream) SendMsg(interface{}) error {
+	return errors.New("SendMsg is not supported on a server stream")
+}
+
+func (s *connectServerStream) RecvMsg(m interface{}) error {
+	return recvMsg(m, func() (proto.Message, error) { return s.Recv() })
+}
+
+// recvMsg receives the next message with recv and copies it to m
+func recvMsg(m interface{}, recv func() (proto.Message, error)) error {
+	msg, err := recv()
+	if err != nil {
+		return err
+	}
+	dst := m.(proto.Message)
+	proto.Reset(dst)
+	proto.Merge(dst, msg)
+	return nil
+}
+
+// statusError converts a Connect error, with its details, to a gRPC status error
+func statusError(err error) error {
+	var cerr *connect.Error
+	if !errors.As(err, &cerr) {
+		return status.FromContextError(err).Err()
+	}
+	st := &spb.Status{Code: int32(cerr.Code()), Message: cerr.Message()}
+	for _, d := range cerr.Details() {
+		st.Details = append(st.Details, &anypb.Any{TypeUrl: "type.googleapis.com/" + d.Type(), Value: d.Bytes()})
+	}
+	return status.FromProto(st).Err()
+}
+
+// headerMetadata converts HTTP headers to gRPC metadata
+func headerMetadata(h http.Header) metadata.MD {
+	md := metadata.MD{}
+	for key, values := range h {
+		md.Append(strings.ToLower(key), values...)
+	}
+	return md
+}
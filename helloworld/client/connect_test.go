@@ -0,0 +1,124 @@
+package client_test
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/prune998/goHelloGrpcStream/helloworld/client"
+	pb "github.com/prune998/goHelloGrpcStream/helloworld/helloworld"
+	"github.com/prune998/goHelloGrpcStream/helloworld/server"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// startHTTPServer starts a Greeter serving its HTTP port on an ephemeral port and returns its address
+func startHTTPServer(t *testing.T, opts server.Options) string {
+	t.Helper()
+
+	opts.Logger = logrus.New()
+	opts.Logger.SetOutput(io.Discard)
+	s, err := server.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.ServeHTTP(lis)
+	t.Cleanup(s.Stop)
+	return lis.Addr().String()
+}
+
+func TestNameHeader(t *testing.T) {
+	if client.NameHeader != server.ClientNameHeader {
+		t.Errorf("the clients send their name as %s, the server reads %s", client.NameHeader, server.ClientNameHeader)
+	}
+}
+
+func TestConnectProtocols(t *testing.T) {
+	opts := server.DefaultOptions()
+	opts.Reply = true
+	addr := startHTTPServer(t, opts)
+
+	for _, protocol := range []string{client.ProtocolGRPCWeb, client.ProtocolConnect} {
+		for _, http1 := range []bool{false, true} {
+			c, err := client.Options{Protocol: protocol, HTTP1: http1}.ConnectGreeterClient(addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r, err := c.SayHello(context.Background(), &pb.HelloRequest{Name: protocol})
+			if err != nil {
+				t.Fatalf("%s http1=%v: SayHello failed: %v", protocol, http1, err)
+			}
+			if r.Message != "Hello "+protocol+" 7788" {
+				t.Errorf("%s http1=%v: got message %q", protocol, http1, r.Message)
+			}
+
+			stream, err := c.SayHelloServerStream(context.Background(), &pb.HelloStreamRequest{Name: protocol, Count: 3, IntervalMs: 1})
+			if err != nil {
+				t.Fatalf("%s http1=%v: SayHelloServerStream failed: %v", protocol, http1, err)
+			}
+			received := 0
+			for {
+				if _, err := stream.Recv(); err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatalf("%s http1=%v: server stream failed: %v", protocol, http1, err)
+				}
+				received++
+			}
+			if received != 3 {
+				t.Errorf("%s http1=%v: got %d greetings, want 3", protocol, http1, received)
+			}
+
+			// streaming both ways needs HTTP/2
+			if http1 {
+				continue
+			}
+			bidi, err := c.SayHelloStream(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range []string{"one", "two"} {
+				if err := bidi.Send(&pb.HelloRequest{Name: name}); err != nil {
+					t.Fatalf("%s: Send failed: %v", protocol, err)
+				}
+				reply, err := bidi.Recv()
+				if err != nil {
+					t.Fatalf("%s: Recv failed: %v", protocol, err)
+				}
+				if reply.Message != "Pong "+name {
+					t.Errorf("%s: got message %q", protocol, reply.Message)
+				}
+			}
+			bidi.CloseSend()
+			if _, err := bidi.Recv(); err != io.EOF {
+				t.Errorf("%s: got %v after CloseSend, want EOF", protocol, err)
+			}
+		}
+	}
+}
+
+func TestConnectErrors(t *testing.T) {
+	opts := server.DefaultOptions()
+	opts.Auth.APIKeys = map[string]string{"secret": "tester"}
+	addr := startHTTPServer(t, opts)
+
+	for _, protocol := range []string{client.ProtocolGRPCWeb, client.ProtocolConnect} {
+		for key, want := range map[string]codes.Code{"": codes.Unauthenticated, "wrong": codes.Unauthenticated, "secret": codes.OK} {
+			c, err := client.Options{Protocol: protocol, APIKey: key}.ConnectGreeterClient(addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = c.SayHello(context.Background(), &pb.HelloRequest{Name: "tester"})
+			if code := status.Code(err); code != want {
+				t.Errorf("%s with key %q: got %v, want %v", protocol, key, code, want)
+			}
+		}
+	}
+}
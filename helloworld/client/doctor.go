@@ -0,0 +1,362 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	pb "github.com/prune998/goHelloGrpcStream/helloworld/helloworld"
+	"golang.org/x/net/context"
+	"golang.org/x/net/http2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Layers checked by Diagnose, in order
+const (
+	LayerDNS    = "dns"
+	LayerTCP    = "tcp"
+	LayerTLS    = "tls"
+	LayerHTTP2  = "http2"
+	LayerHealth = "health"
+	LayerUnary  = "unary"
+	LayerStream = "stream"
+)
+
+// Status of a Check
+const (
+	CheckOK      = "ok"
+	CheckFailed  = "failed"
+	CheckSkipped = "skipped"
+)
+
+// Check is the result of checking one layer
+type Check struct {
+	Layer    string
+	Status   string
+	Duration time.Duration
+	// Detail tells what was found, or why the layer was skipped
+	Detail string
+	Err    error
+}
+
+// Diagnosis are the checks of all the layers. The layers after a failed one are skipped.
+type Diagnosis []Check
+
+// Failed returns the check of the failed layer, or nil when all passed
+func (d Diagnosis) Failed() *Check {
+	for i := range d {
+		if d[i].Status == CheckFailed {
+			return &d[i]
+		}
+	}
+	return nil
+}
+
+// doctor runs the checks of Diagnose, keeping what a layer found for the next ones
+type doctor struct {
+	opts   Options
+	target string
+	name   string
+
+	host  string
+	port  string
+	addrs []string
+	conn  net.Conn
+	c     pb.GreeterClient
+	grpc  *grpc.ClientConn
+}
+
+// Diagnose connects to target step by step, from the DNS resolution to a SayHelloStream round trip,
+// to tell which layer is failing. name is sent in the x-client-name metadata.
+// Each layer has timeout to succeed.
+func (o Options) Diagnose(ctx context.Context, target, name string, timeout time.Duration) Diagnosis {
+	d := &doctor{opts: o, target: strings.TrimPrefix(target, "dns:///"), name: name}
+	defer d.close()
+
+	checks := []struct {
+		layer string
+		run   func(ctx context.Context) (string, error)
+	}{
+		{LayerDNS, d.dns},
+		{LayerTCP, d.tcp},
+		{LayerTLS, d.tls},
+		{LayerHTTP2, d.http2},
+		{LayerHealth, d.health},
+		{LayerUnary, d.unary},
+		{LayerStream, d.stream},
+	}
+	var diag Diagnosis
+	failed := false
+	for _, check := range checks {
+		if failed {
+			diag = append(diag, Check{Layer: check.layer, Status: CheckSkipped, Detail: "a lower layer failed"})
+			continue
+		}
+		stepCtx, cancel := context.WithTimeout(ctx, timeout)
+		start := time.Now()
+		detail, err := check.run(stepCtx)
+		cancel()
+		c := Check{Layer: check.layer, Status: CheckOK, Duration: time.Since(start), Detail: detail, Err: err}
+		switch {
+		case errors.Is(err, errSkipped):
+			c.Status, c.Duration, c.Err = CheckSkipped, 0, nil
+		case err != nil:
+			c.Status = CheckFailed
+			failed = true
+		}
+		diag = append(diag, c)
+	}
+	return diag
+}
+
+// errSkipped is returned by the checks not relevant to the options
+var errSkipped = errors.New("skipped")
+
+func (d *doctor) close() {
+	if d.conn != nil {
+		d.conn.Close()
+	}
+	if d.grpc != nil {
+		d.grpc.Close()
+	}
+}
+
+func (d *doctor) dns(ctx context.Context) (string, error) {
+	if UnixSocket(d.target) != "" {
+		return "unix socket " + UnixSocket(d.target), errSkipped
+	}
+	host, port, err := net.SplitHostPort(d.target)
+	if err != nil {
+		return "", err
+	}
+	d.host, d.port = host, port
+	if host == "" {
+		host = "localhost"
+	}
+	if net.ParseIP(host) != nil {
+		d.addrs = []string{host}
+		return "literal address", nil
+	}
+	d.addrs, err = net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s -> %s", host, strings.Join(d.addrs, ", ")), nil
+}
+
+func (d *doctor) tcp(ctx context.Context) (string, error) {
+	dial := d.opts.DialContext(d.target)
+	if UnixSocket(d.target) != "" {
+		conn, err := dial(ctx, "tcp", d.target)
+		if err != nil {
+			return "", err
+		}
+		d.conn = conn
+		return "connected to " + UnixSocket(d.target), nil
+	}
+
+	// like gRPC, try the addresses in turn
+	var errs []string
+	for _, addr := range d.addrs {
+		conn, err := dial(ctx, "tcp", net.JoinHostPort(addr, d.port))
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		d.conn = conn
+		detail := fmt.Sprintf("%v -> %v", conn.LocalAddr(), conn.RemoteAddr())
+		if d.opts.ProxyHeader != "" {
+			detail += ", PROXY header " + d.opts.ProxyHeader + " sent"
+		}
+		return detail, nil
+	}
+	return "", errors.New(strings.Join(errs, "; "))
+}
+
+func (d *doctor) tls(ctx context.Context) (string, error) {
+	if !d.opts.TLS {
+		return "plain text, -tls is not set", errSkipped
+	}
+	cfg, err := d.opts.TLSConfig()
+	if err != nil {
+		return "", err
+	}
+	cfg.NextProtos = []string{http2.NextProtoTLS}
+	if d.opts.HTTP1 {
+		cfg.NextProtos = []string{"http/1.1"}
+	}
+	if cfg.ServerName == "" {
+		// like gRPC, the authority is the server name
+		cfg.ServerName = d.host
+		if d.opts.Authority != "" {
+			cfg.ServerName = d.opts.Authority
+			if host, _, err := net.SplitHostPort(d.opts.Authority); err == nil {
+				cfg.ServerName = host
+			}
+		}
+	}
+	conn := tls.Client(d.conn, cfg)
+	if err := conn.HandshakeContext(ctx); err != nil {
+		return "", err
+	}
+	d.conn = conn
+
+	state := conn.ConnectionState()
+	leaf := state.PeerCertificates[0]
+	sans := append([]string(nil), leaf.DNSNames...)
+	for _, ip := range leaf.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, uri := range leaf.URIs {
+		sans = append(sans, uri.String())
+	}
+	var chain []string
+	for _, cert := range state.PeerCertificates {
+		chain = append(chain, cert.Subject.String())
+	}
+	detail := fmt.Sprintf("%s %s, alpn=%q, chain [%s], issuer %q, SANs [%s], expires %s",
+		tls.VersionName(state.Version), tls.CipherSuiteName(state.CipherSuite), state.NegotiatedProtocol,
+		strings.Join(chain, " <- "), leaf.Issuer.String(), strings.Join(sans, ", "), leaf.NotAfter.Format(time.RFC3339))
+
+	if cfg.InsecureSkipVerify {
+		// tell what a verifying client would get
+		intermediates := x509.NewCertPool()
+		for _, cert := range state.PeerCertificates[1:] {
+			intermediates.AddCert(cert)
+		}
+		_, err := leaf.Verify(x509.VerifyOptions{DNSName: cfg.ServerName, Roots: cfg.RootCAs, Intermediates: intermediates})
+		if err != nil {
+			detail += fmt.Sprintf(", not verified (-insecureSkipVerify): %v", err)
+		} else {
+			detail += ", verified"
+		}
+	} else {
+		detail += ", verified"
+	}
+
+	if state.NegotiatedProtocol != http2.NextProtoTLS && !d.opts.HTTP1 {
+		return detail, fmt.Errorf("the server did not negotiate h2 with ALPN, gRPC needs HTTP/2")
+	}
+	return detail, nil
+}
+
+func (d *doctor) http2(ctx context.Context) (string, error) {
+	if d.opts.HTTP1 {
+		return "HTTP/1.1, -http1 is set", errSkipped
+	}
+	deadline, _ := ctx.Deadline()
+	d.conn.SetDeadline(deadline)
+	if _, err := io.WriteString(d.conn, http2.ClientPreface); err != nil {
+		return "", err
+	}
+	fr := http2.NewFramer(d.conn, d.conn)
+	if err := fr.WriteSettings(); err != nil {
+		return "", err
+	}
+	f, err := fr.ReadFrame()
+	if err != nil {
+		return "", fmt.Errorf("no HTTP/2 SETTINGS from the server, is it the right port and -tls setting ? %w", err)
+	}
+	settings, ok := f.(*http2.SettingsFrame)
+	if !ok {
+		return "", fmt.Errorf("got a %v frame instead of SETTINGS", f.Header().Type)
+	}
+	var values []string
+	settings.ForeachSetting(func(s http2.Setting) error {
+		values = append(values, fmt.Sprintf("%v=%d", s.ID, s.Val))
+		return nil
+	})
+	// the connection has done its job, gRPC opens its own
+	d.conn.Close()
+	d.conn = nil
+	return "server SETTINGS [" + strings.Join(values, ", ") + "]", nil
+}
+
+// outgoing adds the client name to ctx
+func (d *doctor) outgoing(ctx context.Context) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, NameHeader, d.name)
+}
+
+func (d *doctor) health(ctx context.Context) (string, error) {
+	if d.opts.Protocol != "" && d.opts.Protocol != ProtocolGRPC {
+		c, err := d.opts.ConnectGreeterClient(d.target)
+		if err != nil {
+			return "", err
+		}
+		d.c = c
+		return "not available over " + d.opts.Protocol, errSkipped
+	}
+
+	dialOpts, err := d.opts.DialOptions()
+	if err != nil {
+		return "", err
+	}
+	d.grpc, err = grpc.Dial(d.target, dialOpts...)
+	if err != nil {
+		return "", err
+	}
+	d.c = pb.NewGreeterClient(d.grpc)
+
+	r, err := healthpb.NewHealthClient(d.grpc).Check(d.outgoing(ctx), &healthpb.HealthCheckRequest{})
+	if status.Code(err) == codes.Unimplemented {
+		return "the server has no health service", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if r.Status != healthpb.HealthCheckResponse_SERVING {
+		return "", fmt.Errorf("the server is %v", r.Status)
+	}
+	return r.Status.String(), nil
+}
+
+func (d *doctor) unary(ctx context.Context) (string, error) {
+	r, err := d.c.SayHello(d.outgoing(ctx), &pb.HelloRequest{Name: d.name})
+	if err != nil {
+		return "", err
+	}
+	detail := fmt.Sprintf("%q", r.Message)
+	for _, hop := range r.Hops {
+		detail += ", via " + hop.Name
+	}
+	return detail, nil
+}
+
+func (d *doctor) stream(ctx context.Context) (string, error) {
+	start := time.Now()
+	stream, err := d.c.SayHelloStream(d.outgoing(ctx))
+	if err != nil {
+		return "", err
+	}
+	if err := stream.Send(&pb.HelloRequest{Name: "Ping " + d.name}); err != nil {
+		if _, err := stream.Recv(); err != nil && err != io.EOF {
+			return "", err
+		}
+		return "", errors.New("the server ended the stream")
+	}
+	if err := stream.CloseSend(); err != nil {
+		return "", err
+	}
+
+	// the server only answers with -reply, but always ends the stream after us
+	detail := "no reply, the server may run without -reply"
+	for {
+		r, err := stream.Recv()
+		if err == io.EOF {
+			return detail + ", closed cleanly", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		detail = fmt.Sprintf("%q after %v", r.Message, time.Since(start).Round(time.Microsecond))
+	}
+}
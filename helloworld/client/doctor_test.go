@@ -0,0 +1,141 @@
+package client_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prune998/goHelloGrpcStream/helloworld/client"
+	"github.com/prune998/goHelloGrpcStream/helloworld/server"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+// startGRPCServer starts a Greeter serving gRPC on an ephemeral port and returns its address
+func startGRPCServer(t *testing.T, opts server.Options) string {
+	t.Helper()
+
+	opts.Logger = logrus.New()
+	opts.Logger.SetOutput(io.Discard)
+	s, err := server.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	return lis.Addr().String()
+}
+
+// selfSigned returns a certificate for localhost
+func selfSigned(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "doctor"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// statuses returns the status of each layer
+func statuses(diag client.Diagnosis) string {
+	var s []string
+	for _, check := range diag {
+		s = append(s, check.Layer+"="+check.Status)
+	}
+	return strings.Join(s, " ")
+}
+
+func TestDiagnose(t *testing.T) {
+	opts := server.DefaultOptions()
+	opts.Reply = true
+	addr := startGRPCServer(t, opts)
+
+	diag := client.Options{}.Diagnose(context.Background(), addr, "doctor", 5*time.Second)
+	if diag.Failed() != nil {
+		t.Fatalf("got %v", diag)
+	}
+	want := "dns=ok tcp=ok tls=skipped http2=ok health=ok unary=ok stream=ok"
+	if got := statuses(diag); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if detail := diag[6].Detail; !strings.Contains(detail, `"Pong Ping doctor"`) {
+		t.Errorf("got stream detail %q", detail)
+	}
+}
+
+func TestDiagnoseTLS(t *testing.T) {
+	opts := server.DefaultOptions()
+	opts.TLSConfig = &tls.Config{Certificates: []tls.Certificate{selfSigned(t)}}
+	addr := startGRPCServer(t, opts)
+
+	diag := client.Options{TLS: true, InsecureSkipVerify: true}.Diagnose(context.Background(), addr, "doctor", 5*time.Second)
+	if diag.Failed() != nil {
+		t.Fatalf("got %v", diag)
+	}
+	detail := diag[2].Detail
+	for _, want := range []string{"TLS 1.3", `alpn="h2"`, "CN=doctor", "SANs [localhost, 127.0.0.1]", "not verified"} {
+		if !strings.Contains(detail, want) {
+			t.Errorf("tls detail %q has no %q", detail, want)
+		}
+	}
+
+	// the handshake fails when verifying the certificate
+	diag = client.Options{TLS: true}.Diagnose(context.Background(), addr, "doctor", 5*time.Second)
+	if failed := diag.Failed(); failed == nil || failed.Layer != client.LayerTLS {
+		t.Errorf("got %s", statuses(diag))
+	}
+}
+
+func TestDiagnoseFailures(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := lis.Addr().String()
+	lis.Close()
+
+	http1 := httptest.NewServer(http.NotFoundHandler())
+	defer http1.Close()
+
+	for _, test := range []struct {
+		target string
+		want   string
+	}{
+		{closed, "dns=ok tcp=failed tls=skipped http2=skipped health=skipped unary=skipped stream=skipped"},
+		{strings.TrimPrefix(http1.URL, "http://"), "dns=ok tcp=ok tls=skipped http2=failed health=skipped unary=skipped stream=skipped"},
+		{"nowhere.invalid:7788", "dns=failed tcp=skipped tls=skipped http2=skipped health=skipped unary=skipped stream=skipped"},
+	} {
+		diag := client.Options{}.Diagnose(context.Background(), test.target, "doctor", 2*time.Second)
+		if got := statuses(diag); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.target, got, test.want)
+		}
+	}
+}
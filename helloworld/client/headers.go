@@ -0,0 +1,101 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+)
+
+// ParseHeader splits a key=value header, the key being lowercased like gRPC metadata keys
+func ParseHeader(s string) (key, value string, err error) {
+	key, value, ok := strings.Cut(s, "=")
+	key = strings.ToLower(strings.TrimSpace(key))
+	switch {
+	case !ok || key == "":
+		return "", "", fmt.Errorf("header %q is not key=value", s)
+	case key == ":authority" || key == "host":
+		return "", "", fmt.Errorf("use -authority to set %s", key)
+	case strings.HasPrefix(key, ":"):
+		return "", "", fmt.Errorf("pseudo-header %s can not be set", key)
+	}
+	return key, value, nil
+}
+
+// Headers is a repeatable key=value flag, holding the metadata sent with every call
+type Headers metadata.MD
+
+func (h *Headers) String() string {
+	var pairs []string
+	for key, values := range *h {
+		for _, v := range values {
+			pairs = append(pairs, key+"="+v)
+		}
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (h *Headers) Set(s string) error {
+	key, value, err := ParseHeader(s)
+	if err != nil {
+		return err
+	}
+	if *h == nil {
+		*h = Headers{}
+	}
+	(*h)[key] = append((*h)[key], value)
+	return nil
+}
+
+// outgoing adds the headers of the options to the metadata of ctx
+func (o Options) outgoing(ctx context.Context) context.Context {
+	for key, values := range o.Headers {
+		for _, v := range values {
+			ctx = metadata.AppendToOutgoingContext(ctx, key, v)
+		}
+	}
+	return ctx
+}
+
+// headerInterceptors send the headers of the options with every call
+func (o Options) headerInterceptors() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			return invoker(o.outgoing(ctx), method, req, reply, cc, opts...)
+		}),
+		grpc.WithChainStreamInterceptor(func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			return streamer(o.outgoing(ctx), desc, cc, method, opts...)
+		}),
+	}
+}
+
+// sniCredentials hide their server name from gRPC, so the SNI can differ from the authority
+type sniCredentials struct {
+	credentials.TransportCredentials
+}
+
+func (c sniCredentials) Info() credentials.ProtocolInfo {
+	info := c.TransportCredentials.Info()
+	info.ServerName = ""
+	return info
+}
+
+func (c sniCredentials) Clone() credentials.TransportCredentials {
+	return sniCredentials{c.TransportCredentials.Clone()}
+}
+
+// hostTransport sends the requests with another Host, the HTTP/2 :authority
+type hostTransport struct {
+	http.RoundTripper
+	host string
+}
+
+func (t hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Host = t.host
+	return t.RoundTripper.RoundTrip(req)
+}
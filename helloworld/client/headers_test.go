@@ -0,0 +1,116 @@
+package client_test
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prune998/goHelloGrpcStream/helloworld/client"
+	pb "github.com/prune998/goHelloGrpcStream/helloworld/helloworld"
+	"github.com/prune998/goHelloGrpcStream/helloworld/server"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+func TestParseHeader(t *testing.T) {
+	for _, tc := range []struct {
+		in, key, value string
+		ok             bool
+	}{
+		{"x-route=canary", "x-route", "canary", true},
+		{"X-Tenant = a=b", "x-tenant", " a=b", true},
+		{"x-empty=", "x-empty", "", true},
+		{"x-route", "", "", false},
+		{"=value", "", "", false},
+		{":authority=example.com", "", "", false},
+		{"host=example.com", "", "", false},
+		{":path=/", "", "", false},
+	} {
+		key, value, err := client.ParseHeader(tc.in)
+		if (err == nil) != tc.ok || key != tc.key || value != tc.value {
+			t.Errorf("ParseHeader(%q) = %q, %q, %v", tc.in, key, value, err)
+		}
+	}
+}
+
+// echoed returns the values of the name metadata the server saw
+func echoed(echo *pb.Echo, name string) []string {
+	for _, h := range echo.GetMetadata() {
+		if h.Name == name {
+			return h.Values
+		}
+	}
+	return nil
+}
+
+func TestHeadersAuthoritySNI(t *testing.T) {
+	opts := server.DefaultOptions()
+	opts.Echo = true
+	opts.TLSConfig = &tls.Config{Certificates: []tls.Certificate{selfSigned(t)}}
+	addr := startGRPCServer(t, opts)
+
+	var headers client.Headers
+	for _, h := range []string{"x-route=canary", "x-tenant=a", "x-tenant=b"} {
+		if err := headers.Set(h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clientOpts := client.Options{
+		TLS:                true,
+		InsecureSkipVerify: true,
+		Headers:            headers,
+		Authority:          "greeter.example.com",
+		SNI:                "sni.example.com",
+	}
+	dialOpts, err := clientOpts.DialOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := grpc.Dial(addr, dialOpts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	r, err := pb.NewGreeterClient(conn).SayHello(context.Background(), &pb.HelloRequest{Name: "headers"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := echoed(r.Echo, ":authority"); len(got) != 1 || got[0] != "greeter.example.com" {
+		t.Errorf("got authority %v", got)
+	}
+	if got := echoed(r.Echo, "x-route"); len(got) != 1 || got[0] != "canary" {
+		t.Errorf("got x-route %v", got)
+	}
+	if got := echoed(r.Echo, "x-tenant"); len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Errorf("got x-tenant %v", got)
+	}
+	if r.Echo.ServerName != "sni.example.com" {
+		t.Errorf("got SNI %q", r.Echo.ServerName)
+	}
+}
+
+func TestHeadersConnect(t *testing.T) {
+	// the mesh routes on the HTTP headers, which the server does not all pass to its gRPC handlers
+	got := make(chan *http.Request, 1)
+	web := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- r
+		http.NotFound(w, r)
+	}))
+	defer web.Close()
+
+	var headers client.Headers
+	headers.Set("x-route=canary")
+	c, err := client.Options{Protocol: client.ProtocolConnect, HTTP1: true, Headers: headers, Authority: "greeter.example.com"}.
+		ConnectGreeterClient(strings.TrimPrefix(web.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SayHello(context.Background(), &pb.HelloRequest{Name: "headers"})
+	r := <-got
+	if r.Host != "greeter.example.com" || r.Header.Get("x-route") != "canary" {
+		t.Errorf("got host %q, x-route %q", r.Host, r.Header.Get("x-route"))
+	}
+}
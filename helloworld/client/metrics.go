@@ -0,0 +1,21 @@
+package client
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	PromUnaryCallsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "greeter_client_unary_calls_counter",
+		Help: "unary calls made, by final status code",
+	}, []string{"method", "code"})
+
+	PromUnaryAttemptsHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "greeter_client_unary_attempts",
+		Help:    "attempts made per unary call, retries and hedged calls included",
+		Buckets: []float64{1, 2, 3, 4, 5},
+	}, []string{"method", "code"})
+)
+
+func init() {
+	prometheus.MustRegister(PromUnaryCallsCounter)
+	prometheus.MustRegister(PromUnaryAttemptsHistogram)
+}
@@ -0,0 +1,58 @@
+package client
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/pires/go-proxyproto"
+)
+
+// PROXY protocol versions of Options.ProxyHeader
+const (
+	ProxyHeaderV1 = "v1"
+	ProxyHeaderV2 = "v2"
+)
+
+// validProxyHeader checks the PROXY header version, and the source address
+func (o Options) validProxyHeader() error {
+	switch o.ProxyHeader {
+	case "":
+		return nil
+	case ProxyHeaderV1, ProxyHeaderV2:
+	default:
+		return fmt.Errorf("unknown PROXY header %q, use %s or %s", o.ProxyHeader, ProxyHeaderV1, ProxyHeaderV2)
+	}
+	if o.ProxySource != "" {
+		if _, err := net.ResolveTCPAddr("tcp", o.ProxySource); err != nil {
+			return fmt.Errorf("invalid PROXY source: %w", err)
+		}
+	}
+	return nil
+}
+
+// sendProxyHeader writes a PROXY protocol header at the start of conn, like a TCP load balancer does.
+// The source is o.ProxySource, or the local address of conn.
+func (o Options) sendProxyHeader(conn net.Conn) error {
+	var version byte = 2
+	if o.ProxyHeader == ProxyHeaderV1 {
+		version = 1
+	}
+
+	src, dst := conn.LocalAddr(), conn.RemoteAddr()
+	if o.ProxySource != "" {
+		addr, err := net.ResolveTCPAddr("tcp", o.ProxySource)
+		if err != nil {
+			return fmt.Errorf("invalid PROXY source: %w", err)
+		}
+		src = addr
+		if _, ok := dst.(*net.TCPAddr); !ok {
+			// over a unix socket, the destination has to be of the same family as the source
+			dst = &net.TCPAddr{IP: net.IPv4zero}
+			if addr.IP.To4() == nil {
+				dst = &net.TCPAddr{IP: net.IPv6zero}
+			}
+		}
+	}
+	_, err := proxyproto.HeaderProxyFromAddrs(version, src, dst).WriteTo(conn)
+	return err
+}
@@ -0,0 +1,249 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// RetryOptions configures how unary SayHello calls are retried or hedged.
+// Retries go through the gRPC service config, so grpc-go caps MaxAttempts to 5.
+// Hedging is done by the client itself as grpc-go does not implement it.
+type RetryOptions struct {
+	// MaxAttempts is the maximum number of attempts of a call, 1 or less to disable retries
+	MaxAttempts int
+	// InitialBackoff, MaxBackoff and BackoffMultiplier shape the delay between retries
+	InitialBackoff    time.Duration
+	MaxBackoff        time.Duration
+	BackoffMultiplier float64
+	// RetryableCodes are the status codes triggering a retry, or a new hedged attempt
+	RetryableCodes []codes.Code
+	// HedgingDelay enables hedging: a new attempt is started every HedgingDelay until
+	// one succeeds or MaxAttempts are in flight. It replaces the retry policy.
+	HedgingDelay time.Duration
+}
+
+// serviceConfig returns the service config holding the retry policy of SayHello
+func (r RetryOptions) serviceConfig() string {
+	if r.MaxAttempts <= 1 || r.HedgingDelay > 0 {
+		return ""
+	}
+	initial, max, multiplier := r.InitialBackoff, r.MaxBackoff, r.BackoffMultiplier
+	if initial <= 0 {
+		initial = 100 * time.Millisecond
+	}
+	if max < initial {
+		max = initial
+	}
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+	retryable := r.RetryableCodes
+	if len(retryable) == 0 {
+		retryable = []codes.Code{codes.Unavailable}
+	}
+
+	sc := map[string]interface{}{
+		"methodConfig": []interface{}{map[string]interface{}{
+			"name": []interface{}{map[string]string{"service": "helloworld.Greeter", "method": "SayHello"}},
+			"retryPolicy": map[string]interface{}{
+				"maxAttempts":          r.MaxAttempts,
+				"initialBackoff":       durationString(initial),
+				"maxBackoff":           durationString(max),
+				"backoffMultiplier":    multiplier,
+				"retryableStatusCodes": retryable,
+			},
+		}},
+	}
+	b, _ := json.Marshal(sc)
+	return string(b)
+}
+
+// durationString formats d the way the service config expects it, like 0.1s
+func durationString(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64) + "s"
+}
+
+// dialOptions returns the service config, attempts counter and hedging interceptor
+func (r RetryOptions) dialOptions() []grpc.DialOption {
+	opts := []grpc.DialOption{
+		grpc.WithStatsHandler(attemptsHandler{}),
+		grpc.WithChainUnaryInterceptor(unaryMetricsInterceptor),
+	}
+	if sc := r.serviceConfig(); sc != "" {
+		opts = append(opts, grpc.WithDefaultServiceConfig(sc))
+	}
+	if r.HedgingDelay > 0 && r.MaxAttempts > 1 {
+		opts = append(opts, grpc.WithChainUnaryInterceptor(hedgingInterceptor(r)))
+	}
+	return opts
+}
+
+// ParseCodes parses a comma separated list of status codes, like UNAVAILABLE,DEADLINE_EXCEEDED
+func ParseCodes(s string) ([]codes.Code, error) {
+	var list []codes.Code
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		var c codes.Code
+		if err := c.UnmarshalJSON([]byte(strconv.Quote(name))); err != nil {
+			return nil, fmt.Errorf("invalid status code %q", name)
+		}
+		list = append(list, c)
+	}
+	return list, nil
+}
+
+// Attempts counts the attempts made by the calls sharing a context
+type Attempts struct {
+	n int32
+}
+
+// Count returns the number of attempts made so far
+func (a *Attempts) Count() int {
+	return int(atomic.LoadInt32(&a.n))
+}
+
+type attemptsKey struct{}
+
+// CountAttempts returns a context counting the attempts, retries and hedged calls included,
+// made by the calls using it. It reuses the counter of ctx if there is one.
+func CountAttempts(ctx context.Context) (context.Context, *Attempts) {
+	if a, ok := ctx.Value(attemptsKey{}).(*Attempts); ok {
+		return ctx, a
+	}
+	a := &Attempts{}
+	return context.WithValue(ctx, attemptsKey{}, a), a
+}
+
+// attemptsHandler is a stats.Handler counting each attempt, as grpc-go reports them separately
+type attemptsHandler struct{}
+
+func (attemptsHandler) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (attemptsHandler) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	if b, ok := s.(*stats.Begin); ok && b.Client {
+		countAttempt(ctx)
+	}
+}
+
+// countAttempt adds an attempt to the counter of ctx, if any
+func countAttempt(ctx context.Context) {
+	if a, ok := ctx.Value(attemptsKey{}).(*Attempts); ok {
+		atomic.AddInt32(&a.n, 1)
+	}
+}
+
+func (attemptsHandler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (attemptsHandler) HandleConn(context.Context, stats.ConnStats) {}
+
+// unaryMetricsInterceptor reports the attempts and outcome of each unary call
+func unaryMetricsInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	ctx, attempts := CountAttempts(ctx)
+	before := attempts.Count()
+	err := invoker(ctx, method, req, reply, cc, opts...)
+
+	code := status.Code(err).String()
+	PromUnaryCallsCounter.WithLabelValues(method, code).Inc()
+	PromUnaryAttemptsHistogram.WithLabelValues(method, code).Observe(float64(attempts.Count() - before))
+	return err
+}
+
+// hedgingInterceptor starts a new attempt every HedgingDelay, or as soon as an attempt
+// fails with a retryable code, and returns the first success.
+// Any other error is returned right away and cancels the attempts in flight.
+func hedgingInterceptor(r RetryOptions) grpc.UnaryClientInterceptor {
+	retryable := map[codes.Code]bool{}
+	for _, c := range r.RetryableCodes {
+		retryable[c] = true
+	}
+	if len(retryable) == 0 {
+		retryable[codes.Unavailable] = true
+	}
+
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		type result struct {
+			reply proto.Message
+			err   error
+		}
+		results := make(chan result, r.MaxAttempts)
+		started, pending := 0, 0
+		start := func() {
+			started++
+			pending++
+			attemptReply := proto.Clone(reply.(proto.Message))
+			proto.Reset(attemptReply)
+			go func() {
+				err := invoker(ctx, method, req, attemptReply, cc, opts...)
+				results <- result{reply: attemptReply, err: err}
+			}()
+		}
+
+		start()
+		timer := time.NewTimer(r.HedgingDelay)
+		defer timer.Stop()
+		var lastErr error
+		for {
+			select {
+			case res := <-results:
+				pending--
+				if res.err == nil {
+					proto.Reset(reply.(proto.Message))
+					proto.Merge(reply.(proto.Message), res.reply)
+					return nil
+				}
+				lastErr = res.err
+				if !retryable[status.Code(res.err)] {
+					return res.err
+				}
+				if started < r.MaxAttempts {
+					start()
+					resetTimer(timer, r.HedgingDelay)
+				} else if pending == 0 {
+					return lastErr
+				}
+			case <-timer.C:
+				if started < r.MaxAttempts {
+					start()
+					timer.Reset(r.HedgingDelay)
+				}
+			case <-ctx.Done():
+				if lastErr != nil {
+					return lastErr
+				}
+				return status.FromContextError(ctx.Err()).Err()
+			}
+		}
+	}
+}
+
+// resetTimer restarts t, dropping a tick that was not consumed
+func resetTimer(t *time.Timer, d time.Duration) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+	t.Reset(d)
+}
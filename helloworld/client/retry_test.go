@@ -0,0 +1,111 @@
+package client_test
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prune998/goHelloGrpcStream/helloworld/client"
+	pb "github.com/prune998/goHelloGrpcStream/helloworld/helloworld"
+	"github.com/prune998/goHelloGrpcStream/helloworld/server"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// dialServer starts a Greeter injecting faults and connects to it with the client options
+func dialServer(t *testing.T, faults server.Faults, opts client.Options) pb.GreeterClient {
+	t.Helper()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	serverOpts := server.DefaultOptions()
+	serverOpts.Logger = logger
+	serverOpts.Faults = faults
+	s, err := server.New(serverOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis := bufconn.Listen(1024 * 1024)
+	go s.Serve(lis)
+
+	dialOpts, err := opts.DialOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dialOpts = append(dialOpts, grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return lis.DialContext(ctx)
+	}))
+	conn, err := grpc.Dial("bufnet", dialOpts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		s.Stop()
+	})
+	return pb.NewGreeterClient(conn)
+}
+
+func TestRetries(t *testing.T) {
+	retry := client.RetryOptions{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+		RetryableCodes: []codes.Code{codes.Unavailable},
+	}
+
+	for _, tc := range []struct {
+		name     string
+		faults   server.Faults
+		want     codes.Code
+		attempts int
+	}{
+		{"success", server.Faults{}, codes.OK, 1},
+		{"retryable", server.Faults{ErrorRate: 1, ErrorCode: codes.Unavailable}, codes.Unavailable, 3},
+		{"not retryable", server.Faults{ErrorRate: 1, ErrorCode: codes.Internal}, codes.Internal, 1},
+	} {
+		c := dialServer(t, tc.faults, client.Options{Retry: retry})
+		ctx, attempts := client.CountAttempts(context.Background())
+		_, err := c.SayHello(ctx, &pb.HelloRequest{Name: "retry"})
+		if code := status.Code(err); code != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, code, tc.want)
+		}
+		if got := attempts.Count(); got != tc.attempts {
+			t.Errorf("%s: made %d attempts, want %d", tc.name, got, tc.attempts)
+		}
+	}
+}
+
+func TestHedging(t *testing.T) {
+	retry := client.RetryOptions{MaxAttempts: 3, HedgingDelay: 20 * time.Millisecond}
+	c := dialServer(t, server.Faults{Delay: 200 * time.Millisecond}, client.Options{Retry: retry})
+
+	ctx, attempts := client.CountAttempts(context.Background())
+	r, err := c.SayHello(ctx, &pb.HelloRequest{Name: "hedge"})
+	if err != nil {
+		t.Fatalf("SayHello failed: %v", err)
+	}
+	if r.Message == "" {
+		t.Error("got an empty reply")
+	}
+	if got := attempts.Count(); got != 3 {
+		t.Errorf("made %d attempts, want 3", got)
+	}
+}
+
+func TestHedgingDeadline(t *testing.T) {
+	retry := client.RetryOptions{MaxAttempts: 2, HedgingDelay: 10 * time.Millisecond}
+	c := dialServer(t, server.Faults{Delay: time.Second}, client.Options{Retry: retry})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := c.SayHello(ctx, &pb.HelloRequest{Name: "hedge"})
+	if code := status.Code(err); code != codes.DeadlineExceeded {
+		t.Errorf("got %v, want DeadlineExceeded", code)
+	}
+}
@@ -0,0 +1,50 @@
+package client
+
+import (
+	"net"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// UnixScheme prefixes the targets that are unix sockets, like unix:///run/greeter.sock.
+// gRPC understands them natively, the HTTP based protocols need Options.DialContext and HTTPHost.
+const UnixScheme = "unix://"
+
+// UnixSocket returns the socket path of a unix:// target, or "" when target is a host:port
+func UnixSocket(target string) string {
+	if !strings.HasPrefix(target, UnixScheme) {
+		return ""
+	}
+	return strings.TrimPrefix(target, UnixScheme)
+}
+
+// HTTPHost returns the host:port to use in the URLs of target.
+// A unix socket is called localhost, like gRPC does, which is also the TLS server name.
+func HTTPHost(target string) string {
+	if UnixSocket(target) != "" {
+		return "localhost"
+	}
+	return target
+}
+
+// DialContext returns a dialer connecting to the socket of a unix:// target, whatever the address it is given,
+// or to the address otherwise. It sends the PROXY header of the options, if any.
+func (o Options) DialContext(target string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	socket := UnixSocket(target)
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if socket != "" {
+			network, addr = "unix", socket
+		}
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, network, addr)
+		if err != nil || o.ProxyHeader == "" {
+			return conn, err
+		}
+		if err := o.sendProxyHeader(conn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+}
@@ -0,0 +1,159 @@
+// Package compression registers the gRPC compressors supported by the clients and the server:
+// gzip, from grpc-go, plus zstd and snappy.
+package compression
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/encoding/gzip"
+)
+
+// Names of the registered compressors
+const (
+	Gzip   = gzip.Name
+	Zstd   = "zstd"
+	Snappy = "snappy"
+)
+
+// Names lists all the registered compressors
+var Names = []string{Gzip, Zstd, Snappy}
+
+func init() {
+	encoding.RegisterCompressor(newZstd())
+	encoding.RegisterCompressor(newSnappy())
+}
+
+// Validate returns an error if name is not a registered compressor. An empty name means no compression.
+func Validate(name string) error {
+	if name == "" || name == "identity" || encoding.GetCompressor(name) != nil {
+		return nil
+	}
+	return fmt.Errorf("unknown compressor %q, use one of %v", name, Names)
+}
+
+// zstdCompressor pools the encoders and decoders as they are expensive to create
+type zstdCompressor struct {
+	encoders sync.Pool
+	decoders sync.Pool
+}
+
+func newZstd() *zstdCompressor {
+	c := &zstdCompressor{}
+	c.encoders.New = func() interface{} {
+		enc, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1), zstd.WithEncoderLevel(zstd.SpeedDefault))
+		return &zstdWriter{Encoder: enc, pool: &c.encoders}
+	}
+	return c
+}
+
+func (c *zstdCompressor) Name() string {
+	return Zstd
+}
+
+func (c *zstdCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	z := c.encoders.Get().(*zstdWriter)
+	z.Reset(w)
+	return z, nil
+}
+
+func (c *zstdCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	z, inPool := c.decoders.Get().(*zstdReader)
+	if !inPool {
+		dec, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return &zstdReader{Decoder: dec, pool: &c.decoders}, nil
+	}
+	if err := z.Reset(r); err != nil {
+		c.decoders.Put(z)
+		return nil, err
+	}
+	return z, nil
+}
+
+type zstdWriter struct {
+	*zstd.Encoder
+	pool *sync.Pool
+}
+
+func (z *zstdWriter) Close() error {
+	defer z.pool.Put(z)
+	return z.Encoder.Close()
+}
+
+type zstdReader struct {
+	*zstd.Decoder
+	pool *sync.Pool
+}
+
+// Read gives the decoder back to the pool once the message is fully read
+func (z *zstdReader) Read(p []byte) (int, error) {
+	n, err := z.Decoder.Read(p)
+	if err == io.EOF {
+		z.pool.Put(z)
+	}
+	return n, err
+}
+
+// snappyCompressor uses the snappy framing format, so messages can be streamed
+type snappyCompressor struct {
+	writers sync.Pool
+	readers sync.Pool
+}
+
+func newSnappy() *snappyCompressor {
+	c := &snappyCompressor{}
+	c.writers.New = func() interface{} {
+		return &snappyWriter{Writer: snappy.NewBufferedWriter(nil), pool: &c.writers}
+	}
+	c.readers.New = func() interface{} {
+		return &snappyReader{Reader: snappy.NewReader(nil), pool: &c.readers}
+	}
+	return c
+}
+
+func (c *snappyCompressor) Name() string {
+	return Snappy
+}
+
+func (c *snappyCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	s := c.writers.Get().(*snappyWriter)
+	s.Reset(w)
+	return s, nil
+}
+
+func (c *snappyCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	s := c.readers.Get().(*snappyReader)
+	s.Reset(r)
+	return s, nil
+}
+
+type snappyWriter struct {
+	*snappy.Writer
+	pool *sync.Pool
+}
+
+func (s *snappyWriter) Close() error {
+	defer s.pool.Put(s)
+	return s.Writer.Close()
+}
+
+type snappyReader struct {
+	*snappy.Reader
+	pool *sync.Pool
+}
+
+// Read gives the reader back to the pool once the message is fully read
+func (s *snappyReader) Read(p []byte) (int, error) {
+	n, err := s.Reader.Read(p)
+	if err == io.EOF {
+		s.pool.Put(s)
+	}
+	return n, err
+}
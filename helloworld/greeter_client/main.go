@@ -19,29 +19,69 @@
 package main
 
 import (
-	"crypto/tls"
+	"fmt"
 	"io"
+	"net/http"
 	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	kitlog "github.com/go-kit/log"
 	"github.com/namsral/flag"
+	"github.com/prune998/goHelloGrpcStream/helloworld/client"
 	pb "github.com/prune998/goHelloGrpcStream/helloworld/helloworld"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 var (
-	server             = flag.String("server", "localhost:7788", "Greeter Server URL")
+	server             = flag.String("server", "localhost:7788", "Greeter Server URL, the HTTP port for grpcweb and connect, or unix:///path/to/socket")
 	name               = flag.String("name", "world", "name of the client (will be displayed in the server)")
 	unary              = flag.Bool("unary", false, "open unary HTTP/2 connextion")
 	stream             = flag.Bool("stream", false, "open stream HTTP/2 connection")
+	serverStream       = flag.Bool("serverstream", false, "open a server stream asking for -count greetings every -interval")
+	protocol           = flag.String("protocol", client.ProtocolGRPC, "protocol to talk to the server: grpc, grpcweb or connect")
+	http1              = flag.Bool("http1", false, "use HTTP/1.1 with grpcweb and connect, -stream is not available")
 	withTLS            = flag.Bool("tls", false, "whether to use TLS")
 	insecureSkipVerify = flag.Bool("insecureSkipVerify", true, "whether to ignore security checks")
+	caCert             = flag.String("cacert", "", "CA file to verify the server certificate")
+	cert               = flag.String("cert", "", "client certificate file for mTLS")
+	key                = flag.String("key", "", "client key file for mTLS")
+	apiKey             = flag.String("apikey", "", "API key sent with each call")
+	token              = flag.String("token", "", "bearer token (JWT) sent with each call")
+	tokenFile          = flag.String("tokenfile", "", "file holding the bearer token, read before each call")
+	count              = flag.Int("count", 1, "number of unary calls to make, or of greetings to ask with -serverstream")
+	interval           = flag.Duration("interval", time.Second, "delay between unary calls or server stream greetings")
+	timeout            = flag.Duration("timeout", 0, "deadline of each unary call, retries included, 0 for none")
+	retries            = flag.Int("retries", 1, "maximum attempts of each unary call (max 5), 1 to disable retries")
+	retryBackoff       = flag.Duration("retrybackoff", 100*time.Millisecond, "initial backoff between retries")
+	retryMaxBackoff    = flag.Duration("retrymaxbackoff", time.Second, "maximum backoff between retries")
+	retryMultiplier    = flag.Float64("retrymultiplier", 2, "backoff multiplier between retries")
+	retryCodes         = flag.String("retrycodes", "UNAVAILABLE", "comma separated list of status codes to retry")
+	hedgingDelay       = flag.Duration("hedgingdelay", 0, "hedge unary calls, sending a new attempt after this delay, up to -retries attempts")
+	httpPort           = flag.String("httpport", "", "port to bind for HTTP metrics, disabled when empty")
+	compressor         = flag.String("compression", "", "compressor used by every call: gzip, zstd or snappy")
+	proxyHeader        = flag.String("proxyheader", "", "send a PROXY protocol header, v1 or v2, as a TCP load balancer does")
+	proxySource        = flag.String("proxysource", "", "client address announced in the PROXY header, the local address when empty")
+	doctor             = flag.Bool("doctor", false, "check the connection to the server layer by layer, from DNS to a stream round trip, and exit")
+	doctorTimeout      = flag.Duration("doctortimeout", 5*time.Second, "time each layer has to succeed with -doctor")
+	authority          = flag.String("authority", "", "override the :authority (Host) of the calls, the -server address by default")
+	sni                = flag.String("sni", "", "override the TLS server name, the -authority or -server host by default")
 )
 
+// headers are the -header flags, which can be repeated
+var headers client.Headers
+
+func init() {
+	flag.Var(&headers, "header", "metadata key=value sent with every call, can be repeated")
+}
+
 func main() {
 	flag.Parse()
 
@@ -49,45 +89,100 @@ func main() {
 	logger := kitlog.NewJSONLogger(kitlog.NewSyncWriter(os.Stdout))
 	logger = kitlog.With(logger, "application", "greeter_server", "ts", kitlog.DefaultTimestampUTC, "caller", kitlog.DefaultCaller)
 
-	// Setup gRPC options and TLS
+	// Setup gRPC options, TLS and credentials
 	grpcOpts := []grpc.DialOption{
 		grpc.WithUnaryInterceptor(grpc_prometheus.UnaryClientInterceptor),
 		grpc.WithStreamInterceptor(grpc_prometheus.StreamClientInterceptor),
 	}
+	retryableCodes, err := client.ParseCodes(*retryCodes)
+	if err != nil {
+		logger.Log("msg", "invalid retry codes", "err", err)
+		os.Exit(1)
+	}
+	clientOpts := client.Options{
+		TLS:                *withTLS,
+		InsecureSkipVerify: *insecureSkipVerify,
+		CAFile:             *caCert,
+		CertFile:           *cert,
+		KeyFile:            *key,
+		APIKey:             *apiKey,
+		Token:              *token,
+		TokenFile:          *tokenFile,
+		Retry: client.RetryOptions{
+			MaxAttempts:       *retries,
+			InitialBackoff:    *retryBackoff,
+			MaxBackoff:        *retryMaxBackoff,
+			BackoffMultiplier: *retryMultiplier,
+			RetryableCodes:    retryableCodes,
+			HedgingDelay:      *hedgingDelay,
+		},
+		Compression: *compressor,
+		Protocol:    *protocol,
+		ProxyHeader: *proxyHeader,
+		ProxySource: *proxySource,
+		HTTP1:       *http1,
+		Headers:     headers,
+		Authority:   *authority,
+		SNI:         *sni,
+	}
 
-	if *withTLS {
-		creds := credentials.NewTLS(&tls.Config{InsecureSkipVerify: *insecureSkipVerify})
-		grpcOpts = append(grpcOpts, grpc.WithTransportCredentials(creds))
+	if *doctor {
+		if !diagnose(clientOpts) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	var c pb.GreeterClient
+	if *protocol == client.ProtocolGRPC {
+		dialOpts, err := clientOpts.DialOptions()
+		if err != nil {
+			logger.Log("msg", "invalid connection options", "err", err)
+			os.Exit(1)
+		}
+		grpcOpts = append(grpcOpts, dialOpts...)
+
+		// Set up a connection to the server.
+		conn, err := grpc.Dial(*server, grpcOpts...)
+		if err != nil {
+			logger.Log("msg", "cant connect to server, run with -doctor to find the failing layer", "err", err)
+			os.Exit(1)
+		}
+		defer conn.Close()
+		c = pb.NewGreeterClient(conn)
 	} else {
-		grpcOpts = append(grpcOpts, grpc.WithInsecure())
+		c, err = clientOpts.ConnectGreeterClient(*server)
+		if err != nil {
+			logger.Log("msg", "invalid connection options", "err", err)
+			os.Exit(1)
+		}
 	}
+	logger = kitlog.With(logger, "protocol", *protocol)
 
-	// Set up a connection to the server.
-	conn, err := grpc.Dial(*server, grpcOpts...)
-	if err != nil {
-		logger.Log("msg", "cant connect to server", "err", err)
+	// prometheus metrics
+	if *httpPort != "" {
+		http.Handle("/metrics", promhttp.Handler())
+		go func() {
+			logger.Log("msg", fmt.Sprintf("listening HTTP (metrics) on %v", *httpPort))
+			logger.Log("err", http.ListenAndServe(fmt.Sprintf(":%s", *httpPort), nil))
+		}()
 	}
-	defer conn.Close()
-	c := pb.NewGreeterClient(conn)
 
 	if *unary {
 		logger.Log("msg", "opening unary connection")
-		// Contact the server and print out its response.
-		r, err := c.SayHello(context.Background(), &pb.HelloRequest{Name: *name})
-		if err != nil {
-			logger.Log("msg", "could not greet server", "err", err)
-		}
-		logger.Log("msg", "Received Greeting: "+r.Message)
+		sayHello(logger, c)
 	}
 	if *stream {
 		// request for the Stream
 		logger.Log("msg", "opening Stream connection")
-		stream, err := c.SayHelloStream(context.Background())
+		ctx := metadata.AppendToOutgoingContext(context.Background(), client.NameHeader, *name)
+		stream, err := c.SayHelloStream(ctx)
 		if err != nil {
 			logger.Log("msg", "could not greet server using Streams", "err", err)
+			os.Exit(1)
 		}
 		// send a message in the stream
-		err = stream.SendMsg(&pb.HelloReply{Message: "Ping Client"})
+		err = stream.Send(&pb.HelloRequest{Name: "Ping " + *name, Seq: 1})
 		if err != nil {
 			logger.Log("msg", "error while sending ping to server", "err", err)
 			os.Exit(1)
@@ -103,8 +198,151 @@ func main() {
 				logger.Log("msg", "got error from server", "err", err)
 				break
 			}
-			logger.Log("msg", msg.Message)
+			withReply(logger, msg).Log("msg", msg.Message, "seq", msg.Seq)
+			printEcho(os.Stdout, msg.Echo)
 		}
 	}
+	if *serverStream {
+		logger.Log("msg", "opening server stream")
+		sayHelloServerStream(logger, c)
+	}
 	logger.Log("msg", "done testing gRPC connections")
 }
+
+// withReply adds the backend which answered and the relays the reply went through to the logs, from the server to us
+func withReply(logger kitlog.Logger, r *pb.HelloReply) kitlog.Logger {
+	if b := r.Backend; b != nil {
+		logger = kitlog.With(logger, "backend", b.Pod, "node", b.Node, "zone", b.Zone, "version", b.Version)
+	}
+	if len(r.Hops) == 0 {
+		return logger
+	}
+	hops := make([]string, 0, len(r.Hops))
+	for _, h := range r.Hops {
+		hop := h.Name + " (from " + h.Peer
+		if h.DurationUs > 0 {
+			hop += ", " + (time.Duration(h.DurationUs) * time.Microsecond).String()
+		}
+		hops = append(hops, hop+")")
+	}
+	return kitlog.With(logger, "hops", strings.Join(hops, " -> "))
+}
+
+// printEcho prints what the server saw of the call, when it runs with -echo
+func printEcho(w io.Writer, echo *pb.Echo) {
+	if echo == nil {
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "the server saw:")
+	fmt.Fprintf(tw, "  peer\t%s\n", echo.Peer)
+	if echo.Identity != "" {
+		fmt.Fprintf(tw, "  identity\t%s\n", echo.Identity)
+	}
+	fmt.Fprintf(tw, "  security\t%s\n", echo.AuthType)
+	if echo.TlsVersion != "" {
+		fmt.Fprintf(tw, "  tls\t%s %s, sni %q\n", echo.TlsVersion, echo.CipherSuite, echo.ServerName)
+	}
+	if echo.ClientSubject != "" {
+		fmt.Fprintf(tw, "  client certificate\t%s, SANs [%s]\n", echo.ClientSubject, strings.Join(echo.ClientSans, ", "))
+	}
+	fmt.Fprintln(tw, "  metadata")
+	for _, h := range echo.Metadata {
+		for _, v := range h.Values {
+			fmt.Fprintf(tw, "    %s\t%s\n", h.Name, v)
+		}
+	}
+	tw.Flush()
+}
+
+// sayHelloServerStream asks for -count greetings every -interval and logs them as they come
+func sayHelloServerStream(logger kitlog.Logger, c pb.GreeterClient) {
+	ctx := metadata.AppendToOutgoingContext(context.Background(), client.NameHeader, *name)
+	stream, err := c.SayHelloServerStream(ctx, &pb.HelloStreamRequest{
+		Name:       *name,
+		Count:      int32(*count),
+		IntervalMs: int32(*interval / time.Millisecond),
+	})
+	if err != nil {
+		logger.Log("msg", "could not open the server stream", "err", err, "code", status.Code(err))
+		os.Exit(1)
+	}
+	start := time.Now()
+	received := 0
+	for {
+		msg, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			logger.Log("msg", "got error from server", "err", err, "code", status.Code(err))
+			break
+		}
+		received++
+		withReply(logger, msg).Log("msg", msg.Message, "elapsed", time.Since(start))
+		printEcho(os.Stdout, msg.Echo)
+	}
+	logger.Log("msg", "server stream done", "greetings", received, "duration", time.Since(start))
+}
+
+// sayHello makes -count unary calls and logs the attempts and outcome of each of them
+func sayHello(logger kitlog.Logger, c pb.GreeterClient) {
+	outcomes := map[string]int{}
+	totalAttempts := 0
+	for i := 0; i < *count; i++ {
+		if i > 0 {
+			time.Sleep(*interval)
+		}
+
+		ctx, cancel := context.Background(), context.CancelFunc(func() {})
+		if *timeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, *timeout)
+		}
+		ctx, attempts := client.CountAttempts(ctx)
+		start := time.Now()
+
+		// Contact the server and print out its response.
+		r, err := c.SayHello(ctx, &pb.HelloRequest{Name: *name})
+		cancel()
+		code := status.Code(err).String()
+		outcomes[code]++
+		totalAttempts += attempts.Count()
+		if err != nil {
+			logger.Log("msg", "could not greet server", "err", err, "code", code, "attempts", attempts.Count(), "duration", time.Since(start))
+			continue
+		}
+		withReply(logger, r).Log("msg", "Received Greeting: "+r.Message, "code", code, "attempts", attempts.Count(), "duration", time.Since(start))
+		printEcho(os.Stdout, r.Echo)
+	}
+
+	if *count > 1 {
+		logger.Log("msg", "unary calls summary", "calls", *count, "attempts", totalAttempts, "outcomes", fmt.Sprint(outcomes))
+	}
+}
+
+// diagnose prints the checks of each layer and returns false if one failed
+func diagnose(opts client.Options) bool {
+	diag := opts.Diagnose(context.Background(), *server, *name, *doctorTimeout)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "LAYER\tSTATUS\tTIME\tDETAIL")
+	for _, check := range diag {
+		detail := check.Detail
+		if check.Err != nil {
+			detail = strings.TrimPrefix(detail+", "+check.Err.Error(), ", ")
+		}
+		duration := ""
+		if check.Status != client.CheckSkipped {
+			duration = check.Duration.Round(time.Microsecond).String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", check.Layer, check.Status, duration, detail)
+	}
+	w.Flush()
+
+	if failed := diag.Failed(); failed != nil {
+		fmt.Printf("\n%s failed: %v\n", failed.Layer, failed.Err)
+		return false
+	}
+	fmt.Printf("\nall layers ok\n")
+	return true
+}
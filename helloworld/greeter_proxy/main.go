@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/namsral/flag"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prune998/goHelloGrpcStream/helloworld/client"
+	"github.com/prune998/goHelloGrpcStream/helloworld/proxy"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	debug    = flag.Bool("debug", false, "display debugs")
+	grpcPort = flag.String("grpcport", "7790", "port to bind for the proxied gRPC")
+	httpPort = flag.String("httpport", "7791", "port to bind for HTTP metrics")
+	upstream = flag.String("upstream", "localhost:7788", "gRPC server to forward the calls to")
+	version  = "no version set"
+
+	upstreamTLS        = flag.Bool("upstreamtls", false, "use TLS to the upstream")
+	upstreamCACert     = flag.String("upstreamcacert", "", "CA file to verify the upstream certificate")
+	upstreamCert       = flag.String("upstreamcert", "", "client certificate file for mTLS to the upstream")
+	upstreamKey        = flag.String("upstreamkey", "", "client key file for mTLS to the upstream")
+	upstreamSkipVerify = flag.Bool("upstreaminsecureskipverify", false, "do not verify the upstream certificate")
+
+	requestTimeout       = flag.Duration("requesttimeout", 0, "end the calls, streams included, not done within this duration with UNAVAILABLE, 0 for none")
+	maxStreamDuration    = flag.Duration("maxstreamduration", 0, "end the calls, streams included, after this duration with DEADLINE_EXCEEDED, 0 for none")
+	idleTimeout          = flag.Duration("idletimeout", 0, "end the calls without any message for this duration with UNAVAILABLE, 0 for none")
+	maxConcurrentStreams = flag.Uint("maxconcurrentstreams", 0, "maximum concurrent HTTP/2 streams per client connection, 0 for the HTTP/2 default")
+	maxStreams           = flag.Int64("maxstreams", 0, "reject the calls over this number of calls in flight with UNAVAILABLE, 0 for no limit")
+
+	resetRate  = flag.Float64("resetrate", 0, "fraction of calls, between 0 and 1, reset with an HTTP/2 RST_STREAM")
+	resetAfter = flag.Duration("resetafter", 0, "delay before resetting the calls picked by -resetrate, 0 to reset them before they reach the upstream")
+	goAwayRate = flag.Float64("goawayrate", 0, "fraction of calls, between 0 and 1, whose connection gets an HTTP/2 GOAWAY")
+)
+
+// greeter_proxy forwards gRPC calls, or TCP connections with the tcp subcommand :
+//
+//	greeter_proxy [grpc] -upstream localhost:7788 -idletimeout 30s
+//	greeter_proxy tcp -upstream localhost:7788 -latency 100ms
+func main() {
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		switch args[0] {
+		case "grpc":
+			args = args[1:]
+		case "tcp":
+			runTCP(args[1:])
+			return
+		default:
+			fmt.Fprintf(os.Stderr, "unknown subcommand %q, use grpc or tcp\n", args[0])
+			os.Exit(2)
+		}
+	}
+	flag.CommandLine.Parse(args)
+
+	logger, log := newLogger(*debug)
+
+	opts := proxy.Options{
+		Upstream:             *upstream,
+		RequestTimeout:       *requestTimeout,
+		MaxStreamDuration:    *maxStreamDuration,
+		IdleTimeout:          *idleTimeout,
+		MaxConcurrentStreams: uint32(*maxConcurrentStreams),
+		MaxStreams:           *maxStreams,
+		ResetRate:            *resetRate,
+		ResetAfter:           *resetAfter,
+		GoAwayRate:           *goAwayRate,
+		Logger:               logger,
+	}
+	if *upstreamTLS {
+		upstreamOpts := client.Options{
+			TLS:                true,
+			InsecureSkipVerify: *upstreamSkipVerify,
+			CAFile:             *upstreamCACert,
+			CertFile:           *upstreamCert,
+			KeyFile:            *upstreamKey,
+		}
+		var err error
+		if opts.UpstreamTLS, err = upstreamOpts.TLSConfig(); err != nil {
+			log.Fatalf("invalid upstream setup: %v", err)
+		}
+	}
+
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%s", *grpcPort))
+	if err != nil {
+		log.Fatalf("failed to listen: %v", err)
+	}
+	p := proxy.New(opts)
+	go func() {
+		if err := p.Serve(lis); err != nil {
+			log.Fatalf("failed to serve: %v", err)
+		}
+	}()
+
+	http.Handle("/metrics", promhttp.Handler())
+	go func() {
+		log.Error(http.ListenAndServe(fmt.Sprintf(":%s", *httpPort), nil))
+	}()
+
+	waitSignal(log)
+	p.Stop()
+}
+
+// newLogger logs as JSON on stdout, only showing warnings unless debug is set
+func newLogger(debug bool) (*logrus.Logger, *logrus.Entry) {
+	logger := logrus.New()
+	logger.SetFormatter(&logrus.JSONFormatter{})
+	logger.SetOutput(os.Stdout)
+	if debug {
+		logger.SetLevel(logrus.DebugLevel)
+	} else {
+		logger.SetLevel(logrus.WarnLevel)
+	}
+	return logger, logger.WithFields(logrus.Fields{
+		"application": "greeter_proxy",
+		"version":     version,
+	})
+}
+
+// waitSignal traps SIGINT and SIGTERM to trigger a shutdown
+func waitSignal(log *logrus.Entry) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	sig := <-signals
+	log.Warnf("got signal %v, stopping", sig)
+}
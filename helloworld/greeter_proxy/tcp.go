@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/namsral/flag"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prune998/goHelloGrpcStream/helloworld/proxy"
+)
+
+// runTCP runs the tcp subcommand, a TCP proxy injecting network faults
+func runTCP(args []string) {
+	fs := flag.NewFlagSet("tcp", flag.ExitOnError)
+	debug := fs.Bool("debug", false, "display debugs")
+	port := fs.String("port", "7792", "port to bind for the proxied TCP")
+	httpPort := fs.String("httpport", "7793", "port to bind for HTTP metrics and the /chaos and /reset control API")
+	httpAddr := fs.String("httpaddr", "localhost", "address to bind -httpport on, the control API being unauthenticated, empty for all the addresses")
+	upstream := fs.String("upstream", "localhost:7788", "TCP server to forward the connections to")
+
+	var chaos proxy.Chaos
+	fs.DurationVar(&chaos.Latency, "latency", 0, "delay added to the data, in each direction")
+	fs.DurationVar(&chaos.Jitter, "jitter", 0, "random delay, up to this duration, added to -latency")
+	fs.IntVar(&chaos.Bandwidth, "bandwidth", 0, "bytes/s per connection and direction, 0 for no limit")
+	fs.Int64Var(&chaos.ResetAfterBytes, "resetafterbytes", 0, "reset the connections with a TCP RST after they forwarded this many bytes, 0 to never")
+	fs.DurationVar(&chaos.ResetAfter, "resetafter", 0, "reset the connections with a TCP RST after this duration, 0 to never")
+	fs.BoolVar(&chaos.BlackHole, "blackhole", false, "drop all the data and the closes, keeping the connections open")
+	fs.DurationVar(&chaos.SlowClose, "slowclose", 0, "delay passing on a side closing the connection to the other side")
+	fs.Parse(args)
+
+	logger, log := newLogger(*debug)
+
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%s", *port))
+	if err != nil {
+		log.Fatalf("failed to listen: %v", err)
+	}
+	p := proxy.NewTCP(*upstream, chaos, logger)
+	go func() {
+		if err := p.Serve(lis); err != nil {
+			log.Fatalf("failed to serve: %v", err)
+		}
+	}()
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	control := p.ControlHandler()
+	mux.Handle(proxy.ChaosPath, control)
+	mux.Handle(proxy.ResetPath, control)
+	go func() {
+		log.Error(http.ListenAndServe(net.JoinHostPort(*httpAddr, *httpPort), mux))
+	}()
+
+	waitSignal(log)
+	p.Stop()
+}
@@ -21,94 +21,88 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
-	"io"
-	"net"
-	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	grpc_logrus "github.com/grpc-ecosystem/go-grpc-middleware/logging/logrus"
-	grpc_ctxtags "github.com/grpc-ecosystem/go-grpc-middleware/tags"
-	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
-
 	"github.com/namsral/flag"
+	"github.com/prune998/goHelloGrpcStream/helloworld/client"
 	pb "github.com/prune998/goHelloGrpcStream/helloworld/helloworld"
+	"github.com/prune998/goHelloGrpcStream/helloworld/server"
 	"github.com/sirupsen/logrus"
-
-	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 )
 
 var (
-	freq     = flag.Duration("freq", 10*time.Second, "frequency for sending a msg")
-	debug    = flag.Bool("debug", false, "display debugs")
-	reply    = flag.Bool("reply", false, "reply to each message")
-	grpcPort = flag.String("grpcport", "7788", "port to bind for GRPC")
-	httpPort = flag.String("httpport", "7789", "port to bind for HTTP")
-	version  = "no version set"
+	freq       = flag.Duration("freq", 10*time.Second, "frequency for sending a msg")
+	debug      = flag.Bool("debug", false, "display debugs")
+	reply      = flag.Bool("reply", false, "reply to each message")
+	echo       = flag.Bool("echo", false, "return the metadata, peer and TLS settings of each call in the replies, the first one for the streams")
+	grpcPort   = flag.String("grpcport", "7788", "port to bind for GRPC")
+	httpPort   = flag.String("httpport", "7789", "port to bind for HTTP")
+	singlePort = flag.Bool("singleport", false, "serve gRPC and HTTP on -grpcport only, -httpport is ignored")
+	version    = "no version set"
+
+	maxConcurrentStreams = flag.Uint("maxconcurrentstreams", 50000, "maximum concurrent HTTP/2 streams per connection")
+	keepaliveMinTime     = flag.Duration("keepalivemintime", 0, "shortest interval allowed between client keepalive pings, 0 for the gRPC default of 5m, ignored with -singleport")
+	maxStreams           = flag.Int64("maxstreams", 0, "maximum concurrent streams server-wide, 0 for no limit")
+	unaryRate            = flag.Float64("unaryrate", 0, "maximum unary requests/s server-wide, 0 for no limit")
+	unaryRatePeer        = flag.Float64("unaryratepeer", 0, "maximum unary requests/s per peer address, 0 for no limit")
+	unaryRateClient      = flag.Float64("unaryrateclient", 0, "maximum unary requests/s per client name, 0 for no limit")
+	streamRate           = flag.Float64("streamrate", 0, "maximum new streams/s server-wide, 0 for no limit")
+	streamRatePeer       = flag.Float64("streamratepeer", 0, "maximum new streams/s per peer address, 0 for no limit")
+	streamRateClient     = flag.Float64("streamrateclient", 0, "maximum new streams/s per client name, 0 for no limit")
+	msgRate              = flag.Float64("msgrate", 0, "maximum messages/s per stream, 0 for no limit")
+	rateBurst            = flag.Int("rateburst", 0, "burst allowed by the rate limits, defaults to the rate")
+
+	tlsCert     = flag.String("tlscert", "", "certificate file to serve gRPC over TLS")
+	tlsKey      = flag.String("tlskey", "", "key file to serve gRPC over TLS")
+	tlsClientCA = flag.String("tlsclientca", "", "CA file to verify client certificates (mTLS)")
+	apiKeys     = flag.String("apikeys", "", "comma separated list of identity:key accepted in the x-api-key metadata")
+	jwks        = flag.String("jwks", "", "JWKS file holding the keys to verify bearer JWTs")
+	jwtIssuer   = flag.String("jwtissuer", "", "required iss claim of the JWTs")
+	jwtAudience = flag.String("jwtaudience", "", "required aud claim of the JWTs")
+	allowedSANs = flag.String("allowedsans", "", "comma separated list of client certificate SAN patterns accepted over mTLS")
+
+	faultRate  = flag.Float64("faultrate", 0, "fraction of unary calls, between 0 and 1, failing with -faultcode")
+	faultCode  = flag.String("faultcode", "UNAVAILABLE", "status code of the injected faults")
+	faultDelay = flag.Duration("faultdelay", 0, "delay added to each unary call")
+
+	proxyProtocol  = flag.Bool("proxyprotocol", false, "read the PROXY protocol v1/v2 header load balancers send on -grpcport and -httpport")
+	trustedProxies = flag.String("trustedproxies", "", "comma separated networks or addresses allowed to send a PROXY header, any when empty")
+
+	upstream           = flag.String("upstream", "", "relay the calls and stream messages to this Greeter, like greeter-c:7788, instead of answering them")
+	upstreamTLS        = flag.Bool("upstreamtls", false, "use TLS to the upstream")
+	upstreamCACert     = flag.String("upstreamcacert", "", "CA file to verify the upstream certificate")
+	upstreamCert       = flag.String("upstreamcert", "", "client certificate file for mTLS to the upstream")
+	upstreamKey        = flag.String("upstreamkey", "", "client key file for mTLS to the upstream")
+	upstreamSkipVerify = flag.Bool("upstreaminsecureskipverify", false, "do not verify the upstream certificate")
+	hopName            = flag.String("hopname", "", "name of this relay in the hops of the replies, the hostname when empty")
 )
 
-// server is used to implement helloworld.GreeterServer.
-type server struct {
-	*logrus.Logger
-	pb.UnimplementedGreeterServer
-}
+// listeners are the -listen flags, which can be repeated
+var listeners listFlag
 
-// SayHello implements helloworld.GreeterServer
-func (s *server) SayHello(ctx context.Context, in *pb.HelloRequest) (*pb.HelloReply, error) {
-	log := s.WithFields(logrus.Fields{
-		"client":   in.Name,
-		"port":     *grpcPort,
-		"endpoint": "SayHello",
-	})
-	PromSayHelloReceivedCounter.Inc()
-	log.Infof("got request from client %v:%v", in.Name, *grpcPort)
-	return &pb.HelloReply{Message: "Hello " + in.Name + " " + *grpcPort}, nil
+func init() {
+	flag.Var(&listeners, "listen", "listener as serve=network://address?options, like grpc=tcp://:7788, http=tcp4://127.0.0.1:7789, "+
+		"single=unix:///run/greeter.sock?mode=0660 or grpc=tcp6://[::]:7443?reuseport=true&cert=server.pem&key=server.key&clientca=ca.pem. "+
+		"Can be repeated, replaces -grpcport, -httpport and -singleport")
 }
 
-// SayHelloStream implements helloworld.GreeterServer
-func (s *server) SayHelloStream(stream pb.Greeter_SayHelloStreamServer) error {
-	log := s.WithFields(logrus.Fields{
-		"port":     *grpcPort,
-		"endpoint": "SayHelloStream",
-	})
-	PromSayHelloStreamReceivedCounter.Inc()
-	PromSayHelloStreamReceivedGauge.Inc()
-	log.Info("SayHelloStream called")
-	for {
-		msg, err := stream.Recv()
-		if err == io.EOF {
-			log.Errorf("EOF while sending alerts to user: %v", err)
-			PromSayHelloStreamReceivedGauge.Dec()
-			break
-		}
-		if err != nil {
-			log.Errorf("Error while sending alerts to user: %v", err)
-			PromSayHelloStreamReceivedGauge.Dec()
-			break
-		}
+// listFlag is a flag that can be repeated
+type listFlag []string
 
-		log.Infof("Reveived Stream message %v", msg.Name)
-
-		// we reply to the message
-		if *reply {
-			err = stream.Send(&pb.HelloReply{Message: "Pong " + msg.Name})
-			if err == io.EOF {
-				log.Errorf("EOF while sending alerts to user: %v", err)
-				PromSayHelloStreamReceivedGauge.Dec()
-				break
-			}
-			if err != nil {
-				log.Errorf("Error while sending alerts to user: %v", err)
-				PromSayHelloStreamReceivedGauge.Dec()
-				break
-			}
-		}
+func (l *listFlag) String() string {
+	return strings.Join(*l, " ")
+}
 
-	}
+func (l *listFlag) Set(v string) error {
+	*l = append(*l, v)
 	return nil
 }
 
@@ -116,80 +110,143 @@ func main() {
 	flag.Parse()
 
 	// Logrus
-	// Log as JSON instead of the default ASCII formatter.
-	logrus.SetFormatter(&logrus.JSONFormatter{})
-
-	// Output to stdout instead of the default stderr
-	// Can be any io.Writer, see below for File example
-	logrus.SetOutput(os.Stdout)
-
-	// Only log the warning severity or above.
+	// Log as JSON on stdout, only showing warnings unless debug is set
+	logger := logrus.New()
+	logger.SetFormatter(&logrus.JSONFormatter{})
+	logger.SetOutput(os.Stdout)
 	if *debug {
-		logrus.SetLevel(logrus.DebugLevel)
+		logger.SetLevel(logrus.DebugLevel)
 	} else {
-		logrus.SetLevel(logrus.WarnLevel)
+		logger.SetLevel(logrus.WarnLevel)
 	}
-
-	// all the above logrus is not working...
-	// now we create the logrus logger
-	logger := logrus.New()
 	log := logger.WithFields(logrus.Fields{
 		"application": "greeter_server",
 	})
 	grpc_logrus.ReplaceGrpcLogger(log)
-	lis, err := net.Listen("tcp", fmt.Sprintf(":%v", *grpcPort))
-	if err != nil {
-		log.Fatalf("failed to listen: %v", err)
-	}
 
-	// add logrus accesslogs
-	opts := []grpc_logrus.Option{
-		grpc_logrus.WithDurationField(func(duration time.Duration) (key string, value interface{}) {
-			return "grpc.time_ns", duration.Nanoseconds()
-		}),
+	var err error
+	opts := server.DefaultOptions()
+	opts.GRPCPort = *grpcPort
+	opts.HTTPPort = *httpPort
+	opts.SinglePort = *singlePort
+	opts.Reply = *reply
+	opts.Echo = *echo
+	opts.MaxConcurrentStreams = uint32(*maxConcurrentStreams)
+	opts.KeepaliveMinTime = *keepaliveMinTime
+	opts.Limits = server.Limits{
+		UnaryGlobal:       *unaryRate,
+		UnaryPerPeer:      *unaryRatePeer,
+		UnaryPerClient:    *unaryRateClient,
+		StreamsGlobal:     *streamRate,
+		StreamsPerPeer:    *streamRatePeer,
+		StreamsPerClient:  *streamRateClient,
+		MessagesPerStream: *msgRate,
+		Burst:             *rateBurst,
+		MaxStreams:        *maxStreams,
 	}
+	opts.Version = version
+	opts.Logger = logger
 
-	// configure the gRPC endpoint to report metrics, logs and increase HTTP2 streams
-	grpc_prometheus.EnableHandlingTimeHistogram()
-	serverOpts := []grpc.ServerOption{
-		grpc.MaxConcurrentStreams(50000),
-		grpc.ChainUnaryInterceptor(
-			grpc_ctxtags.UnaryServerInterceptor(),
-			grpc_logrus.UnaryServerInterceptor(log, opts...),
-		),
-		grpc.ChainStreamInterceptor(
-			grpc_ctxtags.StreamServerInterceptor(),
-			grpc_logrus.StreamServerInterceptor(log, opts...),
-		),
+	opts.TLSConfig, err = server.LoadTLSConfig(*tlsCert, *tlsKey, *tlsClientCA)
+	if err != nil {
+		log.Fatalf("invalid TLS setup: %v", err)
+	}
+	opts.ProxyProtocol.Enabled = *proxyProtocol
+	opts.ProxyProtocol.Trusted, err = server.ParseTrustedProxies(*trustedProxies)
+	if err != nil {
+		log.Fatalf("invalid -trustedproxies: %v", err)
+	}
+	for _, spec := range listeners {
+		l, err := server.ParseListener(spec)
+		if err != nil {
+			log.Fatalf("invalid -listen: %v", err)
+		}
+		opts.Listeners = append(opts.Listeners, l)
+	}
+	opts.Auth, err = authOptions()
+	if err != nil {
+		log.Fatalf("invalid auth setup: %v", err)
 	}
-	s := grpc.NewServer(serverOpts...)
-	pb.RegisterGreeterServer(s, &server{})
 
-	// healthz basic
-	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
-		m := map[string]interface{}{"version": version, "status": "OK"}
+	opts.Faults = server.Faults{ErrorRate: *faultRate, Delay: *faultDelay}
+	if err := opts.Faults.ErrorCode.UnmarshalJSON([]byte(strconv.Quote(strings.ToUpper(*faultCode)))); err != nil {
+		log.Fatalf("invalid -faultcode: %v", err)
+	}
 
-		b, err := json.Marshal(m)
+	if *hopName != "" {
+		opts.HopName = *hopName
+	}
+	if *upstream != "" {
+		upstreamOpts := client.Options{
+			TLS:                *upstreamTLS,
+			InsecureSkipVerify: *upstreamSkipVerify,
+			CAFile:             *upstreamCACert,
+			CertFile:           *upstreamCert,
+			KeyFile:            *upstreamKey,
+		}
+		dialOpts, err := upstreamOpts.DialOptions()
+		if err != nil {
+			log.Fatalf("invalid upstream setup: %v", err)
+		}
+		conn, err := grpc.Dial(*upstream, dialOpts...)
 		if err != nil {
-			http.Error(w, "no valid point for this device_id", 500)
-			return
+			log.Fatalf("cant connect to upstream: %v", err)
 		}
+		defer conn.Close()
+		opts.Upstream = pb.NewGreeterClient(conn)
+		log.Warnf("relaying to %s as %s", *upstream, opts.HopName)
+	}
 
-		w.Write(b)
-	})
+	s, err := server.New(opts)
+	if err != nil {
+		log.Fatalf("failed to create the server: %v", err)
+	}
+	if err := s.Start(); err != nil {
+		log.Fatalf("failed to start: %v", err)
+	}
 
-	// prometheus metrics
-	http.Handle("/metrics", promhttp.Handler())
+	// trap SIGINT and SIGTERM to trigger a shutdown.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	sig := <-signals
+	log.Warnf("got signal %v, stopping", sig)
+	s.Stop()
+}
+
+// authOptions builds the authentication setup from the flags
+func authOptions() (server.AuthOptions, error) {
+	var auth server.AuthOptions
+
+	for _, entry := range splitList(*apiKeys) {
+		identity, key, ok := strings.Cut(entry, ":")
+		if !ok || identity == "" || key == "" {
+			return auth, fmt.Errorf("API key %q is not identity:key", entry)
+		}
+		if auth.APIKeys == nil {
+			auth.APIKeys = map[string]string{}
+		}
+		auth.APIKeys[key] = identity
+	}
 
-	// listen on the HTTP port for metrics
-	go func() {
-		log.Warn(fmt.Sprintf("listening HTTP (metrics & map) on %v", *httpPort))
-		log.Warn(http.ListenAndServe(fmt.Sprintf(":%s", *httpPort), nil))
-	}()
+	if *jwks != "" {
+		v, err := server.NewJWTVerifier(*jwks, *jwtIssuer, *jwtAudience)
+		if err != nil {
+			return auth, err
+		}
+		auth.JWT = v
+	}
 
-	// start the gRPC port
-	log.Warnf("Listening on tcp://localhost:%v", *grpcPort)
-	if err := s.Serve(lis); err != nil {
-		log.Fatalf("failed to serve: %v", err)
+	auth.AllowedSANs = splitList(*allowedSANs)
+	return auth, nil
+}
+
+// splitList splits a comma separated flag, ignoring empty entries
+func splitList(s string) []string {
+	var list []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			list = append(list, e)
+		}
 	}
+	return list
 }
@@ -1,3 +1,3 @@
-//go:generate protoc  --proto_path=../..:.:$GOPATH/src --go_out==plugins=grpc:. helloworld.proto
+//go:generate protoc -I .. -I $GOOGLEAPIS --go_out=.. --go_opt=paths=source_relative --go-grpc_out=.. --go-grpc_opt=paths=source_relative --grpc-gateway_out=.. --grpc-gateway_opt=paths=source_relative --openapiv2_out=.. --connect-go_out=.. --connect-go_opt=paths=source_relative helloworld/helloworld.proto
 
 package helloworld
@@ -33,8 +33,9 @@ import (
 const _ = grpc.SupportPackageIsVersion7
 
 const (
-	Greeter_SayHello_FullMethodName       = "/helloworld.Greeter/SayHello"
-	Greeter_SayHelloStream_FullMethodName = "/helloworld.Greeter/SayHelloStream"
+	Greeter_SayHello_FullMethodName             = "/helloworld.Greeter/SayHello"
+	Greeter_SayHelloStream_FullMethodName       = "/helloworld.Greeter/SayHelloStream"
+	Greeter_SayHelloServerStream_FullMethodName = "/helloworld.Greeter/SayHelloServerStream"
 )
 
 // GreeterClient is the client API for Greeter service.
@@ -43,7 +44,12 @@ const (
 type GreeterClient interface {
 	// Sends a greeting
 	SayHello(ctx context.Context, in *HelloRequest, opts ...grpc.CallOption) (*HelloReply, error)
+	// Sends a greeting for each message of the stream, when the server replies.
+	// Over HTTP, messages are newline delimited JSON in both directions.
 	SayHelloStream(ctx context.Context, opts ...grpc.CallOption) (Greeter_SayHelloStreamClient, error)
+	// Sends count greetings, one every interval_ms.
+	// Unlike SayHelloStream it works over HTTP/1.1, with gRPC-Web or Connect.
+	SayHelloServerStream(ctx context.Context, in *HelloStreamRequest, opts ...grpc.CallOption) (Greeter_SayHelloServerStreamClient, error)
 }
 
 type greeterClient struct {
@@ -94,13 +100,50 @@ func (x *greeterSayHelloStreamClient) Recv() (*HelloReply, error) {
 	return m, nil
 }
 
+func (c *greeterClient) SayHelloServerStream(ctx context.Context, in *HelloStreamRequest, opts ...grpc.CallOption) (Greeter_SayHelloServerStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Greeter_ServiceDesc.Streams[1], Greeter_SayHelloServerStream_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &greeterSayHelloServerStreamClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Greeter_SayHelloServerStreamClient interface {
+	Recv() (*HelloReply, error)
+	grpc.ClientStream
+}
+
+type greeterSayHelloServerStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *greeterSayHelloServerStreamClient) Recv() (*HelloReply, error) {
+	m := new(HelloReply)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // GreeterServer is the server API for Greeter service.
 // All implementations must embed UnimplementedGreeterServer
 // for forward compatibility
 type GreeterServer interface {
 	// Sends a greeting
 	SayHello(context.Context, *HelloRequest) (*HelloReply, error)
+	// Sends a greeting for each message of the stream, when the server replies.
+	// Over HTTP, messages are newline delimited JSON in both directions.
 	SayHelloStream(Greeter_SayHelloStreamServer) error
+	// Sends count greetings, one every interval_ms.
+	// Unlike SayHelloStream it works over HTTP/1.1, with gRPC-Web or Connect.
+	SayHelloServerStream(*HelloStreamRequest, Greeter_SayHelloServerStreamServer) error
 	mustEmbedUnimplementedGreeterServer()
 }
 
@@ -114,6 +157,9 @@ func (UnimplementedGreeterServer) SayHello(context.Context, *HelloRequest) (*Hel
 func (UnimplementedGreeterServer) SayHelloStream(Greeter_SayHelloStreamServer) error {
 	return status.Errorf(codes.Unimplemented, "method SayHelloStream not implemented")
 }
+func (UnimplementedGreeterServer) SayHelloServerStream(*HelloStreamRequest, Greeter_SayHelloServerStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method SayHelloServerStream not implemented")
+}
 func (UnimplementedGreeterServer) mustEmbedUnimplementedGreeterServer() {}
 
 // UnsafeGreeterServer may be embedded to opt out of forward compatibility for this service.
@@ -171,6 +217,27 @@ func (x *greeterSayHelloStreamServer) Recv() (*HelloRequest, error) {
 	return m, nil
 }
 
+func _Greeter_SayHelloServerStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(HelloStreamRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(GreeterServer).SayHelloServerStream(m, &greeterSayHelloServerStreamServer{stream})
+}
+
+type Greeter_SayHelloServerStreamServer interface {
+	Send(*HelloReply) error
+	grpc.ServerStream
+}
+
+type greeterSayHelloServerStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *greeterSayHelloServerStreamServer) Send(m *HelloReply) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 // Greeter_ServiceDesc is the grpc.ServiceDesc for Greeter service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -190,6 +257,11 @@ var Greeter_ServiceDesc = grpc.ServiceDesc{
 			ServerStreams: true,
 			ClientStreams: true,
 		},
+		{
+			StreamName:    "SayHelloServerStream",
+			Handler:       _Greeter_SayHelloServerStream_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: "helloworld/helloworld.proto",
 }
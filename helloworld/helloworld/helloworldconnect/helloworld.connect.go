@@ -0,0 +1,195 @@
+// Copyright 2015 gRPC authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Code generated by protoc-gen-connect-go. DO NOT EDIT.
+//
+// Source: helloworld/helloworld.proto
+
+package helloworldconnect
+
+import (
+	connect "connectrpc.com/connect"
+	context "context"
+	errors "errors"
+	helloworld "github.com/prune998/goHelloGrpcStream/helloworld/helloworld"
+	http "net/http"
+	strings "strings"
+)
+
+// This is a compile-time assertion to ensure that this generated file and the connect package are
+// compatible. If you get a compiler error that this constant is not defined, this code was
+// generated with a version of connect newer than the one compiled into your binary. You can fix the
+// problem by either regenerating this code with an older version of connect or updating the connect
+// version compiled into your binary.
+const _ = connect.IsAtLeastVersion1_13_0
+
+const (
+	// GreeterName is the fully-qualified name of the Greeter service.
+	GreeterName = "helloworld.Greeter"
+)
+
+// These constants are the fully-qualified names of the RPCs defined in this package. They're
+// exposed at runtime as Spec.Procedure and as the final two segments of the HTTP route.
+//
+// Note that these are different from the fully-qualified method names used by
+// google.golang.org/protobuf/reflect/protoreflect. To convert from these constants to
+// reflection-formatted method names, remove the leading slash and convert the remaining slash to a
+// period.
+const (
+	// GreeterSayHelloProcedure is the fully-qualified name of the Greeter's SayHello RPC.
+	GreeterSayHelloProcedure = "/helloworld.Greeter/SayHello"
+	// GreeterSayHelloStreamProcedure is the fully-qualified name of the Greeter's SayHelloStream RPC.
+	GreeterSayHelloStreamProcedure = "/helloworld.Greeter/SayHelloStream"
+	// GreeterSayHelloServerStreamProcedure is the fully-qualified name of the Greeter's
+	// SayHelloServerStream RPC.
+	GreeterSayHelloServerStreamProcedure = "/helloworld.Greeter/SayHelloServerStream"
+)
+
+// These variables are the protoreflect.Descriptor objects for the RPCs defined in this package.
+var (
+	greeterServiceDescriptor                    = helloworld.File_helloworld_helloworld_proto.Services().ByName("Greeter")
+	greeterSayHelloMethodDescriptor             = greeterServiceDescriptor.Methods().ByName("SayHello")
+	greeterSayHelloStreamMethodDescriptor       = greeterServiceDescriptor.Methods().ByName("SayHelloStream")
+	greeterSayHelloServerStreamMethodDescriptor = greeterServiceDescriptor.Methods().ByName("SayHelloServerStream")
+)
+
+// GreeterClient is a client for the helloworld.Greeter service.
+type GreeterClient interface {
+	// Sends a greeting
+	SayHello(context.Context, *connect.Request[helloworld.HelloRequest]) (*connect.Response[helloworld.HelloReply], error)
+	// Sends a greeting for each message of the stream, when the server replies.
+	// Over HTTP, messages are newline delimited JSON in both directions.
+	SayHelloStream(context.Context) *connect.BidiStreamForClient[helloworld.HelloRequest, helloworld.HelloReply]
+	// Sends count greetings, one every interval_ms.
+	// Unlike SayHelloStream it works over HTTP/1.1, with gRPC-Web or Connect.
+	SayHelloServerStream(context.Context, *connect.Request[helloworld.HelloStreamRequest]) (*connect.ServerStreamForClient[helloworld.HelloReply], error)
+}
+
+// NewGreeterClient constructs a client for the helloworld.Greeter service. By default, it uses the
+// Connect protocol with the binary Protobuf Codec, asks for gzipped responses, and sends
+// uncompressed requests. To use the gRPC or gRPC-Web protocols, supply the connect.WithGRPC() or
+// connect.WithGRPCWeb() options.
+//
+// The URL supplied here should be the base URL for the Connect or gRPC server (for example,
+// http://api.acme.com or https://acme.com/grpc).
+func NewGreeterClient(httpClient connect.HTTPClient, baseURL string, opts ...connect.ClientOption) GreeterClient {
+	baseURL = strings.TrimRight(baseURL, "/")
+	return &greeterClient{
+		sayHello: connect.NewClient[helloworld.HelloRequest, helloworld.HelloReply](
+			httpClient,
+			baseURL+GreeterSayHelloProcedure,
+			connect.WithSchema(greeterSayHelloMethodDescriptor),
+			connect.WithClientOptions(opts...),
+		),
+		sayHelloStream: connect.NewClient[helloworld.HelloRequest, helloworld.HelloReply](
+			httpClient,
+			baseURL+GreeterSayHelloStreamProcedure,
+			connect.WithSchema(greeterSayHelloStreamMethodDescriptor),
+			connect.WithClientOptions(opts...),
+		),
+		sayHelloServerStream: connect.NewClient[helloworld.HelloStreamRequest, helloworld.HelloReply](
+			httpClient,
+			baseURL+GreeterSayHelloServerStreamProcedure,
+			connect.WithSchema(greeterSayHelloServerStreamMethodDescriptor),
+			connect.WithClientOptions(opts...),
+		),
+	}
+}
+
+// greeterClient implements GreeterClient.
+type greeterClient struct {
+	sayHello             *connect.Client[helloworld.HelloRequest, helloworld.HelloReply]
+	sayHelloStream       *connect.Client[helloworld.HelloRequest, helloworld.HelloReply]
+	sayHelloServerStream *connect.Client[helloworld.HelloStreamRequest, helloworld.HelloReply]
+}
+
+// SayHello calls helloworld.Greeter.SayHello.
+func (c *greeterClient) SayHello(ctx context.Context, req *connect.Request[helloworld.HelloRequest]) (*connect.Response[helloworld.HelloReply], error) {
+	return c.sayHello.CallUnary(ctx, req)
+}
+
+// SayHelloStream calls helloworld.Greeter.SayHelloStream.
+func (c *greeterClient) SayHelloStream(ctx context.Context) *connect.BidiStreamForClient[helloworld.HelloRequest, helloworld.HelloReply] {
+	return c.sayHelloStream.CallBidiStream(ctx)
+}
+
+// SayHelloServerStream calls helloworld.Greeter.SayHelloServerStream.
+func (c *greeterClient) SayHelloServerStream(ctx context.Context, req *connect.Request[helloworld.HelloStreamRequest]) (*connect.ServerStreamForClient[helloworld.HelloReply], error) {
+	return c.sayHelloServerStream.CallServerStream(ctx, req)
+}
+
+// GreeterHandler is an implementation of the helloworld.Greeter service.
+type GreeterHandler interface {
+	// Sends a greeting
+	SayHello(context.Context, *connect.Request[helloworld.HelloRequest]) (*connect.Response[helloworld.HelloReply], error)
+	// Sends a greeting for each message of the stream, when the server replies.
+	// Over HTTP, messages are newline delimited JSON in both directions.
+	SayHelloStream(context.Context, *connect.BidiStream[helloworld.HelloRequest, helloworld.HelloReply]) error
+	// Sends count greetings, one every interval_ms.
+	// Unlike SayHelloStream it works over HTTP/1.1, with gRPC-Web or Connect.
+	SayHelloServerStream(context.Context, *connect.Request[helloworld.HelloStreamRequest], *connect.ServerStream[helloworld.HelloReply]) error
+}
+
+// NewGreeterHandler builds an HTTP handler from the service implementation. It returns the path on
+// which to mount the handler and the handler itself.
+//
+// By default, handlers support the Connect, gRPC, and gRPC-Web protocols with the binary Protobuf
+// and JSON codecs. They also support gzip compression.
+func NewGreeterHandler(svc GreeterHandler, opts ...connect.HandlerOption) (string, http.Handler) {
+	greeterSayHelloHandler := connect.NewUnaryHandler(
+		GreeterSayHelloProcedure,
+		svc.SayHello,
+		connect.WithSchema(greeterSayHelloMethodDescriptor),
+		connect.WithHandlerOptions(opts...),
+	)
+	greeterSayHelloStreamHandler := connect.NewBidiStreamHandler(
+		GreeterSayHelloStreamProcedure,
+		svc.SayHelloStream,
+		connect.WithSchema(greeterSayHelloStreamMethodDescriptor),
+		connect.WithHandlerOptions(opts...),
+	)
+	greeterSayHelloServerStreamHandler := connect.NewServerStreamHandler(
+		GreeterSayHelloServerStreamProcedure,
+		svc.SayHelloServerStream,
+		connect.WithSchema(greeterSayHelloServerStreamMethodDescriptor),
+		connect.WithHandlerOptions(opts...),
+	)
+	return "/helloworld.Greeter/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case GreeterSayHelloProcedure:
+			greeterSayHelloHandler.ServeHTTP(w, r)
+		case GreeterSayHelloStreamProcedure:
+			greeterSayHelloStreamHandler.ServeHTTP(w, r)
+		case GreeterSayHelloServerStreamProcedure:
+			greeterSayHelloServerStreamHandler.ServeHTTP(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+// UnimplementedGreeterHandler returns CodeUnimplemented from all methods.
+type UnimplementedGreeterHandler struct{}
+
+func (UnimplementedGreeterHandler) SayHello(context.Context, *connect.Request[helloworld.HelloRequest]) (*connect.Response[helloworld.HelloReply], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("helloworld.Greeter.SayHello is not implemented"))
+}
+
+func (UnimplementedGreeterHandler) SayHelloStream(context.Context, *connect.BidiStream[helloworld.HelloRequest, helloworld.HelloReply]) error {
+	return connect.NewError(connect.CodeUnimplemented, errors.New("helloworld.Greeter.SayHelloStream is not implemented"))
+}
+
+func (UnimplementedGreeterHandler) SayHelloServerStream(context.Context, *connect.Request[helloworld.HelloStreamRequest], *connect.ServerStream[helloworld.HelloReply]) error {
+	return connect.NewError(connect.CodeUnimplemented, errors.New("helloworld.Greeter.SayHelloServerStream is not implemented"))
+}
@@ -0,0 +1,8 @@
+package helloworld
+
+import _ "embed"
+
+// OpenAPI is the OpenAPI (swagger 2.0) document of the REST/JSON gateway, generated from helloworld.proto
+//
+//go:embed helloworld.swagger.json
+var OpenAPI []byte
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	pb "github.com/prune998/goHelloGrpcStream/helloworld/helloworld"
+)
+
+// unknownBackend names what the servers not giving their identity left empty
+const unknownBackend = "unknown"
+
+// backend is the identity of the server replica in a reply
+type backend struct {
+	pod, node, zone, version string
+}
+
+// backendOf returns the backend of a reply, unknown fields filled with unknownBackend
+func backendOf(b *pb.Backend) backend {
+	orUnknown := func(s string) string {
+		if s == "" {
+			return unknownBackend
+		}
+		return s
+	}
+	return backend{
+		pod:     orUnknown(b.GetPod()),
+		node:    orUnknown(b.GetNode()),
+		zone:    orUnknown(b.GetZone()),
+		version: orUnknown(b.GetVersion()),
+	}
+}
+
+// backendReplies counts the replies by backend
+type backendReplies map[backend]int
+
+// total is the number of replies
+func (r backendReplies) total() int {
+	n := 0
+	for _, count := range r {
+		n += count
+	}
+	return n
+}
+
+// byVersion counts the replies by version
+func (r backendReplies) byVersion() map[string]int {
+	versions := map[string]int{}
+	for b, count := range r {
+		versions[b.version] += count
+	}
+	return versions
+}
+
+// imbalance is the ratio of the replies of the busiest backend over the idlest one, 1 being a fair load balancing
+func (r backendReplies) imbalance() float64 {
+	min, max := 0, 0
+	for _, count := range r {
+		if min == 0 || count < min {
+			min = count
+		}
+		if count > max {
+			max = count
+		}
+	}
+	if min == 0 {
+		return 0
+	}
+	return float64(max) / float64(min)
+}
+
+// printBackends prints the share of the replies of each backend and each version
+func printBackends(w io.Writer, replies backendReplies) {
+	total := replies.total()
+	if total == 0 {
+		return
+	}
+	backends := make([]backend, 0, len(replies))
+	for b := range replies {
+		backends = append(backends, b)
+	}
+	sort.Slice(backends, func(i, j int) bool {
+		if backends[i].version != backends[j].version {
+			return backends[i].version < backends[j].version
+		}
+		return backends[i].pod < backends[j].pod
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "BACKEND\tNODE\tZONE\tVERSION\tREPLIES\tSHARE")
+	for _, b := range backends {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%.1f%%\n", b.pod, b.node, b.zone, b.version, replies[b], share(replies[b], total))
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "%d backends, the busiest got %.2f times the replies of the idlest\n\n", len(replies), replies.imbalance())
+
+	versions := replies.byVersion()
+	names := make([]string, 0, len(versions))
+	for v := range versions {
+		names = append(names, v)
+	}
+	sort.Strings(names)
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tREPLIES\tSHARE")
+	for _, v := range names {
+		fmt.Fprintf(tw, "%s\t%d\t%.1f%%\n", v, versions[v], share(versions[v], total))
+	}
+	tw.Flush()
+}
+
+// share is n in percent of total
+func share(n, total int) float64 {
+	return 100 * float64(n) / float64(total)
+}
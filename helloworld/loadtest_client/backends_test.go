@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prune998/goHelloGrpcStream/helloworld/client"
+	pb "github.com/prune998/goHelloGrpcStream/helloworld/helloworld"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestBackendReplies(t *testing.T) {
+	stable := backend{pod: "greeter-a", node: "node-1", zone: "zone-a", version: "v1"}
+	replies := backendReplies{
+		stable: 45,
+		{pod: "greeter-b", node: "node-2", zone: "zone-b", version: "v1"}: 45,
+		backendOf(&pb.Backend{Pod: "greeter-c", Version: "v2"}):           10,
+	}
+	if got := replies.byVersion(); got["v1"] != 90 || got["v2"] != 10 || len(got) != 2 {
+		t.Errorf("got versions %v", got)
+	}
+	if got := replies.imbalance(); got != 4.5 {
+		t.Errorf("got imbalance %v, want 4.5", got)
+	}
+	if got := backendOf(nil); got.pod != unknownBackend || got.version != unknownBackend {
+		t.Errorf("got %v for a reply without backend", got)
+	}
+
+	var out bytes.Buffer
+	printBackends(&out, replies)
+	for _, want := range []string{
+		"greeter-c  unknown  unknown  v2       10       10.0%",
+		"v1       90       90.0%",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("%q missing from\n%s", want, out.String())
+		}
+	}
+}
+
+// canaryServer answers the first 3 messages of a stream as the canary pod, then ends it
+type canaryServer struct {
+	pb.UnimplementedGreeterServer
+}
+
+func (canaryServer) SayHelloStream(stream pb.Greeter_SayHelloStreamServer) error {
+	for i := 0; i < 3; i++ {
+		msg, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		reply := &pb.HelloReply{Message: "Pong", Seq: msg.Seq, Backend: &pb.Backend{Pod: "greeter-canary", Version: "v2"}}
+		if err := stream.Send(reply); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestClientBackends(t *testing.T) {
+	*sleepTime = 10 * time.Millisecond
+	counter := PromBackendReplyCounter.WithLabelValues(transportGRPC, "greeter-canary", "v2")
+	before := testutil.ToFloat64(counter)
+
+	lis := bufconn.Listen(1024 * 1024)
+	s := grpc.NewServer()
+	pb.RegisterGreeterServer(s, canaryServer{})
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	c := NewClient("0", kitlog.NewNopLogger(), false, transportGRPC, client.Options{}, []grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+	})
+	jobChan := make(chan int, 1)
+	go c.Start(context.Background(), jobChan, "bufnet", "0", 42)
+	expectDone(t, jobChan)
+
+	canary := backend{pod: "greeter-canary", node: unknownBackend, zone: unknownBackend, version: "v2"}
+	if got := c.stats.backends[canary]; got != 3 || len(c.stats.backends) != 1 {
+		t.Errorf("got replies %v, want 3 from %v", c.stats.backends, canary)
+	}
+	if got := testutil.ToFloat64(counter) - before; got != 3 {
+		t.Errorf("counter increased by %v, want 3", got)
+	}
+}
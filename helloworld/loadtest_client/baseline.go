@@ -0,0 +1,381 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// exitRegression is the exit code of a load test worse than its baseline, after exitSLOFailed
+const exitRegression = 4
+
+// maxSavedLatencies is the most Pong latencies saved in the results, evenly spaced quantiles of all of them
+const maxSavedLatencies = 10000
+
+// runResults are what a load test saves, to be the baseline of the next runs
+type runResults struct {
+	// Version is the loadtest_client version
+	Version     string        `json:"version"`
+	Start       time.Time     `json:"start"`
+	Duration    time.Duration `json:"duration_ns"`
+	Transport   string        `json:"transport"`
+	Clients     int           `json:"clients"`
+	Sessions    int           `json:"sessions"`
+	Failures    int           `json:"failures"`
+	Survivors   int           `json:"survivors"`
+	Disconnects int           `json:"disconnects"`
+	Pings       int           `json:"pings"`
+	Pongs       int           `json:"pongs"`
+	// Latencies are the sorted Pong latencies, or maxSavedLatencies quantiles of them
+	Latencies []time.Duration `json:"latencies_ns"`
+}
+
+// newRunResults returns the results of a load test which started at start and ran for elapsed
+func newRunResults(s *sessionStats, start time.Time, elapsed time.Duration, transport string, clients int) runResults {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	latencies := append([]time.Duration(nil), s.latencies...)
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	if n := len(latencies); n > maxSavedLatencies {
+		quantiles := make([]time.Duration, maxSavedLatencies)
+		for i := range quantiles {
+			quantiles[i] = latencies[i*(n-1)/(maxSavedLatencies-1)]
+		}
+		latencies = quantiles
+	}
+	return runResults{
+		Version:     version,
+		Start:       start,
+		Duration:    elapsed,
+		Transport:   transport,
+		Clients:     clients,
+		Sessions:    s.sessions,
+		Failures:    s.failures,
+		Survivors:   s.survivors,
+		Disconnects: s.disconnects,
+		Pings:       s.pings,
+		Pongs:       s.pongs,
+		Latencies:   latencies,
+	}
+}
+
+// saveResults writes r as JSON to path
+func saveResults(path string, r runResults) error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o644)
+}
+
+// loadResults reads the results saved at path
+func loadResults(path string) (runResults, error) {
+	var r runResults
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return r, err
+	}
+	if err := json.Unmarshal(b, &r); err != nil {
+		return r, fmt.Errorf("invalid results in %s: %w", path, err)
+	}
+	return r, nil
+}
+
+// comparableWith returns why a run over transport with clients can not be compared with r, nil when it can
+func (r runResults) comparableWith(transport string, clients int) error {
+	if r.Transport != transport {
+		return fmt.Errorf("the baseline ran over %s, not %s", r.Transport, transport)
+	}
+	if r.Clients != clients {
+		return fmt.Errorf("the baseline ran with %d clients, not %d", r.Clients, clients)
+	}
+	return nil
+}
+
+// errorRate is the share of the sessions failing to open or disconnected, and the number of sessions tried
+func (r runResults) errorRate() (float64, int) {
+	tried := r.Sessions + r.Failures
+	if tried == 0 {
+		return 0, 0
+	}
+	return float64(r.Failures+r.Disconnects) / float64(tried), tried
+}
+
+// messageRate is the Pings sent and Pongs received per second
+func (r runResults) messageRate() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Pings+r.Pongs) / r.Duration.Seconds()
+}
+
+// samples is the number of latencies measured, Latencies holding at most maxSavedLatencies quantiles of them
+func (r runResults) samples() int {
+	return max(r.Pongs, len(r.Latencies))
+}
+
+// percentile returns the p-th percentile of the latencies, between 0 and 1
+func (r runResults) percentile(p float64) time.Duration {
+	if len(r.Latencies) == 0 {
+		return 0
+	}
+	return r.Latencies[int(p*float64(len(r.Latencies)-1))]
+}
+
+// tolerances are how much worse than the baseline a run can be before it is a regression
+type tolerances struct {
+	// latency is the relative increase allowed of the latency percentiles, 0.1 for 10%
+	latency float64
+	// errorRate is the increase allowed of the error rate, 0.01 for 1 percentage point
+	errorRate float64
+	// throughput is the relative decrease allowed of the messages per second, 0.1 for 10%
+	throughput float64
+	// alpha is the significance level of the tests, the changes with a higher p-value are noise
+	alpha float64
+}
+
+// Statuses of a compared metric
+const (
+	statusSame       = "same"
+	statusChanged    = "changed"
+	statusImproved   = "improved"
+	statusRegression = "regression"
+	statusNoData     = "no data"
+)
+
+// metricDiff is a metric of a run compared with the baseline
+type metricDiff struct {
+	Name     string  `json:"name"`
+	Unit     string  `json:"unit"`
+	Baseline float64 `json:"baseline"`
+	Current  float64 `json:"current"`
+	// Change is relative for the latencies and throughput, and the difference for the error rate.
+	// The latency distribution has the Kolmogorov-Smirnov distance from the baseline as Current and Change.
+	Change    float64 `json:"change"`
+	Tolerance float64 `json:"tolerance"`
+	PValue    float64 `json:"p_value"`
+	Status    string  `json:"status"`
+}
+
+// comparison is a run compared with its baseline
+type comparison struct {
+	Verdict  string       `json:"verdict"`
+	Baseline string       `json:"baseline"`
+	Metrics  []metricDiff `json:"metrics"`
+}
+
+// regressed tells whether a metric got worse than its tolerance
+func (c comparison) regressed() bool {
+	return c.Verdict == statusRegression
+}
+
+// status classifies a change, higherIsWorse telling which way is a regression
+func (t tolerances) status(d metricDiff, higherIsWorse bool) string {
+	change := d.Change
+	if !higherIsWorse {
+		change = -change
+	}
+	switch {
+	case d.PValue >= t.alpha:
+		return statusSame
+	case change > d.Tolerance:
+		return statusRegression
+	case change < 0:
+		return statusImproved
+	}
+	return statusChanged
+}
+
+// compareRuns compares the error rate, latency distribution and throughput of cur with base
+func compareRuns(baseline string, base, cur runResults, tol tolerances) comparison {
+	c := comparison{Verdict: "pass", Baseline: baseline}
+
+	baseRate, baseTried := base.errorRate()
+	curRate, curTried := cur.errorRate()
+	errors := metricDiff{Name: "error rate", Unit: "ratio", Baseline: baseRate, Current: curRate, Change: curRate - baseRate, Tolerance: tol.errorRate, PValue: 1, Status: statusNoData}
+	if baseTried > 0 && curTried > 0 {
+		errors.PValue = twoProportionPValue(base.Failures+base.Disconnects, baseTried, cur.Failures+cur.Disconnects, curTried)
+		errors.Status = tol.status(errors, true)
+	}
+	c.Metrics = append(c.Metrics, errors)
+
+	// the distribution as a whole, which changes but does not regress
+	hasLatencies := len(base.Latencies) > 0 && len(cur.Latencies) > 0
+	distribution := metricDiff{Name: "latency distribution", Unit: "distance", PValue: 1, Status: statusNoData}
+	if hasLatencies {
+		distribution.Current, distribution.PValue = ksTest(base.Latencies, cur.Latencies, base.samples(), cur.samples())
+		distribution.Change = distribution.Current
+		distribution.Status = statusSame
+		if distribution.PValue < tol.alpha {
+			distribution.Status = statusChanged
+		}
+	}
+	c.Metrics = append(c.Metrics, distribution)
+	for _, p := range []struct {
+		name     string
+		quantile float64
+	}{{"p50 latency", 0.5}, {"p90 latency", 0.9}, {"p99 latency", 0.99}} {
+		b, n := base.percentile(p.quantile).Seconds(), cur.percentile(p.quantile).Seconds()
+		d := metricDiff{Name: p.name, Unit: "seconds", Baseline: b, Current: n, Change: relativeChange(b, n), Tolerance: tol.latency, PValue: 1, Status: statusNoData}
+		if hasLatencies {
+			d.PValue = quantileShiftPValue(base.Latencies, cur.Latencies, base.samples(), cur.samples(), base.percentile(p.quantile))
+			d.Status = tol.status(d, true)
+		}
+		c.Metrics = append(c.Metrics, d)
+	}
+
+	b, n := base.messageRate(), cur.messageRate()
+	throughput := metricDiff{Name: "messages/s", Unit: "1/s", Baseline: b, Current: n, Change: relativeChange(b, n), Tolerance: tol.throughput, PValue: 1, Status: statusNoData}
+	if base.Duration > 0 && cur.Duration > 0 {
+		throughput.PValue = rateRatioPValue(base.Pings+base.Pongs, base.Duration.Seconds(), cur.Pings+cur.Pongs, cur.Duration.Seconds())
+		throughput.Status = tol.status(throughput, false)
+	}
+	c.Metrics = append(c.Metrics, throughput)
+
+	for _, d := range c.Metrics {
+		if d.Status == statusRegression {
+			c.Verdict = statusRegression
+		}
+	}
+	return c
+}
+
+// relativeChange is the change from base to cur relative to base
+func relativeChange(base, cur float64) float64 {
+	if base == 0 {
+		return 0
+	}
+	return (cur - base) / base
+}
+
+// twoProportionPValue is the two-sided p-value of a z-test of x1 of n1 and x2 of n2 having the same proportion
+func twoProportionPValue(x1, n1, x2, n2 int) float64 {
+	pooled := float64(x1+x2) / float64(n1+n2)
+	se := math.Sqrt(pooled * (1 - pooled) * (1/float64(n1) + 1/float64(n2)))
+	if se == 0 {
+		return 1
+	}
+	z := (float64(x1)/float64(n1) - float64(x2)/float64(n2)) / se
+	return math.Erfc(math.Abs(z) / math.Sqrt2)
+}
+
+// rateRatioPValue is the two-sided p-value of a z-test of the c1 events in t1 and c2 in t2 coming at the same Poisson rate
+func rateRatioPValue(c1 int, t1 float64, c2 int, t2 float64) float64 {
+	se := math.Sqrt(float64(c1)/(t1*t1) + float64(c2)/(t2*t2))
+	if se == 0 {
+		return 1
+	}
+	z := (float64(c1)/t1 - float64(c2)/t2) / se
+	return math.Erfc(math.Abs(z) / math.Sqrt2)
+}
+
+// quantileShiftPValue is the two-sided p-value of the same share of a and b being over threshold,
+// a quantile of a, testing whether that quantile moved. a and b are the quantiles of na and nb latencies.
+func quantileShiftPValue(a, b []time.Duration, na, nb int, threshold time.Duration) float64 {
+	over := func(samples []time.Duration, n int) int {
+		count := len(samples) - sort.Search(len(samples), func(i int) bool { return samples[i] > threshold })
+		return int(math.Round(float64(count) / float64(len(samples)) * float64(n)))
+	}
+	return twoProportionPValue(over(a, na), na, over(b, nb), nb)
+}
+
+// ksTest is the two-sample Kolmogorov-Smirnov test of the sorted samples a and b, the quantiles of na and nb latencies,
+// returning the largest distance between their distributions and the asymptotic p-value of them being the same
+func ksTest(a, b []time.Duration, na, nb int) (d, p float64) {
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		x := a[i]
+		if b[j] < x {
+			x = b[j]
+		}
+		for i < len(a) && a[i] == x {
+			i++
+		}
+		for j < len(b) && b[j] == x {
+			j++
+		}
+		d = math.Max(d, math.Abs(float64(i)/float64(len(a))-float64(j)/float64(len(b))))
+	}
+	n := math.Sqrt(float64(na) * float64(nb) / float64(na+nb))
+	return d, kolmogorovQ((n + 0.12 + 0.11/n) * d)
+}
+
+// kolmogorovQ is the complementary cumulative Kolmogorov distribution
+func kolmogorovQ(lambda float64) float64 {
+	if lambda < 0.2 {
+		return 1
+	}
+	q, sign := 0.0, 2.0
+	for k := 1; k <= 100; k++ {
+		term := sign * math.Exp(-2*float64(k*k)*lambda*lambda)
+		q += term
+		if math.Abs(term) < 1e-12 {
+			break
+		}
+		sign = -sign
+	}
+	return math.Max(0, math.Min(1, q))
+}
+
+// printComparison prints each metric of the run next to the baseline, the regressions last
+func printComparison(w io.Writer, c comparison) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "METRIC\tBASELINE\tCURRENT\tCHANGE\tP-VALUE\tSTATUS\n")
+	for _, d := range c.Metrics {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%.3g\t%s\n", d.Name, d.format(d.Baseline), d.format(d.Current), d.formatChange(d.Change), d.PValue, d.Status)
+	}
+	tw.Flush()
+	if !c.regressed() {
+		fmt.Fprintln(w, "no regression against "+c.Baseline)
+		return
+	}
+	for _, d := range c.Metrics {
+		if d.Status == statusRegression {
+			fmt.Fprintf(w, "REGRESSION: %s %s, over the %s tolerance\n", d.Name, d.formatChange(d.Change), strings.TrimPrefix(d.formatChange(d.Tolerance), "+"))
+		}
+	}
+}
+
+// format prints a value of the metric
+func (d metricDiff) format(v float64) string {
+	switch d.Unit {
+	case "ratio":
+		return fmt.Sprintf("%.2f%%", 100*v)
+	case "seconds":
+		return time.Duration(v * float64(time.Second)).String()
+	case "distance":
+		return fmt.Sprintf("D=%.3f", v)
+	}
+	return fmt.Sprintf("%.1f", v)
+}
+
+// formatChange prints a change of the metric, in percentage points for the error rate and percent otherwise
+func (d metricDiff) formatChange(v float64) string {
+	switch d.Unit {
+	case "ratio":
+		return fmt.Sprintf("%+.2fpt", 100*v)
+	case "distance":
+		return d.format(v)
+	}
+	return fmt.Sprintf("%+.1f%%", 100*v)
+}
+
+// writeVerdict writes the comparison as JSON to path, - for stdout
+func writeVerdict(path string, c comparison) error {
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	if path == "-" {
+		_, err = os.Stdout.Write(b)
+		return err
+	}
+	return os.WriteFile(path, b, 0o644)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testRun returns the results of a 100s run, its n latencies spread from 1ms to 1ms*scale*n/1000
+func testRun(n int, scale float64, failures, disconnects, messages int) runResults {
+	r := runResults{Duration: 100 * time.Second, Sessions: 1000 - failures, Failures: failures, Disconnects: disconnects, Pings: messages / 2, Pongs: messages / 2}
+	for i := 1; i <= n; i++ {
+		r.Latencies = append(r.Latencies, time.Duration(scale*float64(i)*float64(time.Millisecond)/float64(n)*1000))
+	}
+	return r
+}
+
+func TestStatisticalTests(t *testing.T) {
+	base := testRun(1000, 1, 0, 0, 0)
+	if d, p := ksTest(base.Latencies, base.Latencies, 1000, 1000); d != 0 || p != 1 {
+		t.Errorf("same samples: got d %v, p %v", d, p)
+	}
+	slower := testRun(1000, 1.2, 0, 0, 0).Latencies
+	if d, p := ksTest(base.Latencies, slower, 1000, 1000); math.Abs(d-1/6.0) > 0.01 || p > 1e-5 {
+		t.Errorf("samples 20%% slower: got d %v, p %v", d, p)
+	}
+	// the same quantiles of 20 latencies are not significant
+	if _, p := ksTest(base.Latencies, slower, 20, 20); p < 0.5 {
+		t.Errorf("quantiles of 20 latencies 20%% slower: got p %v", p)
+	}
+	// 3 samples over the baseline p99 instead of 1 is noise, 182 over its p90 instead of 100 is not
+	small := testRun(100, 1, 0, 0, 0)
+	if p := quantileShiftPValue(small.Latencies, testRun(100, 1.02, 0, 0, 0).Latencies, 100, 100, small.percentile(0.99)); p < 0.1 {
+		t.Errorf("p99 of 100 samples 2%% slower: got p %v", p)
+	}
+	if p := quantileShiftPValue(base.Latencies, testRun(1000, 1.1, 0, 0, 0).Latencies, 1000, 1000, base.percentile(0.9)); p > 1e-4 {
+		t.Errorf("p90 of samples 10%% slower: got p %v", p)
+	}
+	if p := quantileShiftPValue(base.Latencies, testRun(1000, 1.1, 0, 0, 0).Latencies, 100000, 100000, base.percentile(0.99)); p > 1e-10 {
+		t.Errorf("p99 of quantiles of 100000 latencies 10%% slower: got p %v", p)
+	}
+	if p := twoProportionPValue(5, 1000, 30, 1000); p > 1e-4 {
+		t.Errorf("0.5%% and 3%% errors: got p %v", p)
+	}
+	if p := twoProportionPValue(10, 1000, 12, 1000); p < 0.5 {
+		t.Errorf("1%% and 1.2%% errors: got p %v", p)
+	}
+	if p := twoProportionPValue(0, 1000, 0, 1000); p != 1 {
+		t.Errorf("no errors: got p %v", p)
+	}
+	if p := rateRatioPValue(100000, 100, 90000, 100); p > 1e-10 {
+		t.Errorf("10%% less messages: got p %v", p)
+	}
+	if p := rateRatioPValue(100000, 100, 99900, 100); p < 0.5 {
+		t.Errorf("0.1%% less messages: got p %v", p)
+	}
+}
+
+func TestSaveResults(t *testing.T) {
+	stats := &sessionStats{}
+	for i := 3 * maxSavedLatencies; i > 0; i-- {
+		stats.pong(time.Duration(i) * time.Microsecond)
+	}
+	r := newRunResults(stats, time.Unix(1700000000, 0).UTC(), time.Minute, transportGRPC, 10)
+	if len(r.Latencies) != maxSavedLatencies || r.Latencies[0] != time.Microsecond || r.Latencies[maxSavedLatencies-1] != 3*maxSavedLatencies*time.Microsecond {
+		t.Fatalf("got %d latencies from %v to %v", len(r.Latencies), r.Latencies[0], r.Latencies[len(r.Latencies)-1])
+	}
+	if got, want := r.percentile(0.5), stats.percentile(0.5); math.Abs(float64(got-want)) > float64(10*time.Microsecond) {
+		t.Errorf("got p50 %v, want %v", got, want)
+	}
+
+	path := filepath.Join(t.TempDir(), "baseline.json")
+	if err := saveResults(path, r); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadResults(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, r) {
+		t.Errorf("got %+v back", loaded)
+	}
+	if err := loaded.comparableWith(transportGRPC, 10); err != nil {
+		t.Errorf("same setup: %v", err)
+	}
+	if err := loaded.comparableWith(transportWebSocket, 10); err == nil {
+		t.Error("compared with a run over another transport")
+	}
+	if err := loaded.comparableWith(transportGRPC, 20); err == nil {
+		t.Error("compared with a run with more clients")
+	}
+	if _, err := loadResults(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("loaded a missing baseline")
+	}
+}
+
+func TestCompareRuns(t *testing.T) {
+	tol := tolerances{latency: 0.1, errorRate: 0.01, throughput: 0.1, alpha: 0.01}
+	base := testRun(5000, 1, 0, 15, 100000)
+	for _, test := range []struct {
+		desc   string
+		run    runResults
+		status map[string]string
+	}{
+		{"same run", testRun(5000, 1, 0, 15, 100000), map[string]string{
+			"error rate": statusSame, "latency distribution": statusSame, "p99 latency": statusSame, "messages/s": statusSame,
+		}},
+		{"slower, more errors and less messages", testRun(5000, 1.3, 10, 40, 80000), map[string]string{
+			"error rate": statusRegression, "latency distribution": statusChanged, "p50 latency": statusRegression, "p99 latency": statusRegression, "messages/s": statusRegression,
+		}},
+		{"slightly slower, within the tolerances", testRun(5000, 1.05, 0, 12, 96000), map[string]string{
+			"error rate": statusSame, "p99 latency": statusChanged, "messages/s": statusChanged,
+		}},
+		{"faster", testRun(5000, 0.5, 0, 0, 150000), map[string]string{
+			"error rate": statusImproved, "p99 latency": statusImproved, "messages/s": statusImproved,
+		}},
+		{"no latency", testRun(0, 1, 0, 15, 100000), map[string]string{
+			"latency distribution": statusNoData, "p99 latency": statusNoData,
+		}},
+	} {
+		c := compareRuns("base.json", base, test.run, tol)
+		regression := false
+		for _, d := range c.Metrics {
+			if want, ok := test.status[d.Name]; ok && d.Status != want {
+				t.Errorf("%s: %s changed by %v with p %v is %s, want %s", test.desc, d.Name, d.Change, d.PValue, d.Status, want)
+			}
+			regression = regression || d.Status == statusRegression
+		}
+		if c.regressed() != regression {
+			t.Errorf("%s: got verdict %s", test.desc, c.Verdict)
+		}
+	}
+
+	var out bytes.Buffer
+	printComparison(&out, compareRuns("base.json", base, testRun(5000, 1.3, 0, 15, 100000), tol))
+	if !strings.Contains(out.String(), "REGRESSION: p99 latency +30.0%, over the 10.0% tolerance") {
+		t.Errorf("got\n%s", out.String())
+	}
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/prune998/goHelloGrpcStream/helloworld/client"
+	pb "github.com/prune998/goHelloGrpcStream/helloworld/helloworld"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errQueued is returned for the streams not open in time on a shared connection,
+// which gRPC queues once the server MaxConcurrentStreams is reached
+var errQueued = errors.New("stream queued by the HTTP/2 MaxConcurrentStreams of the connection")
+
+// errOpenTimeout is returned for the streams not open in time on their own connection
+var errOpenTimeout = errors.New("stream not open in time")
+
+// capacityProbe finds how many concurrent streams the path to the server sustains.
+// The streams grow by factor from start until a step fails, then the limit is bisected.
+type capacityProbe struct {
+	logger    kitlog.Logger
+	transport string
+	target    string
+	opts      client.Options
+	dialOpts  []grpc.DialOption
+
+	// perConn opens all the streams on one connection, instead of one connection per stream
+	perConn   bool
+	start     int
+	max       int
+	factor    float64
+	precision int
+	// hold is how long the streams of a step ping the server
+	hold time.Duration
+	// openTimeout is how long a stream has to open and get its first Pong
+	openTimeout time.Duration
+	// latencyFactor fails the steps whose p99 Pong latency is over latencyFactor times the first step's
+	latencyFactor float64
+}
+
+// capacityStep is what happened with a number of streams
+type capacityStep struct {
+	streams int
+	opened  int
+	p99     time.Duration
+	// failure is the type of the first failure, empty when the streams were sustained
+	failure string
+	err     error
+}
+
+// capacityReport is the result of the probe
+type capacityReport struct {
+	steps []capacityStep
+	// sustained is the most streams sustained, failed the fewest that failed, 0 if none
+	sustained, failed int
+	// first is a copy of the step that failed first, steps growing after it
+	first *capacityStep
+}
+
+// run grows the streams until a step fails, then bisects the limit
+func (p capacityProbe) run(ctx context.Context) capacityReport {
+	var report capacityReport
+	var baseline time.Duration
+	check := func(n int) bool {
+		p.logger.Log("msg", "capacity probe step", "streams", n)
+		s := p.step(ctx, n, baseline)
+		p.logger.Log("msg", "capacity probe step done", "streams", n, "opened", s.opened, "p99", s.p99, "failure", s.failure, "err", s.err)
+		report.steps = append(report.steps, s)
+		if s.failure != "" {
+			if report.first == nil {
+				report.first = &s
+			}
+			return false
+		}
+		if baseline == 0 {
+			baseline = s.p99
+		}
+		return true
+	}
+
+	low, high := 0, 0
+	for n := p.start; ctx.Err() == nil; {
+		if !check(n) {
+			high = n
+			break
+		}
+		low = n
+		if n >= p.max {
+			break
+		}
+		next := int(float64(n) * p.factor)
+		if next <= n {
+			next = n + 1
+		}
+		if next > p.max {
+			next = p.max
+		}
+		n = next
+	}
+	for high > 0 && high-low > p.precision && ctx.Err() == nil {
+		mid := low + (high-low)/2
+		if check(mid) {
+			low = mid
+		} else {
+			high = mid
+		}
+	}
+	report.sustained, report.failed = low, high
+	return report
+}
+
+// step opens n streams, makes them ping the server for hold and closes them
+func (p capacityProbe) step(ctx context.Context, n int, baseline time.Duration) capacityStep {
+	result := capacityStep{streams: n}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	open := func(ctx context.Context, name string) (session, error) {
+		return openSession(ctx, p.transport, p.target, name, p.opts, p.dialOpts)
+	}
+	if p.perConn {
+		conn, err := grpc.Dial(p.target, p.dialOpts...)
+		if err != nil {
+			result.failure, result.err = classifyFailure(err), err
+			return result
+		}
+		defer conn.Close()
+		c := pb.NewGreeterClient(conn)
+		open = func(ctx context.Context, name string) (session, error) {
+			stream, err := c.SayHelloStream(outgoing(ctx, name, p.opts))
+			if err != nil {
+				return nil, err
+			}
+			return &grpcSession{stream: stream}, nil
+		}
+	}
+
+	var (
+		stats    sessionStats
+		mu       sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+			// no need to wait for the other streams
+			cancel()
+		}
+	}
+	opened := make(chan struct{}, n)
+	allOpen := make(chan struct{})
+	go func() {
+		for i := 0; i < n; i++ {
+			select {
+			case <-opened:
+			case <-ctx.Done():
+				return
+			}
+		}
+		close(allOpen)
+	}()
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s, latency, err := p.openStream(ctx, open, fmt.Sprintf("capacity-%d", i))
+			if err != nil {
+				if ctx.Err() == nil {
+					fail(err)
+				}
+				return
+			}
+			defer s.Close()
+			defer s.CloseSend()
+			stats.opened()
+			stats.pong(latency)
+			opened <- struct{}{}
+
+			// the streams are held together once all are open
+			select {
+			case <-allOpen:
+			case <-ctx.Done():
+				return
+			}
+			deadline := time.Now().Add(p.hold)
+			for time.Now().Before(deadline) {
+				select {
+				case <-time.After(p.hold / 5):
+				case <-ctx.Done():
+					return
+				}
+				latency, err := capacityPing(ctx, s, p.openTimeout)
+				if err != nil {
+					if ctx.Err() == nil {
+						fail(fmt.Errorf("disconnected: %w", err))
+					}
+					return
+				}
+				stats.pong(latency)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	result.opened = stats.sessions
+	result.p99 = stats.percentile(0.99)
+	if firstErr != nil {
+		result.failure, result.err = classifyFailure(firstErr), firstErr
+		return result
+	}
+	if baseline == 0 || p.latencyFactor <= 0 {
+		return result
+	}
+	// the latency of a quiet local server is too small to compare
+	if baseline < time.Millisecond {
+		baseline = time.Millisecond
+	}
+	if float64(result.p99) > float64(baseline)*p.latencyFactor {
+		result.failure = fmt.Sprintf("latency, p99 over %.1f times the %v of the first step", p.latencyFactor, baseline)
+	}
+	return result
+}
+
+// openStream opens a stream and waits for its first Pong, returning its latency
+func (p capacityProbe) openStream(ctx context.Context, open func(context.Context, string) (session, error), name string) (session, time.Duration, error) {
+	type opening struct {
+		s   session
+		err error
+	}
+	done := make(chan opening, 1)
+	go func() {
+		s, err := open(ctx, name)
+		done <- opening{s, err}
+	}()
+
+	timer := time.NewTimer(p.openTimeout)
+	defer timer.Stop()
+	select {
+	case o := <-done:
+		if o.err != nil {
+			return nil, 0, o.err
+		}
+		latency, err := capacityPing(ctx, o.s, p.openTimeout)
+		if err != nil {
+			o.s.Close()
+			return nil, 0, err
+		}
+		return o.s, latency, nil
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	// close the stream if it opens after all
+	go func() {
+		if o := <-done; o.err == nil {
+			o.s.Close()
+		}
+	}()
+	if ctx.Err() != nil {
+		return nil, 0, ctx.Err()
+	}
+	if p.perConn {
+		return nil, 0, errQueued
+	}
+	return nil, 0, errOpenTimeout
+}
+
+// capacityPing sends a Ping and waits for the Pong, returning its latency
+func capacityPing(ctx context.Context, s session, timeout time.Duration) (time.Duration, error) {
+	start := time.Now()
+	if err := s.Send(&pb.HelloRequest{Name: payload("Ping capacity")}); err != nil {
+		return 0, err
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.Recv()
+		if err == io.EOF {
+			err = errors.New("the server ended the stream")
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		return time.Since(start), err
+	case <-time.After(timeout):
+		return 0, fmt.Errorf("no Pong within %v, is the server running with -reply ?", timeout)
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+// classifyFailure tells which limit an error comes from
+func classifyFailure(err error) string {
+	switch {
+	case errors.Is(err, syscall.EMFILE) || strings.Contains(err.Error(), "too many open files"):
+		return "file descriptors, raise ulimit -n"
+	case errors.Is(err, errQueued):
+		return "server MaxConcurrentStreams per connection"
+	case errors.Is(err, errOpenTimeout):
+		return "open timeout"
+	}
+	switch status.Code(err) {
+	case codes.ResourceExhausted:
+		return "rejected by the server limits"
+	case codes.Unavailable:
+		if strings.Contains(err.Error(), "overflow") {
+			return "rejected by a proxy stream limit"
+		}
+		if errors.Is(err, syscall.ECONNREFUSED) || strings.Contains(err.Error(), "connection refused") {
+			return "connection refused"
+		}
+		return "unavailable"
+	case codes.Unknown:
+		return "error"
+	}
+	return status.Code(err).String()
+}
+
+// printCapacityReport prints each step of the probe, then the limit found
+func printCapacityReport(w io.Writer, report capacityReport, perConn bool) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STREAMS\tOPENED\tP99\tRESULT")
+	for _, s := range report.steps {
+		result := "sustained"
+		if s.failure != "" {
+			result = s.failure
+			if s.err != nil {
+				result += ": " + s.err.Error()
+			}
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%v\t%s\n", s.streams, s.opened, s.p99, result)
+	}
+	tw.Flush()
+
+	scope := "in total, one connection per stream"
+	if perConn {
+		scope = "on one connection"
+	}
+	fmt.Fprintln(w)
+	if report.failed == 0 {
+		fmt.Fprintf(w, "%d streams sustained %s, no failure found\n", report.sustained, scope)
+		return
+	}
+	fmt.Fprintf(w, "sustainable maximum: %d streams %s, %d failed\n", report.sustained, scope, report.failed)
+	if report.first != nil {
+		fmt.Fprintf(w, "first failure at %d streams: %s\n", report.first.streams, report.first.failure)
+	}
+}
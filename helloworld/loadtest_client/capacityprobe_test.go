@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+	pb "github.com/prune998/goHelloGrpcStream/helloworld/helloworld"
+	"github.com/prune998/goHelloGrpcStream/helloworld/proxy"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// startPongServer starts a pongServer with opts and returns its address
+func startPongServer(t *testing.T, opts ...grpc.ServerOption) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer(opts...)
+	pb.RegisterGreeterServer(s, pongServer{})
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	return lis.Addr().String()
+}
+
+// testCapacityProbe returns a quick probe of target
+func testCapacityProbe(target string, perConn bool) capacityProbe {
+	return capacityProbe{
+		logger:      kitlog.NewNopLogger(),
+		transport:   transportGRPC,
+		target:      target,
+		dialOpts:    []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())},
+		perConn:     perConn,
+		start:       2,
+		max:         64,
+		factor:      2,
+		precision:   1,
+		hold:        100 * time.Millisecond,
+		openTimeout: 500 * time.Millisecond,
+	}
+}
+
+func TestCapacityProbePerConn(t *testing.T) {
+	probe := testCapacityProbe(startPongServer(t, grpc.MaxConcurrentStreams(5)), true)
+	report := probe.run(context.Background())
+
+	if report.sustained != 5 || report.failed != 6 {
+		t.Errorf("got %d sustained and %d failed streams, want 5 and 6", report.sustained, report.failed)
+	}
+	if report.first == nil || report.first.failure != classifyFailure(errQueued) {
+		t.Errorf("got first failure %+v", report.first)
+	}
+}
+
+func TestCapacityProbeProxy(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	p := proxy.New(proxy.Options{Upstream: startPongServer(t), MaxStreams: 12, Logger: logger})
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.Serve(lis)
+	t.Cleanup(p.Stop)
+
+	probe := testCapacityProbe(lis.Addr().String(), false)
+	report := probe.run(context.Background())
+
+	if report.sustained != 12 || report.failed != 13 {
+		t.Errorf("got %d sustained and %d failed streams, want 12 and 13", report.sustained, report.failed)
+	}
+	var out bytes.Buffer
+	printCapacityReport(&out, report, false)
+	for _, want := range []string{"sustainable maximum: 12 streams", "first failure at 16 streams: rejected by a proxy stream limit"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("no %q in the report:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestCapacityProbeNoLimit(t *testing.T) {
+	probe := testCapacityProbe(startPongServer(t), false)
+	probe.max = 8
+	report := probe.run(context.Background())
+
+	if report.sustained != 8 || report.failed != 0 || len(report.steps) != 3 {
+		t.Errorf("got %d sustained and %d failed streams in %d steps", report.sustained, report.failed, len(report.steps))
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/prune998/goHelloGrpcStream/helloworld/client"
+	pb "github.com/prune998/goHelloGrpcStream/helloworld/helloworld"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// benchResult is what one compression setting cost during the benchmark
+type benchResult struct {
+	compression string
+	messages    int64
+	bytes       client.Bytes
+	cpu         time.Duration
+	err         error
+}
+
+// wireRatio is the wire size of the sent messages compared to their uncompressed size
+func (r benchResult) wireRatio() float64 {
+	if r.bytes.Sent == 0 {
+		return 0
+	}
+	return float64(r.bytes.SentWire) / float64(r.bytes.Sent)
+}
+
+// cpuPerMessage is the client CPU used by each message
+func (r benchResult) cpuPerMessage() time.Duration {
+	if r.messages == 0 {
+		return 0
+	}
+	return r.cpu / time.Duration(r.messages)
+}
+
+// wirePerMessage is the number of bytes sent and received on the wire for each message
+func (r benchResult) wirePerMessage() float64 {
+	if r.messages == 0 {
+		return 0
+	}
+	return float64(r.bytes.SentWire+r.bytes.ReceivedWire) / float64(r.messages)
+}
+
+// payload returns the message sent by the clients, padded to -payloadsize with compressible text
+func payload(prefix string) string {
+	if len(prefix) >= *payloadSize {
+		return prefix
+	}
+	filler := " hello from the load tester"
+	return prefix + strings.Repeat(filler, (*payloadSize-len(prefix))/len(filler)+1)[:*payloadSize-len(prefix)]
+}
+
+// runCompressionBench runs the same streaming load once per compression setting,
+// one after the other, and reports the bandwidth and CPU used by each of them
+func runCompressionBench(ctx context.Context, logger kitlog.Logger, opts client.Options, settings []string) error {
+	var results []benchResult
+	for _, setting := range settings {
+		if setting == "none" {
+			setting = ""
+		}
+		opts.Compression = setting
+		r := benchCompression(ctx, opts, *clients, *benchDuration)
+		if r.err != nil {
+			return fmt.Errorf("compression %q: %w", setting, r.err)
+		}
+		logger.Log("msg", "compression bench done", "compression", r.compression, "messages", r.messages,
+			"sent", r.bytes.Sent, "sentWire", r.bytes.SentWire, "receivedWire", r.bytes.ReceivedWire, "cpu", r.cpu)
+		results = append(results, r)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	printCompressionReport(os.Stdout, results)
+	return nil
+}
+
+// benchCompression opens one stream per client and sends messages back to back for duration
+func benchCompression(ctx context.Context, opts client.Options, clients int, duration time.Duration) benchResult {
+	result := benchResult{compression: opts.Compression}
+	if result.compression == "" {
+		result.compression = "none"
+	}
+
+	counter := &client.ByteCounter{}
+	dialOpts, err := opts.DialOptions()
+	if err != nil {
+		result.err = err
+		return result
+	}
+	dialOpts = append(dialOpts, grpc.WithStatsHandler(counter))
+
+	ctx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var messages int64
+	cpuBefore := cpuTime()
+	for i := 0; i < clients; i++ {
+		conn, err := grpc.Dial(*server, dialOpts...)
+		if err != nil {
+			result.err = err
+			return result
+		}
+		defer conn.Close()
+		stream, err := pb.NewGreeterClient(conn).SayHelloStream(outgoing(ctx, strconv.Itoa(i), withHeaders(opts, i)))
+		if err != nil {
+			result.err = err
+			return result
+		}
+
+		// drain the replies, if the server sends any
+		go func() {
+			for {
+				if _, err := stream.Recv(); err != nil {
+					return
+				}
+			}
+		}()
+
+		msg := &pb.HelloRequest{Name: payload(fmt.Sprintf("Ping %d", i))}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				if err := stream.Send(msg); err != nil {
+					return
+				}
+				atomic.AddInt64(&messages, 1)
+			}
+			stream.CloseSend()
+		}()
+	}
+	wg.Wait()
+
+	result.cpu = cpuTime() - cpuBefore
+	result.messages = atomic.LoadInt64(&messages)
+	result.bytes = counter.Snapshot()
+	return result
+}
+
+// printCompressionReport prints one line per setting, with the deltas against the first one
+func printCompressionReport(w io.Writer, results []benchResult) {
+	if len(results) == 0 {
+		return
+	}
+	base := results[0]
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "COMPRESSION\tMESSAGES\tSENT\tSENT WIRE\tRATIO\tWIRE/MSG\tΔ WIRE/MSG\tCPU\tCPU/MSG\tΔ CPU/MSG")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%.2f\t%.0f\t%s\t%v\t%v\t%s\n",
+			r.compression, r.messages, r.bytes.Sent, r.bytes.SentWire, r.wireRatio(),
+			r.wirePerMessage(), delta(r.wirePerMessage(), base.wirePerMessage()),
+			r.cpu.Round(time.Millisecond), r.cpuPerMessage(),
+			delta(float64(r.cpuPerMessage()), float64(base.cpuPerMessage())))
+	}
+	tw.Flush()
+}
+
+// delta formats the relative change of v against base
+func delta(v, base float64) string {
+	if base == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%+.1f%%", (v-base)/base*100)
+}
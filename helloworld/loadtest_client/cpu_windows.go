@@ -0,0 +1,8 @@
+package main
+
+import "time"
+
+// cpuTime is not available on windows, CPU deltas will show as 0
+func cpuTime() time.Duration {
+	return 0
+}
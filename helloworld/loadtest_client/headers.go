@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/prune998/goHelloGrpcStream/helloworld/client"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/metadata"
+)
+
+// headerTemplates are the -header flags, which can be repeated. Their values are templates
+// rendered for each simulated client, like x-user=user-{{.ID}} or x-canary={{if eq (mod .Index 10) 0}}true{{end}}
+type headerTemplates []headerTemplate
+
+type headerTemplate struct {
+	key, value string
+	tmpl       *template.Template
+}
+
+// templateFuncs are the functions of the header templates
+var templateFuncs = template.FuncMap{
+	"mod": func(a, b int) int { return a % b },
+}
+
+// headers are the -header flags
+var headers headerTemplates
+
+func (h *headerTemplates) String() string {
+	var pairs []string
+	for _, t := range *h {
+		pairs = append(pairs, t.key+"="+t.value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (h *headerTemplates) Set(s string) error {
+	key, value, err := client.ParseHeader(s)
+	if err != nil {
+		return err
+	}
+	tmpl, err := template.New(key).Funcs(templateFuncs).Option("missingkey=error").Parse(value)
+	if err != nil {
+		return err
+	}
+	*h = append(*h, headerTemplate{key: key, value: value, tmpl: tmpl})
+	return nil
+}
+
+// headerData is what the header templates of a client can use
+type headerData struct {
+	// ID is the client id, Index the same as a number
+	ID    string
+	Index int
+}
+
+// render returns the headers of the client with index
+func (h headerTemplates) render(index int) (client.Headers, error) {
+	headers := client.Headers{}
+	data := headerData{ID: strconv.Itoa(index), Index: index}
+	for _, t := range h {
+		var value strings.Builder
+		if err := t.tmpl.Execute(&value, data); err != nil {
+			return nil, err
+		}
+		headers[t.key] = append(headers[t.key], value.String())
+	}
+	return headers, nil
+}
+
+// withHeaders returns opts with the -header flags rendered for the client with index.
+// The headers go in the metadata of each stream rather than in the dial options, shared by all the clients.
+func withHeaders(opts client.Options, index int) client.Options {
+	// the templates were checked with the flags
+	opts.Headers, _ = headers.render(index)
+	return opts
+}
+
+// outgoing adds the client name and the headers of opts to the metadata of ctx
+func outgoing(ctx context.Context, name string, opts client.Options) context.Context {
+	ctx = metadata.AppendToOutgoingContext(ctx, client.NameHeader, name)
+	for key, values := range opts.Headers {
+		for _, v := range values {
+			ctx = metadata.AppendToOutgoingContext(ctx, key, v)
+		}
+	}
+	return ctx
+}
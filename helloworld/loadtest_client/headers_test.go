@@ -0,0 +1,51 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/prune998/goHelloGrpcStream/helloworld/client"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestHeaderTemplates(t *testing.T) {
+	var h headerTemplates
+	for _, s := range []string{"x-user=user-{{.ID}}", "x-canary={{if eq (mod .Index 10) 0}}true{{else}}false{{end}}", "x-static=on"} {
+		if err := h.Set(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for index, want := range map[int]client.Headers{
+		0:  {"x-user": {"user-0"}, "x-canary": {"true"}, "x-static": {"on"}},
+		13: {"x-user": {"user-13"}, "x-canary": {"false"}, "x-static": {"on"}},
+	} {
+		got, err := h.render(index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("client %d: got %v, want %v", index, got, want)
+		}
+	}
+
+	for _, s := range []string{"x-user", "x-user={{.ID", ":authority=example.com"} {
+		if err := h.Set(s); err == nil {
+			t.Errorf("%q was accepted", s)
+		}
+	}
+	var bad headerTemplates
+	bad.Set("x-user={{.Name}}")
+	if _, err := bad.render(0); err == nil {
+		t.Error("rendered an unknown field")
+	}
+}
+
+func TestOutgoing(t *testing.T) {
+	opts := client.Options{Headers: client.Headers{"x-user": {"user-1"}}}
+	md, _ := metadata.FromOutgoingContext(outgoing(context.Background(), "1", opts))
+	want := metadata.MD{"x-client-name": {"1"}, "x-user": {"user-1"}}
+	if !reflect.DeepEqual(md, want) {
+		t.Errorf("got %v, want %v", md, want)
+	}
+}
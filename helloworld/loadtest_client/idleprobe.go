@@ -0,0 +1,284 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/prune998/goHelloGrpcStream/helloworld/client"
+	pb "github.com/prune998/goHelloGrpcStream/helloworld/helloworld"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// idleProbe finds after how long idle streams are killed on the path to the server.
+// Streams idle for min, min*factor... up to max run in parallel, then the range between the longest
+// surviving interval and the shortest killed one is split between streams again, until it is under precision.
+type idleProbe struct {
+	logger    kitlog.Logger
+	transport string
+	target    string
+	opts      client.Options
+	dialOpts  []grpc.DialOption
+
+	min, max  time.Duration
+	factor    float64
+	precision time.Duration
+	// streams is the number of parallel streams splitting the range after the first round
+	streams int
+	// replyTimeout is how long to wait for a Pong, a stream silently dropped never gets one
+	replyTimeout time.Duration
+}
+
+// idleResult is what happened to a stream idle for interval
+type idleResult struct {
+	round    int
+	interval time.Duration
+	survived bool
+	// after is how long the stream was idle when it was found dead
+	after time.Duration
+	// onPing is set when the stream was only found dead when sending the Ping after the idle interval
+	onPing bool
+	// broken is set when the stream did not work before idling, telling nothing about the idle timeout
+	broken bool
+	err    error
+}
+
+// reason describes why the stream died
+func (r idleResult) reason() string {
+	if r.err == nil {
+		return ""
+	}
+	if st, ok := status.FromError(r.err); ok {
+		return st.Code().String() + ": " + st.Message()
+	}
+	return r.err.Error()
+}
+
+// idleReport is the result of the probe
+type idleReport struct {
+	results []idleResult
+	// survived is the longest idle interval a stream survived, killed the shortest one that killed it
+	survived, killed time.Duration
+}
+
+// run runs rounds of parallel streams until the idle timeout is known within precision,
+// or a round tells nothing more
+func (p idleProbe) run(ctx context.Context) idleReport {
+	var report idleReport
+	intervals := geometric(p.min, p.max, p.factor)
+	for round := 1; len(intervals) > 0 && ctx.Err() == nil; round++ {
+		p.logger.Log("msg", "idle probe round", "round", round, "intervals", fmt.Sprint(intervals))
+		survived, killed := report.survived, report.killed
+		report.add(p.round(ctx, round, intervals))
+		if report.killed == 0 || report.killed-report.survived <= p.precision {
+			break
+		}
+		// the streams of the round all broke, the next one would probe the same intervals
+		if report.survived == survived && report.killed == killed {
+			p.logger.Log("msg", "idle probe round narrowed nothing, stopping", "round", round)
+			break
+		}
+		intervals = split(report.survived, report.killed, p.streams)
+	}
+	return report
+}
+
+// add records results and narrows the range of the idle timeout
+func (r *idleReport) add(results []idleResult) {
+	r.results = append(r.results, results...)
+	for _, res := range results {
+		if res.survived || res.broken {
+			continue
+		}
+		// a stream reset while idle tells when the timeout fired
+		killed := res.interval
+		if !res.onPing && res.after < killed {
+			killed = res.after
+		}
+		if r.killed == 0 || killed < r.killed {
+			r.killed = killed
+		}
+	}
+	r.survived = 0
+	for _, res := range r.results {
+		if res.survived && res.interval > r.survived && (r.killed == 0 || res.interval < r.killed) {
+			r.survived = res.interval
+		}
+	}
+}
+
+// round runs one stream per interval, in parallel
+func (p idleProbe) round(ctx context.Context, round int, intervals []time.Duration) []idleResult {
+	results := make([]idleResult, len(intervals))
+	var wg sync.WaitGroup
+	for i, interval := range intervals {
+		wg.Add(1)
+		go func(i int, interval time.Duration) {
+			defer wg.Done()
+			results[i] = p.probe(ctx, interval)
+			results[i].round = round
+			p.logger.Log("msg", "idle probe", "interval", interval, "survived", results[i].survived,
+				"after", results[i].after, "err", results[i].err)
+		}(i, interval)
+	}
+	wg.Wait()
+	return results
+}
+
+// probe opens a stream, checks it works, leaves it idle for interval and checks it still works
+func (p idleProbe) probe(ctx context.Context, interval time.Duration) idleResult {
+	result := idleResult{interval: interval}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	s, err := openSession(ctx, p.transport, p.target, "idle-"+interval.String(), p.opts, p.dialOpts)
+	if err != nil {
+		result.broken, result.err = true, err
+		return result
+	}
+	defer s.Close()
+
+	replies := make(chan error)
+	go func() {
+		for {
+			_, err := s.Recv()
+			if err == io.EOF {
+				err = fmt.Errorf("the server ended the stream")
+			}
+			select {
+			case replies <- err:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	pingPong := func() error {
+		if err := s.Send(&pb.HelloRequest{Name: payload("Ping idle")}); err != nil {
+			// the reason comes with Recv
+			select {
+			case err := <-replies:
+				return err
+			case <-time.After(p.replyTimeout):
+				return err
+			}
+		}
+		select {
+		case err := <-replies:
+			return err
+		case <-time.After(p.replyTimeout):
+			return fmt.Errorf("no Pong within %v, is the server running with -reply ?", p.replyTimeout)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if err := pingPong(); err != nil {
+		result.broken, result.err = true, fmt.Errorf("before idling: %w", err)
+		return result
+	}
+
+	idle := time.Now()
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	select {
+	case err := <-replies:
+		if err == nil {
+			err = fmt.Errorf("got an unexpected message while idle")
+		}
+		result.after, result.err = time.Since(idle), err
+		return result
+	case <-timer.C:
+	case <-ctx.Done():
+		result.broken, result.err = true, ctx.Err()
+		return result
+	}
+
+	result.after = time.Since(idle)
+	if err := pingPong(); err != nil {
+		result.onPing, result.err = true, err
+		return result
+	}
+	result.survived = true
+	s.CloseSend()
+	return result
+}
+
+// geometric returns min, min*factor... up to max
+func geometric(min, max time.Duration, factor float64) []time.Duration {
+	var intervals []time.Duration
+	for d := min; d <= max && d > 0; d = time.Duration(math.Ceil(float64(d) * factor)) {
+		intervals = append(intervals, d)
+		if factor <= 1 {
+			break
+		}
+	}
+	return intervals
+}
+
+// split returns n intervals evenly spread between low and high, excluded
+func split(low, high time.Duration, n int) []time.Duration {
+	var intervals []time.Duration
+	step := (high - low) / time.Duration(n+1)
+	for i := 1; i <= n && step > 0; i++ {
+		intervals = append(intervals, low+time.Duration(i)*step)
+	}
+	return intervals
+}
+
+// printIdleReport prints each stream of the probe, then the idle timeout found
+func printIdleReport(w io.Writer, report idleReport) {
+	results := append([]idleResult(nil), report.results...)
+	sort.SliceStable(results, func(i, j int) bool { return results[i].interval < results[j].interval })
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ROUND\tIDLE\tRESULT\tDEAD AFTER\tREASON")
+	for _, r := range results {
+		result, after := "survived", ""
+		switch {
+		case r.survived:
+		case r.broken:
+			result = "failed"
+		case r.onPing:
+			result, after = "killed, found on Ping", r.after.Round(time.Millisecond).String()
+		default:
+			result, after = "killed while idle", r.after.Round(time.Millisecond).String()
+		}
+		fmt.Fprintf(tw, "%s\t%v\t%s\t%s\t%s\n", strconv.Itoa(r.round), r.interval, result, after, r.reason())
+	}
+	tw.Flush()
+
+	fmt.Fprintln(w)
+	switch {
+	case report.killed == 0:
+		fmt.Fprintf(w, "no idle stream was killed, the idle timeout is over %v\n", report.survived)
+		return
+	case report.survived == 0:
+		fmt.Fprintf(w, "the idle timeout is under %v\n", report.killed)
+	default:
+		fmt.Fprintf(w, "idle timeout between %v and %v\n", report.survived, report.killed)
+	}
+	reasons := map[string]int{}
+	var keys []string
+	for _, r := range report.results {
+		if r.survived || r.broken {
+			continue
+		}
+		if reasons[r.reason()] == 0 {
+			keys = append(keys, r.reason())
+		}
+		reasons[r.reason()]++
+	}
+	for _, reason := range keys {
+		fmt.Fprintf(w, "%d streams killed by %s\n", reasons[reason], reason)
+	}
+}
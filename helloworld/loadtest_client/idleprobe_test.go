@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+	pb "github.com/prune998/goHelloGrpcStream/helloworld/helloworld"
+	"github.com/prune998/goHelloGrpcStream/helloworld/proxy"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// startIdleProxy starts srv behind a proxy ending the streams idle for idleTimeout, and returns the proxy address
+func startIdleProxy(t *testing.T, srv pb.GreeterServer, idleTimeout time.Duration) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	pb.RegisterGreeterServer(s, srv)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	p := proxy.New(proxy.Options{Upstream: lis.Addr().String(), IdleTimeout: idleTimeout, Logger: logger})
+	plis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.Serve(plis)
+	t.Cleanup(p.Stop)
+	return plis.Addr().String()
+}
+
+func TestIdleProbe(t *testing.T) {
+	probe := idleProbe{
+		logger:       kitlog.NewNopLogger(),
+		transport:    transportGRPC,
+		target:       startIdleProxy(t, pongServer{}, 300*time.Millisecond),
+		dialOpts:     []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())},
+		min:          100 * time.Millisecond,
+		max:          800 * time.Millisecond,
+		factor:       2,
+		precision:    60 * time.Millisecond,
+		streams:      2,
+		replyTimeout: time.Second,
+	}
+	report := probe.run(context.Background())
+
+	if report.survived < 200*time.Millisecond || report.killed < 280*time.Millisecond || report.killed > 400*time.Millisecond {
+		t.Errorf("got an idle timeout between %v and %v", report.survived, report.killed)
+	}
+	if report.killed-report.survived > probe.precision {
+		t.Errorf("the range %v-%v is over the precision", report.survived, report.killed)
+	}
+
+	var out bytes.Buffer
+	printIdleReport(&out, report)
+	if !strings.Contains(out.String(), "streams killed by Unavailable: stream timeout") {
+		t.Errorf("no reason in the report:\n%s", out.String())
+	}
+}
+
+// closingServer is a pongServer refusing the streams after the first ones
+type closingServer struct {
+	pongServer
+	streams *int64
+	open    int64
+}
+
+func (s closingServer) SayHelloStream(stream pb.Greeter_SayHelloStreamServer) error {
+	if atomic.AddInt64(s.streams, 1) > s.open {
+		return status.Error(codes.Unavailable, "closing")
+	}
+	return s.pongServer.SayHelloStream(stream)
+}
+
+func TestIdleProbeBrokenRound(t *testing.T) {
+	probe := idleProbe{
+		logger:       kitlog.NewNopLogger(),
+		transport:    transportGRPC,
+		target:       startIdleProxy(t, closingServer{streams: new(int64), open: 4}, 300*time.Millisecond),
+		dialOpts:     []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())},
+		min:          100 * time.Millisecond,
+		max:          800 * time.Millisecond,
+		factor:       2,
+		precision:    10 * time.Millisecond,
+		streams:      2,
+		replyTimeout: time.Second,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	report := probe.run(ctx)
+
+	// the first round finds the range, the second one breaks and the probe stops
+	if ctx.Err() != nil || len(report.results) != 6 {
+		t.Errorf("got %d results, %v", len(report.results), ctx.Err())
+	}
+	if report.survived != 200*time.Millisecond || report.killed < 280*time.Millisecond || report.killed > 400*time.Millisecond {
+		t.Errorf("got an idle timeout between %v and %v", report.survived, report.killed)
+	}
+}
+
+func TestIdleIntervals(t *testing.T) {
+	got := geometric(time.Second, 10*time.Second, 2)
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("geometric: got %v, want %v", got, want)
+	}
+
+	got = split(4*time.Second, 8*time.Second, 3)
+	want = []time.Duration{5 * time.Second, 6 * time.Second, 7 * time.Second}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("split: got %v, want %v", got, want)
+	}
+}
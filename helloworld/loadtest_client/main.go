@@ -19,7 +19,6 @@
 package main
 
 import (
-	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -27,159 +26,275 @@ import (
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"time"
 
-	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	kitlog "github.com/go-kit/log"
 	"github.com/namsral/flag"
+	"github.com/prune998/goHelloGrpcStream/helloworld/client"
 	pb "github.com/prune998/goHelloGrpcStream/helloworld/helloworld"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials"
 )
 
 var (
-	debug              = flag.Bool("debug", false, "display debugs")
-	server             = flag.String("server", "localhost:7788", "Greeter Server URL")
-	name               = flag.String("name", "world", "name of the client (will be displayed in the server)")
-	clients            = flag.Int("clients", 1, "number of clients to simulate")
-	sleepTime          = flag.Duration("sleeptime", 60*time.Second, "time before closing the HTTP2 Stream in seconds")
-	httpPort           = flag.String("httpport", "7787", "port to bind for HTTP")
-	version            = "no version set"
-	withTLS            = flag.Bool("tls", false, "whether to use TLS")
-	insecureSkipVerify = flag.Bool("insecureSkipVerify", true, "whether to ignore security checks")
+	debug               = flag.Bool("debug", false, "display debugs")
+	server              = flag.String("server", "localhost:7788", "Greeter Server URL, or unix:///path/to/socket")
+	name                = flag.String("name", "world", "name of the client (will be displayed in the server)")
+	clients             = flag.Int("clients", 1, "number of clients to simulate")
+	sleepTime           = flag.Duration("sleeptime", 60*time.Second, "time before closing the HTTP2 Stream in seconds")
+	httpPort            = flag.String("httpport", "7787", "port to bind for HTTP")
+	version             = "no version set"
+	withTLS             = flag.Bool("tls", false, "whether to use TLS")
+	insecureSkipVerify  = flag.Bool("insecureSkipVerify", true, "whether to ignore security checks")
+	caCert              = flag.String("cacert", "", "CA file to verify the server certificate")
+	cert                = flag.String("cert", "", "client certificate file for mTLS")
+	key                 = flag.String("key", "", "client key file for mTLS")
+	apiKey              = flag.String("apikey", "", "API key sent with each call")
+	token               = flag.String("token", "", "bearer token (JWT) sent with each call")
+	tokenFile           = flag.String("tokenfile", "", "file holding the bearer token, read before each call")
+	compressor          = flag.String("compression", "", "compressor used by the streams: gzip, zstd or snappy")
+	payloadSize         = flag.Int("payloadsize", 0, "pad the stream messages to this size in bytes")
+	transport           = flag.String("transport", transportGRPC, "transport of the streams: grpc, websocket or sse")
+	httpServer          = flag.String("httpserver", "localhost:7789", "Greeter Server HTTP address, or unix:///path/to/socket, used by the websocket and sse transports")
+	transportBench      = flag.String("transportbench", "", "comma separated transports (grpc,websocket,sse) to compare instead of running the load test")
+	compressionBench    = flag.String("compressionbench", "", "comma separated compression settings (none,gzip,zstd,snappy) to compare instead of running the load test")
+	benchDuration       = flag.Duration("benchduration", 10*time.Second, "duration of each setting in -compressionbench and -transportbench")
+	idleProbeMode       = flag.Bool("idleprobe", false, "find after how long idle streams are killed on the path to the server instead of running the load test, the server must run with -reply")
+	idleMin             = flag.Duration("idlemin", time.Second, "shortest idle interval of -idleprobe")
+	idleMax             = flag.Duration("idlemax", time.Hour, "longest idle interval of -idleprobe")
+	idleFactor          = flag.Float64("idlefactor", 2, "factor between the idle intervals of the first -idleprobe round")
+	idlePrecision       = flag.Duration("idleprecision", time.Second, "-idleprobe stops once the idle timeout is known within this duration")
+	idleStreams         = flag.Int("idlestreams", 4, "parallel streams of each -idleprobe round after the first one")
+	idleReplyTimeout    = flag.Duration("idlereplytimeout", 10*time.Second, "time to wait for a Pong before considering an -idleprobe stream dead")
+	capacityProbeMode   = flag.Bool("capacityprobe", false, "find how many concurrent streams the path to the server sustains instead of running the load test, the server must run with -reply")
+	probePerConn        = flag.Bool("probeperconn", false, "open all the -capacityprobe streams on one connection, to find the HTTP/2 MaxConcurrentStreams, grpc transport only")
+	probeStart          = flag.Int("probestart", 10, "streams of the first -capacityprobe step")
+	probeMax            = flag.Int("probemax", 100000, "most streams tried by -capacityprobe")
+	probeFactor         = flag.Float64("probefactor", 2, "factor between the streams of the -capacityprobe steps until one fails")
+	probePrecision      = flag.Int("probeprecision", 1, "-capacityprobe stops once the limit is known within this number of streams")
+	probeHold           = flag.Duration("probehold", 5*time.Second, "how long the streams of each -capacityprobe step are held, pinging the server")
+	probeOpenTimeout    = flag.Duration("probeopentimeout", 10*time.Second, "time for a -capacityprobe stream to open and get a Pong")
+	probeLatency        = flag.Float64("probelatency", 5, "fail the -capacityprobe steps whose p99 latency is over this factor of the first step's, 0 to disable")
+	splitWeights        = flag.String("splitcheck", "", "expected split of the replies as comma separated group=weight, like v1=90,v2=10: send -splitrequests requests and check their split instead of running the load test")
+	splitRequests       = flag.Int("splitrequests", 1000, "requests sent by -splitcheck")
+	splitBy             = flag.String("splitby", "version", "group the -splitcheck replies by version, pod, node or zone")
+	splitStream         = flag.Bool("splitstream", false, "open a stream over -transport for each -splitcheck request instead of making unary calls on one connection, the server must run with -reply")
+	splitConcurrency    = flag.Int("splitconcurrency", 10, "-splitcheck requests in flight")
+	splitTimeout        = flag.Duration("splittimeout", 10*time.Second, "timeout of each -splitcheck request")
+	splitTolerance      = flag.Float64("splittolerance", 5, "largest difference allowed between the expected and observed share of each -splitcheck group, in percentage points")
+	splitAlpha          = flag.Float64("splitalpha", 0.001, "significance level of the -splitcheck chi-squared test, the split fails when its p-value is lower")
+	duration            = flag.Duration("duration", 0, "end the streams and the load test after this duration, 0 to run until they are all over or SIGINT")
+	maxErrorRate        = flag.Float64("maxerrorrate", -1, "fail the load test when more of the sessions, between 0 and 1, fail to open or are disconnected, negative to not check")
+	maxP99              = flag.Duration("maxp99", 0, "fail the load test when the p99 Pong latency is over this duration, 0 to not check")
+	minStreams          = flag.Int("minstreams", 0, "fail the load test when it does not reach this number of concurrent streams, 0 to not check")
+	maxDisconnects      = flag.Int("maxdisconnects", -1, "fail the load test when more streams are ended by the server or the network, negative to not check")
+	minMessageRate      = flag.Float64("minmsgrate", 0, "fail the load test when it sends and receives fewer messages per second, 0 to not check")
+	saveResultsFile     = flag.String("saveresults", "", "save the results of the load test as JSON to this file, to be the -baseline of the next runs")
+	baselineFile        = flag.String("baseline", "", "compare the load test with the results saved in this file by -saveresults")
+	verdictFile         = flag.String("verdict", "", "write the -baseline comparison as JSON to this file, - for stdout")
+	latencyTolerance    = flag.Float64("latencytolerance", 10, "largest increase of the p50, p90 and p99 latencies over the -baseline, in percent")
+	errorRateTolerance  = flag.Float64("errorratetolerance", 1, "largest increase of the error rate over the -baseline, in percentage points")
+	throughputTolerance = flag.Float64("throughputtolerance", 10, "largest decrease of the messages per second under the -baseline, in percent")
+	regressionAlpha     = flag.Float64("regressionalpha", 0.01, "significance level of the -baseline tests, the changes with a higher p-value are noise")
+	keepaliveTime       = flag.Duration("keepalive", 0, "send HTTP/2 keepalive pings after this duration without activity, 0 to disable, 10s minimum")
+	keepaliveTimeout    = flag.Duration("keepalivetimeout", 20*time.Second, "close the connection when a keepalive ping is not answered within this duration")
+	proxyHeader         = flag.String("proxyheader", "", "send a PROXY protocol header, v1 or v2, as a TCP load balancer does")
+	proxySource         = flag.String("proxysource", "", "client address announced in the PROXY header, the local address when empty")
+	authority           = flag.String("authority", "", "override the :authority (Host) of the streams, the server address by default")
+	sni                 = flag.String("sni", "", "override the TLS server name, the -authority or server host by default")
 )
 
+func init() {
+	flag.Var(&headers, "header", "metadata key=value sent with every stream, can be repeated. "+
+		"The value is a template rendered for each client, like x-user=user-{{.ID}} or x-canary={{if eq (mod .Index 10) 0}}true{{end}}")
+}
+
 // Client is a worker that will load the server
 type Client struct {
 	kitlog.Logger
-	ID                 string `json:"device_id"`
-	debug              bool
-	withTLS            bool
-	insecureSkipVerify bool
+	ID        string `json:"device_id"`
+	debug     bool
+	transport string
+	opts      client.Options
+	dialOpts  []grpc.DialOption
+	stats     *sessionStats
 }
 
 // NewClient creates a new client
-func NewClient(id string, logger kitlog.Logger, debug, withTLS, insecureSkipVerify bool) *Client {
+// transport is grpc, websocket or sse, opts holds the TLS and credentials of the HTTP transports
+// and dialOpts the transport and per-RPC credentials of gRPC
+func NewClient(id string, logger kitlog.Logger, debug bool, transport string, opts client.Options, dialOpts []grpc.DialOption) *Client {
 	if debug {
 		logger.Log("msg", "starting client "+id)
 	}
 
 	return &Client{
-		Logger:             logger,
-		ID:                 id,
-		debug:              debug,
-		withTLS:            withTLS,
-		insecureSkipVerify: insecureSkipVerify,
+		Logger:    logger,
+		ID:        id,
+		debug:     debug,
+		transport: transport,
+		opts:      opts,
+		dialOpts:  dialOpts,
+		stats:     &sessionStats{},
 	}
 }
 
 // Start a new client
+// It reports its id on jobChan exactly once, when the stream is over or ctx is cancelled.
+// server is the gRPC address for the grpc transport and the HTTP address otherwise.
 func (c Client) Start(ctx context.Context, jobChan chan<- int, server, name string, id int) {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
+	defer func() { jobChan <- id }()
 
-	// Setup gRPC options and TLS
-	grpcOpts := []grpc.DialOption{
-		grpc.WithUnaryInterceptor(grpc_prometheus.UnaryClientInterceptor),
-		grpc.WithStreamInterceptor(grpc_prometheus.StreamClientInterceptor),
-	}
-
-	if c.withTLS {
-		creds := credentials.NewTLS(&tls.Config{InsecureSkipVerify: c.insecureSkipVerify})
-		grpcOpts = append(grpcOpts, grpc.WithTransportCredentials(creds))
-	} else {
-		grpcOpts = append(grpcOpts, grpc.WithInsecure())
+	transport := c.transport
+	if transport == "" {
+		transport = transportGRPC
 	}
-
-	conn, err := grpc.Dial(server, grpcOpts...)
+	stream, err := openSession(ctx, transport, server, name, c.opts, c.dialOpts)
 	if err != nil {
-		c.Logger.Log("msg", "cant connect to server", "err", err, "ID", c.ID)
-		jobChan <- id
-		return
-	}
-	defer conn.Close()
-	g := pb.NewGreeterClient(conn)
-
-	// This code is removed as we only need to test the Streaming capability
-	// Contact the server and print out its response.
-	// r, err := g.SayHello(ctx, &pb.HelloRequest{Name: name})
-	// if err != nil {
-	// 	c.Logger.Log("msg", "could not greet server", "err", err, "ID", c.ID)
-	// 	jobChan <- id
-	// 	return
-	// }
-	// PromSayHelloReceivedCounter.Inc()
-	// if c.debug {
-	// 	c.Logger.Log("msg", "Received Greeting: "+r.Message, "ID", c.ID)
-	// }
-
-	// open the stream
-	stream, err := g.SayHelloStream(context.Background())
-	if err != nil {
-		c.Logger.Log("msg", "could not SayHelloStream", "err", err, "ID", c.ID)
-		jobChan <- id
+		c.stats.failed()
+		c.Logger.Log("msg", "could not SayHelloStream", "err", err, "ID", c.ID, "transport", transport)
 		return
 	}
+	defer stream.Close()
+	c.stats.opened()
+	defer c.stats.closed()
+	PromSayHelloStreamGauge.Inc()
+	defer PromSayHelloStreamGauge.Dec()
 
-	// watch for messages from server
+	// watch for messages from server, done is closed when the stream is over
+	var seq sequence
+	clean := false
+	done := make(chan struct{})
 	go func() {
+		defer close(done)
 		for {
 			msg, err := stream.Recv()
 			if err == io.EOF {
 				c.Logger.Log("msg", "got EOF from server", "err", err, "ID", c.ID)
-				PromSayHelloStreamGauge.Dec()
-				jobChan <- id
+				clean = true
 				return
 			}
 			if err != nil {
 				c.Logger.Log("msg", "got error from server", "err", err, "ID", c.ID)
-				PromSayHelloStreamGauge.Dec()
-				jobChan <- id
 				return
 			}
+			// time the reply against the message it answers, the next ones may be sent already
+			if latency, ok := seq.latency(msg.Seq, time.Now()); ok {
+				c.stats.pong(latency)
+				PromPongLatency.WithLabelValues(transport).Observe(latency.Seconds())
+			}
 			PromSayHelloStreamReceivedCounter.Inc()
+			b := backendOf(msg.Backend)
+			c.stats.reply(b)
+			PromBackendReplyCounter.WithLabelValues(transport, b.pod, b.version).Inc()
+			if seqErr, n := seq.reply(msg.Seq); seqErr != "" {
+				c.sequenceError(transport, seqErr, n)
+				c.Logger.Log("msg", "sequence error", "error", seqErr, "seq", msg.Seq, "messages", n, "ID", c.ID)
+			}
 			if c.debug {
-				c.Logger.Log("msg", msg.Message, "ID", c.ID)
+				c.Logger.Log("msg", msg.Message, "seq", msg.Seq, "backend", b.pod, "version", b.version, "ID", c.ID)
 			}
 		}
 	}()
+	// once the stream is over, count the messages never answered
+	defer func() {
+		select {
+		case <-done:
+			if n := seq.unanswered(clean); n > 0 {
+				c.sequenceError(transport, seqUnanswered, n)
+				c.Logger.Log("msg", "sequence error", "error", seqUnanswered, "messages", n, "ID", c.ID)
+			}
+		default:
+		}
+	}()
 
-	// loop until we are done
+	// loop until we are done, or the server or the network ended the stream before we did
+	disconnected := false
 	for {
 		// send a message to the stream
-		err = stream.SendMsg(&pb.HelloReply{Message: "Ping " + c.ID})
+		err = stream.Send(&pb.HelloRequest{Name: payload("Ping " + c.ID), Seq: seq.next()})
 		if err != nil {
 			c.Logger.Log("msg", "error while sending alerts to server", "err", err, "ID", c.ID)
-			jobChan <- id
+			// the stream fails too once ctx is cancelled
+			disconnected = ctx.Err() == nil
 			break
 		}
-		PromSayHelloStreamGauge.Inc()
-		if *debug {
+		c.stats.ping()
+		if c.debug {
 			c.Logger.Log("msg", "msg sent", "ID", c.ID)
 		}
 
-		// we close the stream after a fixed delay specified on the commandline
-		time.Sleep(*sleepTime)
+		// we send a new message after a fixed delay specified on the commandline
+		select {
+		case <-time.After(*sleepTime):
+			continue
+		case <-done:
+			// done and ctx.Done are both ready when cancelling ctx ended the stream, which is not a disconnect
+			if ctx.Err() == nil {
+				c.stats.disconnected()
+				PromDisconnectCounter.WithLabelValues(transport).Inc()
+				return
+			}
+		case <-ctx.Done():
+		}
+		break
+	}
+	if disconnected {
+		c.stats.disconnected()
+		PromDisconnectCounter.WithLabelValues(transport).Inc()
+	} else {
+		c.stats.survived()
 	}
 
 	// closing the stream will send an "EOF from server error"
 	err = stream.CloseSend()
 	if err != nil {
 		c.Logger.Log("msg", "got error from CloseSend", "err", err, "ID", c.ID)
-		PromSayHelloStreamGauge.Dec()
-		jobChan <- id
 		return
 	}
+	<-done
+}
+
+// sequenceError counts n messages with a sequence error
+func (c Client) sequenceError(transport, err string, n int) {
+	c.stats.sequenceError(err, n)
+	PromSequenceErrorCounter.WithLabelValues(transport, err).Add(float64(n))
+}
+
+// loadTarget is the address the clients connect to over transport
+func loadTarget(transport string) string {
+	if transport != transportGRPC {
+		return *httpServer
+	}
+	return *server
+}
+
+// modeContext is the context of a mode running instead of the load test, cancelled by SIGINT
+func modeContext(signals <-chan os.Signal) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-signals
+		cancel()
+	}()
+	return ctx
 }
 
 func main() {
 	flag.Parse()
 
+	// the logs and reports go to stderr when stdout is for the JSON verdict
+	out := io.Writer(os.Stdout)
+	if *verdictFile == "-" {
+		out = os.Stderr
+	}
+
 	// setup logger with Json output
-	logger := kitlog.NewJSONLogger(kitlog.NewSyncWriter(os.Stdout))
+	logger := kitlog.NewJSONLogger(kitlog.NewSyncWriter(out))
 	logger = kitlog.With(logger, "application", "greeter_server", "ts", kitlog.DefaultTimestampUTC, "caller", kitlog.DefaultCaller)
 
 	// trap SIGINT to trigger a shutdown.
@@ -208,14 +323,177 @@ func main() {
 		logger.Log("err", http.ListenAndServe(fmt.Sprintf(":%s", *httpPort), nil))
 	}()
 
+	// TLS and credentials are the same for all the clients
+	clientOpts := client.Options{
+		TLS:                *withTLS,
+		InsecureSkipVerify: *insecureSkipVerify,
+		CAFile:             *caCert,
+		CertFile:           *cert,
+		KeyFile:            *key,
+		APIKey:             *apiKey,
+		Token:              *token,
+		TokenFile:          *tokenFile,
+		Compression:        *compressor,
+		Keepalive:          *keepaliveTime,
+		KeepaliveTimeout:   *keepaliveTimeout,
+		ProxyHeader:        *proxyHeader,
+		ProxySource:        *proxySource,
+		Authority:          *authority,
+		SNI:                *sni,
+	}
+	dialOpts, err := clientOpts.DialOptions()
+	if err != nil {
+		logger.Log("msg", "invalid connection options", "err", err)
+		os.Exit(1)
+	}
+	if _, err := headers.render(0); err != nil {
+		logger.Log("msg", "invalid -header", "err", err)
+		os.Exit(1)
+	}
+
+	// compare the compression settings instead of running the load test
+	if *compressionBench != "" {
+		ctx := modeContext(signals)
+		if err := runCompressionBench(ctx, logger, clientOpts, strings.Split(*compressionBench, ",")); err != nil {
+			logger.Log("msg", "compression bench failed", "err", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// find the idle timeout instead of running the load test
+	if *idleProbeMode {
+		switch {
+		case *idleMin <= 0:
+			logger.Log("msg", "invalid -idlemin, it must be over 0", "idlemin", *idleMin)
+			os.Exit(1)
+		case *idleFactor <= 1:
+			logger.Log("msg", "invalid -idlefactor, it must be over 1", "idlefactor", *idleFactor)
+			os.Exit(1)
+		case *idleStreams < 1:
+			logger.Log("msg", "invalid -idlestreams, it must be at least 1", "idlestreams", *idleStreams)
+			os.Exit(1)
+		}
+		ctx := modeContext(signals)
+		probe := idleProbe{
+			logger:       logger,
+			transport:    *transport,
+			target:       loadTarget(*transport),
+			opts:         withHeaders(clientOpts, 0),
+			dialOpts:     dialOpts,
+			min:          *idleMin,
+			max:          *idleMax,
+			factor:       *idleFactor,
+			precision:    *idlePrecision,
+			streams:      *idleStreams,
+			replyTimeout: *idleReplyTimeout,
+		}
+		printIdleReport(os.Stdout, probe.run(ctx))
+		return
+	}
+
+	// check the split of the replies between the backends instead of running the load test
+	if *splitWeights != "" {
+		weights, err := parseWeights(*splitWeights)
+		if err != nil {
+			logger.Log("msg", "invalid -splitcheck", "err", err)
+			os.Exit(1)
+		}
+		if _, ok := splitGroups[*splitBy]; !ok {
+			logger.Log("msg", "invalid -splitby, use version, pod, node or zone", "splitby", *splitBy)
+			os.Exit(1)
+		}
+		if !*splitStream && *transport != transportGRPC {
+			logger.Log("msg", "unary -splitcheck requests need the grpc transport, set -splitstream")
+			os.Exit(1)
+		}
+		ctx := modeContext(signals)
+		check := splitCheck{
+			logger:      logger,
+			transport:   *transport,
+			target:      loadTarget(*transport),
+			opts:        clientOpts,
+			dialOpts:    dialOpts,
+			stream:      *splitStream,
+			requests:    *splitRequests,
+			concurrency: *splitConcurrency,
+			timeout:     *splitTimeout,
+			by:          *splitBy,
+			weights:     weights,
+			tolerance:   *splitTolerance,
+			alpha:       *splitAlpha,
+		}
+		report := check.run(ctx)
+		printSplitReport(os.Stdout, report)
+		if len(report.failed) > 0 {
+			os.Exit(exitSplitFailed)
+		}
+		return
+	}
+
+	// find the concurrent streams limit instead of running the load test
+	if *capacityProbeMode {
+		if *probePerConn && *transport != transportGRPC {
+			logger.Log("msg", "-probeperconn needs the grpc transport")
+			os.Exit(1)
+		}
+		ctx := modeContext(signals)
+		probe := capacityProbe{
+			logger:        logger,
+			transport:     *transport,
+			target:        loadTarget(*transport),
+			opts:          withHeaders(clientOpts, 0),
+			dialOpts:      dialOpts,
+			perConn:       *probePerConn,
+			start:         *probeStart,
+			max:           *probeMax,
+			factor:        *probeFactor,
+			precision:     *probePrecision,
+			hold:          *probeHold,
+			openTimeout:   *probeOpenTimeout,
+			latencyFactor: *probeLatency,
+		}
+		printCapacityReport(os.Stdout, probe.run(ctx), *probePerConn)
+		return
+	}
+
+	// compare the transports instead of running the load test
+	if *transportBench != "" {
+		ctx := modeContext(signals)
+		runTransportBench(ctx, logger, clientOpts, dialOpts, strings.Split(*transportBench, ","))
+		return
+	}
+
+	// load the baseline now rather than losing the run to an unreadable one
+	var base runResults
+	if *baselineFile != "" {
+		if base, err = loadResults(*baselineFile); err != nil {
+			logger.Log("msg", "cant load the baseline", "err", err)
+			os.Exit(1)
+		}
+		if err := base.comparableWith(*transport, *clients); err != nil {
+			logger.Log("msg", "cant compare with the baseline", "err", err)
+			os.Exit(1)
+		}
+	}
+
 	// start many go routines with clients
+	target := loadTarget(*transport)
 	jobs := make([]*Client, *clients)
-	ctx := context.Background()
+	stats := &sessionStats{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	start := time.Now()
+	var deadline <-chan time.Time
+	if *duration > 0 {
+		deadline = time.After(*duration)
+	}
 	jobChan := make(chan int)
 	jobCounter := 0
 	for i := 0; i < *clients; i++ {
-		jobs[i] = NewClient(strconv.Itoa(i), logger, *debug, *withTLS, *insecureSkipVerify)
-		go jobs[i].Start(ctx, jobChan, *server, strconv.Itoa(i), i)
+		jobs[i] = NewClient(strconv.Itoa(i), logger, *debug, *transport, withHeaders(clientOpts, i), dialOpts)
+		jobs[i].stats = stats
+		go jobs[i].Start(ctx, jobChan, target, strconv.Itoa(i), i)
 		jobCounter++
 		// delay the clients creation by 100ms
 		time.Sleep(100 * time.Millisecond)
@@ -224,20 +502,78 @@ func main() {
 		}
 	}
 
-	// wait for the clients to finish the work
-	for {
+	// wait for the clients to finish the work, or end their streams after -duration or on SIGINT.
+	// A second SIGINT, or streams not ending within 10s, stop waiting.
+	var closing <-chan time.Time
+wait:
+	for jobCounter > 0 {
 		select {
 		case wdone := <-jobChan:
 			logger.Log("info", "job finished", "state", "OK", "ID", wdone)
 			jobCounter--
+		case <-deadline:
+			logger.Log("info", "duration is over, ending the streams")
+			cancel()
+			closing = time.After(10 * time.Second)
 		case <-signals:
-			return
+			if closing != nil {
+				break wait
+			}
+			logger.Log("info", "interrupted, ending the streams")
+			cancel()
+			closing = time.After(10 * time.Second)
+		case <-closing:
+			logger.Log("info", "streams not ended in time", "jobCounter", jobCounter)
+			break wait
 		case <-time.After(20 * time.Second):
 			logger.Log("info", "jobCounter", "jobCounter", jobCounter)
-			if jobCounter == 0 {
-				logger.Log("info", "no more jobs")
-				return
+		}
+	}
+	logger.Log("info", "no more jobs")
+	elapsed := time.Since(start)
+	printSummary(out, stats)
+
+	slos := thresholds{
+		maxErrorRate:   *maxErrorRate,
+		maxP99:         *maxP99,
+		minStreams:     *minStreams,
+		maxDisconnects: *maxDisconnects,
+		minMessageRate: *minMessageRate,
+	}
+	results := slos.evaluate(stats, elapsed)
+	if len(results) > 0 {
+		fmt.Fprintln(out)
+	}
+	pass := printSLOs(out, results)
+
+	run := newRunResults(stats, start, elapsed, *transport, *clients)
+	if *saveResultsFile != "" {
+		if err := saveResults(*saveResultsFile, run); err != nil {
+			logger.Log("msg", "cant save the results", "err", err)
+		}
+	}
+	regressed := false
+	if *baselineFile != "" {
+		c := compareRuns(*baselineFile, base, run, tolerances{
+			latency:    *latencyTolerance / 100,
+			errorRate:  *errorRateTolerance / 100,
+			throughput: *throughputTolerance / 100,
+			alpha:      *regressionAlpha,
+		})
+		fmt.Fprintln(out)
+		printComparison(out, c)
+		if *verdictFile != "" {
+			if err := writeVerdict(*verdictFile, c); err != nil {
+				logger.Log("msg", "cant write the verdict", "err", err)
 			}
 		}
+		regressed = c.regressed()
+	}
+
+	switch {
+	case !pass:
+		os.Exit(exitSLOFailed)
+	case regressed:
+		os.Exit(exitRegression)
 	}
 }
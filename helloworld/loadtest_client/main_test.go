@@ -0,0 +1,174 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prune998/goHelloGrpcStream/helloworld/client"
+	pb "github.com/prune998/goHelloGrpcStream/helloworld/helloworld"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// pongServer answers each stream message with a Pong
+type pongServer struct {
+	pb.UnimplementedGreeterServer
+}
+
+func (pongServer) SayHelloStream(stream pb.Greeter_SayHelloStreamServer) error {
+	for {
+		msg, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(&pb.HelloReply{Message: "Pong " + msg.Name, Seq: msg.Seq}); err != nil {
+			return err
+		}
+	}
+}
+
+// startClient runs a Client against a pongServer on bufconn and returns the channel it reports on
+func startClient(t *testing.T, ctx context.Context) (*grpc.Server, <-chan int) {
+	t.Helper()
+
+	lis := bufconn.Listen(1024 * 1024)
+	s := grpc.NewServer()
+	pb.RegisterGreeterServer(s, pongServer{})
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	c := NewClient("0", kitlog.NewNopLogger(), false, transportGRPC, client.Options{}, []grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+	})
+
+	jobChan := make(chan int, 2)
+	go c.Start(ctx, jobChan, "bufnet", "0", 42)
+	return s, jobChan
+}
+
+// expectDone checks the client reported its id exactly once
+func expectDone(t *testing.T, jobChan <-chan int) {
+	t.Helper()
+	select {
+	case id := <-jobChan:
+		if id != 42 {
+			t.Errorf("got id %d, want 42", id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("client did not finish")
+	}
+	select {
+	case id := <-jobChan:
+		t.Errorf("client reported twice, second id %d", id)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestClientStartCancel(t *testing.T) {
+	*sleepTime = 10 * time.Millisecond
+	received := testutil.ToFloat64(PromSayHelloStreamReceivedCounter)
+	disconnects := testutil.ToFloat64(PromDisconnectCounter.WithLabelValues(transportGRPC))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	_, jobChan := startClient(t, ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for testutil.ToFloat64(PromSayHelloStreamReceivedCounter)-received < 3 {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for pongs")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	expectDone(t, jobChan)
+	// ending the stream on cancel is not a disconnect
+	if got := testutil.ToFloat64(PromDisconnectCounter.WithLabelValues(transportGRPC)) - disconnects; got != 0 {
+		t.Errorf("disconnect counter increased by %v, want 0", got)
+	}
+}
+
+func TestClientStartServerStop(t *testing.T) {
+	*sleepTime = time.Hour
+	gauge := testutil.ToFloat64(PromSayHelloStreamGauge)
+	disconnects := testutil.ToFloat64(PromDisconnectCounter.WithLabelValues(transportGRPC))
+
+	s, jobChan := startClient(t, context.Background())
+	deadline := time.Now().Add(5 * time.Second)
+	for testutil.ToFloat64(PromSayHelloStreamGauge) == gauge {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for the stream to open")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the client must not wait for its next send to notice the stream is gone
+	s.Stop()
+	expectDone(t, jobChan)
+	if got := testutil.ToFloat64(PromSayHelloStreamGauge); got != gauge {
+		t.Errorf("stream gauge is %v, want %v", got, gauge)
+	}
+	if got := testutil.ToFloat64(PromDisconnectCounter.WithLabelValues(transportGRPC)) - disconnects; got != 1 {
+		t.Errorf("disconnect counter increased by %v, want 1", got)
+	}
+}
+
+// webSocketPong answers each WebSocket message with a Pong, like greeter_server -reply
+func webSocketPong(w http.ResponseWriter, r *http.Request) {
+	conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			return
+		}
+		msg := &pb.HelloRequest{}
+		protojson.Unmarshal(data, msg)
+		b, _ := protojson.Marshal(&pb.HelloReply{Message: "Pong " + msg.Name, Seq: msg.Seq})
+		conn.WriteMessage(websocket.TextMessage, b)
+	}
+}
+
+func TestWebSocketSession(t *testing.T) {
+	web := httptest.NewServer(http.HandlerFunc(webSocketPong))
+	defer web.Close()
+
+	s, err := openSession(context.Background(), transportWebSocket, strings.TrimPrefix(web.URL, "http://"), "tester", client.Options{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.Send(&pb.HelloRequest{Name: "Ping", Seq: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if msg, err := s.Recv(); err != nil || msg.Message != "Pong Ping" || msg.Seq != 1 {
+		t.Errorf("got %v, %v", msg, err)
+	}
+	if err := s.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Recv(); err != io.EOF {
+		t.Errorf("got %v after CloseSend, want EOF", err)
+	}
+}
@@ -17,10 +17,35 @@ var (
 		Name: "greeter_server_SayHelloStream_gauge",
 		Help: "current SayHelloStream count",
 	})
+
+	PromPongLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "loadtest_client_pong_latency_seconds",
+		Help:    "time between a Ping and the next Pong, by transport",
+		Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
+	}, []string{"transport"})
+
+	PromDisconnectCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "loadtest_client_disconnect_counter",
+		Help: "streams ended by the server or the network before the client closed them, by transport",
+	}, []string{"transport"})
+
+	PromSequenceErrorCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "loadtest_client_sequence_errors_counter",
+		Help: "stream messages with a gap, reordered, duplicate or unanswered reply, by transport and error",
+	}, []string{"transport", "error"})
+
+	PromBackendReplyCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "loadtest_client_backend_replies_counter",
+		Help: "stream replies by transport and by the pod and version of the server replica which sent them",
+	}, []string{"transport", "backend", "version"})
 )
 
 func init() {
 	prometheus.MustRegister(PromSayHelloReceivedCounter)
 	prometheus.MustRegister(PromSayHelloStreamReceivedCounter)
 	prometheus.MustRegister(PromSayHelloStreamGauge)
+	prometheus.MustRegister(PromPongLatency)
+	prometheus.MustRegister(PromDisconnectCounter)
+	prometheus.MustRegister(PromSequenceErrorCounter)
+	prometheus.MustRegister(PromBackendReplyCounter)
 }
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Sequence errors found on the replies of a stream
+const (
+	// seqGap is a reply skipping earlier messages, counting the messages skipped
+	seqGap = "gap"
+	// seqReordered is the reply to a message skipped by an earlier reply
+	seqReordered = "reordered"
+	// seqDuplicate is a second reply to a message, or a reply to a message never sent
+	seqDuplicate = "duplicate"
+	// seqUnanswered is a message never answered, although a later one was or the stream ended cleanly
+	seqUnanswered = "unanswered"
+)
+
+// sequence numbers the messages of a stream and checks the server echoes each number once, in order.
+// Replies without a number, from a server not echoing them, are not checked.
+type sequence struct {
+	mu sync.Mutex
+	// sent is the number of the last message sent, highest the highest number answered
+	sent, highest int64
+	// missing are the numbers under highest not answered yet
+	missing map[int64]bool
+
+	// sentAt are the send times of the messages not answered yet, lastSent the one of the last message.
+	// They are not kept by number once the server showed it does not echo them.
+	sentAt     map[int64]time.Time
+	lastSent   time.Time
+	unnumbered bool
+}
+
+// next returns the number of the next message, about to be sent
+func (s *sequence) next() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent++
+	s.lastSent = time.Now()
+	if !s.unnumbered {
+		if s.sentAt == nil {
+			s.sentAt = map[int64]time.Time{}
+		}
+		s.sentAt[s.sent] = s.lastSent
+	}
+	return s.sent
+}
+
+// latency returns how long before now the message a reply answers was sent, the last message for the replies
+// without a number. It is false for the duplicates and the numbers never sent.
+func (s *sequence) latency(seq int64, now time.Time) (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if seq == 0 {
+		s.unnumbered, s.sentAt = true, nil
+		return now.Sub(s.lastSent), true
+	}
+	sent, ok := s.sentAt[seq]
+	delete(s.sentAt, seq)
+	return now.Sub(sent), ok
+}
+
+// reply checks the number of a reply, returning the sequence error it shows and how many messages it concerns
+func (s *sequence) reply(seq int64) (string, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case seq == 0:
+		return "", 0
+	case seq > s.sent || seq == s.highest:
+		return seqDuplicate, 1
+	case seq > s.highest:
+		skipped := seq - s.highest - 1
+		for i := s.highest + 1; i < seq; i++ {
+			if s.missing == nil {
+				s.missing = map[int64]bool{}
+			}
+			s.missing[i] = true
+		}
+		s.highest = seq
+		if skipped > 0 {
+			return seqGap, int(skipped)
+		}
+		return "", 0
+	case s.missing[seq]:
+		delete(s.missing, seq)
+		return seqReordered, 1
+	}
+	return seqDuplicate, 1
+}
+
+// unanswered returns how many messages were never answered once the stream is over.
+// The messages sent after the last reply only count when the server ended the stream cleanly,
+// they are in flight when the client cancels it.
+func (s *sequence) unanswered(clean bool) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := len(s.missing)
+	if clean && s.highest > 0 {
+		n += int(s.sent - s.highest)
+	}
+	return n
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prune998/goHelloGrpcStream/helloworld/client"
+	pb "github.com/prune998/goHelloGrpcStream/helloworld/helloworld"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestSequence(t *testing.T) {
+	var s sequence
+	for i := 0; i < 6; i++ {
+		s.next()
+	}
+	for _, test := range []struct {
+		seq  int64
+		err  string
+		n    int
+		desc string
+	}{
+		{1, "", 0, "in order"},
+		{0, "", 0, "not numbered"},
+		{4, seqGap, 2, "skipping 2 and 3"},
+		{3, seqReordered, 1, "late"},
+		{3, seqDuplicate, 1, "answered twice"},
+		{4, seqDuplicate, 1, "highest answered twice"},
+		{9, seqDuplicate, 1, "never sent"},
+		{5, "", 0, "back in order"},
+	} {
+		if err, n := s.reply(test.seq); err != test.err || n != test.n {
+			t.Errorf("%s: reply(%d) = %q, %d, want %q, %d", test.desc, test.seq, err, n, test.err, test.n)
+		}
+	}
+
+	// 2 is missing, 6 is in flight
+	if n := s.unanswered(false); n != 1 {
+		t.Errorf("got %d unanswered messages, want 1", n)
+	}
+	if n := s.unanswered(true); n != 2 {
+		t.Errorf("got %d unanswered messages after a clean end, want 2", n)
+	}
+
+	var quiet sequence
+	quiet.next()
+	if n := quiet.unanswered(true); n != 0 {
+		t.Errorf("got %d unanswered messages from a server not replying", n)
+	}
+}
+
+func TestSequenceLatency(t *testing.T) {
+	var s sequence
+	s.next()
+	time.Sleep(20 * time.Millisecond)
+	s.next()
+	now := time.Now()
+
+	// the reply to 1 comes after 2 is sent, it is still timed against 1
+	if latency, ok := s.latency(1, now); !ok || latency < 20*time.Millisecond {
+		t.Errorf("latency(1) = %v, %v, want over 20ms", latency, ok)
+	}
+	if latency, ok := s.latency(2, now); !ok || latency >= 20*time.Millisecond {
+		t.Errorf("latency(2) = %v, %v, want under 20ms", latency, ok)
+	}
+	if _, ok := s.latency(1, now); ok {
+		t.Error("a duplicate reply should not be timed")
+	}
+	if _, ok := s.latency(9, now); ok {
+		t.Error("a reply to a message never sent should not be timed")
+	}
+
+	// a server not echoing the numbers is timed against the last message
+	if latency, ok := s.latency(0, now); !ok || latency >= 20*time.Millisecond {
+		t.Errorf("latency(0) = %v, %v, want under 20ms", latency, ok)
+	}
+	if s.next(); len(s.sentAt) != 0 {
+		t.Errorf("kept %d send times for a server not echoing the numbers", len(s.sentAt))
+	}
+}
+
+// shuffleServer answers the first 5 messages out of order: 1, 2, 2, 4, 3, then ends the stream
+type shuffleServer struct {
+	pb.UnimplementedGreeterServer
+}
+
+func (shuffleServer) SayHelloStream(stream pb.Greeter_SayHelloStreamServer) error {
+	replies := map[int64][]int64{1: {1}, 2: {2, 2}, 4: {4, 3}}
+	for i := 0; i < 5; i++ {
+		msg, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		for _, seq := range replies[msg.Seq] {
+			if err := stream.Send(&pb.HelloReply{Message: "Pong", Seq: seq}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func TestClientSequenceErrors(t *testing.T) {
+	*sleepTime = 10 * time.Millisecond
+	before := map[string]float64{}
+	for _, err := range []string{seqGap, seqReordered, seqDuplicate, seqUnanswered} {
+		before[err] = testutil.ToFloat64(PromSequenceErrorCounter.WithLabelValues(transportGRPC, err))
+	}
+
+	lis := bufconn.Listen(1024 * 1024)
+	s := grpc.NewServer()
+	pb.RegisterGreeterServer(s, shuffleServer{})
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	c := NewClient("0", kitlog.NewNopLogger(), false, transportGRPC, client.Options{}, []grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+	})
+	jobChan := make(chan int, 1)
+	go c.Start(context.Background(), jobChan, "bufnet", "0", 42)
+	expectDone(t, jobChan)
+
+	for err, want := range map[string]int{seqGap: 1, seqReordered: 1, seqDuplicate: 1, seqUnanswered: 1} {
+		if got := c.stats.sequenceErrors[err]; got != want {
+			t.Errorf("got %d %s messages, want %d", got, err, want)
+		}
+		if got := testutil.ToFloat64(PromSequenceErrorCounter.WithLabelValues(transportGRPC, err)) - before[err]; got != float64(want) {
+			t.Errorf("%s counter increased by %v, want %d", err, got, want)
+		}
+	}
+}
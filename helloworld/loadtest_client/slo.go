@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// exitSLOFailed is the exit code of a load test missing its thresholds, 1 being for the invalid setups
+const exitSLOFailed = 3
+
+// thresholds are the service level objectives the load test must meet, each one disabled by its zero value
+// or, for the maximums 0 is a valid value of, a negative one
+type thresholds struct {
+	// maxErrorRate is the largest share, between 0 and 1, of the sessions failing to open or disconnected
+	maxErrorRate float64
+	maxP99       time.Duration
+	// minStreams is the fewest concurrent streams the load test must reach
+	minStreams     int
+	maxDisconnects int
+	// minMessageRate is the fewest Pings sent and Pongs received per second
+	minMessageRate float64
+}
+
+// sloResult is the outcome of a threshold
+type sloResult struct {
+	name, threshold, actual string
+	pass                    bool
+}
+
+// evaluate checks the stats of a load test which ran for elapsed against the thresholds that are set
+func (t thresholds) evaluate(s *sessionStats, elapsed time.Duration) []sloResult {
+	p99 := s.percentile(0.99)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var results []sloResult
+	if t.maxErrorRate >= 0 {
+		tried := s.sessions + s.failures
+		rate := 0.0
+		if tried > 0 {
+			rate = float64(s.failures+s.disconnects) / float64(tried)
+		}
+		results = append(results, sloResult{
+			name:      "error rate",
+			threshold: fmt.Sprintf("<= %.2f%%", 100*t.maxErrorRate),
+			actual:    fmt.Sprintf("%.2f%% (%d failed, %d disconnected of %d)", 100*rate, s.failures, s.disconnects, tried),
+			pass:      tried > 0 && rate <= t.maxErrorRate,
+		})
+	}
+	if t.maxP99 > 0 {
+		r := sloResult{name: "p99 latency", threshold: fmt.Sprintf("<= %v", t.maxP99), actual: p99.String(), pass: p99 <= t.maxP99}
+		if s.pongs == 0 {
+			r.actual, r.pass = "no Pong, is the server running with -reply ?", false
+		}
+		results = append(results, r)
+	}
+	if t.minStreams > 0 {
+		results = append(results, sloResult{
+			name:      "concurrent streams",
+			threshold: fmt.Sprintf(">= %d", t.minStreams),
+			actual:    fmt.Sprint(s.peak),
+			pass:      s.peak >= t.minStreams,
+		})
+	}
+	if t.maxDisconnects >= 0 {
+		results = append(results, sloResult{
+			name:      "disconnects",
+			threshold: fmt.Sprintf("<= %d", t.maxDisconnects),
+			actual:    fmt.Sprint(s.disconnects),
+			pass:      s.disconnects <= t.maxDisconnects,
+		})
+	}
+	if t.minMessageRate > 0 {
+		rate := 0.0
+		if elapsed > 0 {
+			rate = float64(s.pings+s.pongs) / elapsed.Seconds()
+		}
+		results = append(results, sloResult{
+			name:      "messages/s",
+			threshold: fmt.Sprintf(">= %g", t.minMessageRate),
+			actual:    fmt.Sprintf("%.1f", rate),
+			pass:      rate >= t.minMessageRate,
+		})
+	}
+	return results
+}
+
+// printSLOs prints the pass/fail table of the thresholds and tells whether they all passed
+func printSLOs(w io.Writer, results []sloResult) bool {
+	if len(results) == 0 {
+		return true
+	}
+	pass := true
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SLO\tTHRESHOLD\tACTUAL\tRESULT")
+	for _, r := range results {
+		result := "PASS"
+		if !r.pass {
+			result = "FAIL"
+			pass = false
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.name, r.threshold, r.actual, result)
+	}
+	tw.Flush()
+	if pass {
+		fmt.Fprintln(w, "PASS")
+	} else {
+		fmt.Fprintln(w, "FAIL")
+	}
+	return pass
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestThresholds(t *testing.T) {
+	stats := &sessionStats{}
+	for i := 0; i < 10; i++ {
+		stats.opened()
+	}
+	for i := 0; i < 10; i++ {
+		stats.closed()
+	}
+	stats.opened()
+	stats.failed()
+	stats.disconnected()
+	for i := 0; i < 100; i++ {
+		stats.ping()
+		stats.pong(time.Duration(i+1) * time.Millisecond)
+	}
+	if stats.peak != 10 || stats.active != 1 {
+		t.Errorf("got %d active streams, peak %d, want 1 and 10", stats.active, stats.peak)
+	}
+
+	disabled := thresholds{maxErrorRate: -1, maxDisconnects: -1}
+	if results := disabled.evaluate(stats, time.Second); len(results) != 0 {
+		t.Errorf("got %v without thresholds", results)
+	}
+
+	// 2 errors of 12 sessions, p99 of 99ms, 200 messages in 10s
+	for _, test := range []struct {
+		thresholds thresholds
+		pass       []bool
+	}{
+		{thresholds{maxErrorRate: 0.2, maxP99: 100 * time.Millisecond, minStreams: 10, maxDisconnects: 1, minMessageRate: 20}, []bool{true, true, true, true, true}},
+		{thresholds{maxErrorRate: 0.1, maxP99: 50 * time.Millisecond, minStreams: 11, maxDisconnects: 0, minMessageRate: 21}, []bool{false, false, false, false, false}},
+	} {
+		results := test.thresholds.evaluate(stats, 10*time.Second)
+		if len(results) != len(test.pass) {
+			t.Fatalf("got %d results, want %d", len(results), len(test.pass))
+		}
+		for i, r := range results {
+			if r.pass != test.pass[i] {
+				t.Errorf("%s %s: got %s, pass %v", r.name, r.threshold, r.actual, r.pass)
+			}
+		}
+		var out bytes.Buffer
+		if pass := printSLOs(&out, results); pass != test.pass[0] {
+			t.Errorf("printSLOs returned %v\n%s", pass, out.String())
+		}
+	}
+
+	var out bytes.Buffer
+	if printSLOs(&out, thresholds{maxErrorRate: -1, maxP99: time.Second, maxDisconnects: -1}.evaluate(&sessionStats{}, time.Second)) {
+		t.Error("p99 passed without any Pong")
+	}
+	if !strings.Contains(out.String(), "no Pong") {
+		t.Errorf("got\n%s", out.String())
+	}
+}
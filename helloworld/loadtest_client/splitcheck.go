@@ -0,0 +1,318 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/prune998/goHelloGrpcStream/helloworld/client"
+	pb "github.com/prune998/goHelloGrpcStream/helloworld/helloworld"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// exitSplitFailed is the exit code of a -splitcheck not matching its weights, after exitRegression
+const exitSplitFailed = 5
+
+// splitGroups are what the -splitcheck replies can be grouped by
+var splitGroups = map[string]func(backend) string{
+	"version": func(b backend) string { return b.version },
+	"pod":     func(b backend) string { return b.pod },
+	"node":    func(b backend) string { return b.node },
+	"zone":    func(b backend) string { return b.zone },
+}
+
+// parseWeights parses the expected split, like v1=90,v2=10, into the share of each group.
+// A group can have a 0 weight, to check it gets no traffic.
+func parseWeights(s string) (map[string]float64, error) {
+	weights := map[string]float64{}
+	total := 0.0
+	for _, part := range strings.Split(s, ",") {
+		group, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok || group == "" {
+			return nil, fmt.Errorf("%q is not group=weight", part)
+		}
+		if _, ok := weights[group]; ok {
+			return nil, fmt.Errorf("group %s is given twice", group)
+		}
+		weight, err := strconv.ParseFloat(value, 64)
+		if err != nil || weight < 0 || math.IsInf(weight, 0) {
+			return nil, fmt.Errorf("invalid weight %q for %s", value, group)
+		}
+		weights[group] = weight
+		total += weight
+	}
+	if total == 0 {
+		return nil, errors.New("the weights add up to 0")
+	}
+	for group := range weights {
+		weights[group] /= total
+	}
+	return weights, nil
+}
+
+// splitCheck sends requests to the server and checks the replies are split between the backends as expected
+type splitCheck struct {
+	logger    kitlog.Logger
+	transport string
+	target    string
+	opts      client.Options
+	dialOpts  []grpc.DialOption
+
+	// stream opens a stream per request over transport, instead of making unary gRPC calls on a shared connection
+	stream      bool
+	requests    int
+	concurrency int
+	timeout     time.Duration
+	// by is the splitGroups key the replies are grouped by
+	by string
+	// weights are the expected shares of the groups, adding up to 1
+	weights map[string]float64
+	// tolerance is the largest difference allowed between the expected and observed shares, in percentage points
+	tolerance float64
+	// alpha is the significance level of the chi-squared test
+	alpha float64
+}
+
+// splitReport is how the replies were split, and whether it matches the expected weights
+type splitReport struct {
+	by       string
+	weights  map[string]float64
+	replies  map[string]int
+	requests int
+	failures int
+
+	chiSquared float64
+	freedom    int
+	pValue     float64
+	// failed are the reasons of the failure, empty when the split matches
+	failed []string
+}
+
+// run sends the requests, concurrency at a time, and compares their split against the weights
+func (c splitCheck) run(ctx context.Context) splitReport {
+	request, closeAll, err := c.requester()
+	if err != nil {
+		c.logger.Log("msg", "cant connect to server", "err", err)
+		return c.evaluate(nil, c.requests)
+	}
+	defer closeAll()
+
+	group := splitGroups[c.by]
+	replies := map[string]int{}
+	failures := 0
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	indexes := make(chan int)
+	for w := 0; w < max(c.concurrency, 1); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				reply, err := request(ctx, i)
+				mu.Lock()
+				if err != nil {
+					failures++
+				} else {
+					replies[group(backendOf(reply.Backend))]++
+				}
+				mu.Unlock()
+				if err != nil && ctx.Err() == nil {
+					c.logger.Log("msg", "split check request failed", "err", err, "request", i)
+				}
+			}
+		}()
+	}
+	for i := 0; i < c.requests && ctx.Err() == nil; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return c.evaluate(replies, failures)
+}
+
+// requester returns the function making a request, and the one closing the shared connection
+func (c splitCheck) requester() (func(context.Context, int) (*pb.HelloReply, error), func(), error) {
+	if c.stream {
+		return c.streamRequest, func() {}, nil
+	}
+	conn, err := grpc.Dial(c.target, c.dialOpts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	greeter := pb.NewGreeterClient(conn)
+	unary := func(ctx context.Context, i int) (*pb.HelloReply, error) {
+		ctx, cancel := context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+		return greeter.SayHello(outgoing(ctx, strconv.Itoa(i), withHeaders(c.opts, i)), &pb.HelloRequest{Name: *name})
+	}
+	return unary, func() { conn.Close() }, nil
+}
+
+// streamRequest opens a stream, sends a Ping and waits for the Pong
+func (c splitCheck) streamRequest(ctx context.Context, i int) (*pb.HelloReply, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	s, err := openSession(ctx, c.transport, c.target, strconv.Itoa(i), withHeaders(c.opts, i), c.dialOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+	if err := s.Send(&pb.HelloRequest{Name: "Ping split", Seq: 1}); err != nil {
+		return nil, err
+	}
+	reply, err := s.Recv()
+	if err == io.EOF {
+		err = errors.New("the server ended the stream, is it running with -reply ?")
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.CloseSend()
+	return reply, nil
+}
+
+// evaluate compares the replies of each group with the weights, with a chi-squared goodness of fit test
+func (c splitCheck) evaluate(replies map[string]int, failures int) splitReport {
+	r := splitReport{by: c.by, weights: c.weights, replies: replies, failures: failures}
+	total := 0
+	for _, n := range replies {
+		total += n
+	}
+	r.requests = total + failures
+	if total == 0 {
+		r.failed = append(r.failed, "no reply")
+		return r
+	}
+	if failures > 0 {
+		r.failed = append(r.failed, fmt.Sprintf("%d requests failed", failures))
+	}
+
+	for _, group := range r.groups() {
+		weight, expected := c.weights[group]
+		observed := replies[group]
+		if !expected || weight == 0 {
+			if observed > 0 {
+				r.failed = append(r.failed, fmt.Sprintf("%s is not expected and got %d replies", group, observed))
+			}
+			continue
+		}
+		if diff := share(observed, total) - 100*weight; math.Abs(diff) > c.tolerance {
+			r.failed = append(r.failed, fmt.Sprintf("%s is %.1f points off its %.1f%% share, over the %.1f tolerance", group, diff, 100*weight, c.tolerance))
+		}
+		want := weight * float64(total)
+		r.chiSquared += (float64(observed) - want) * (float64(observed) - want) / want
+		r.freedom++
+	}
+	r.freedom--
+	r.pValue = chiSquaredPValue(r.chiSquared, r.freedom)
+	if r.pValue < c.alpha {
+		r.failed = append(r.failed, fmt.Sprintf("the p-value %.3g is below %.3g, the replies do not follow the weights", r.pValue, c.alpha))
+	}
+	return r
+}
+
+// groups returns the expected and observed groups, sorted
+func (r splitReport) groups() []string {
+	seen := map[string]bool{}
+	for group := range r.weights {
+		seen[group] = true
+	}
+	for group := range r.replies {
+		seen[group] = true
+	}
+	groups := make([]string, 0, len(seen))
+	for group := range seen {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+// chiSquaredPValue is the probability of a chi-squared of at least x with k degrees of freedom
+func chiSquaredPValue(x float64, k int) float64 {
+	if k <= 0 || x <= 0 {
+		return 1
+	}
+	return gammaQ(float64(k)/2, x/2)
+}
+
+// gammaQ is the regularized upper incomplete gamma function, from its series below a+1
+// and its continued fraction above
+func gammaQ(a, x float64) float64 {
+	const (
+		epsilon = 1e-14
+		tiny    = 1e-300
+		steps   = 1000
+	)
+	lgamma, _ := math.Lgamma(a)
+	prefix := math.Exp(a*math.Log(x) - x - lgamma)
+	if x < a+1 {
+		sum, term := 1/a, 1/a
+		for n := 1; n < steps; n++ {
+			term *= x / (a + float64(n))
+			sum += term
+			if math.Abs(term) < math.Abs(sum)*epsilon {
+				break
+			}
+		}
+		return 1 - sum*prefix
+	}
+	// modified Lentz
+	b := x + 1 - a
+	c := 1 / tiny
+	d := 1 / b
+	h := d
+	for n := 1; n < steps; n++ {
+		an := -float64(n) * (float64(n) - a)
+		b += 2
+		d = an*d + b
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = b + an/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		delta := d * c
+		h *= delta
+		if math.Abs(delta-1) < epsilon {
+			break
+		}
+	}
+	return h * prefix
+}
+
+// printSplitReport prints the expected and observed share of each group, and the verdict
+func printSplitReport(w io.Writer, r splitReport) {
+	total := r.requests - r.failures
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "%s\tEXPECTED\tREPLIES\tSHARE\tDIFF\n", strings.ToUpper(r.by))
+	for _, group := range r.groups() {
+		expected := 100 * r.weights[group]
+		observed := 0.0
+		if total > 0 {
+			observed = share(r.replies[group], total)
+		}
+		fmt.Fprintf(tw, "%s\t%.1f%%\t%d\t%.1f%%\t%+.1f\n", group, expected, r.replies[group], observed, observed-expected)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "%d requests, %d failed, chi-squared %.2f with %d degrees of freedom, p-value %.3g\n",
+		r.requests, r.failures, r.chiSquared, r.freedom, r.pValue)
+	if len(r.failed) == 0 {
+		fmt.Fprintln(w, "PASS")
+		return
+	}
+	for _, reason := range r.failed {
+		fmt.Fprintln(w, "FAIL: "+reason)
+	}
+}
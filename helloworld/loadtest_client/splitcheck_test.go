@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/prune998/goHelloGrpcStream/helloworld/client"
+	pb "github.com/prune998/goHelloGrpcStream/helloworld/helloworld"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestParseWeights(t *testing.T) {
+	weights, err := parseWeights("v1=90, v2=10,v3=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if weights["v1"] != 0.9 || weights["v2"] != 0.1 || weights["v3"] != 0 || len(weights) != 3 {
+		t.Errorf("got %v", weights)
+	}
+	for _, s := range []string{"", "v1", "=10", "v1=-1", "v1=x", "v1=1,v1=2", "v1=0,v2=0"} {
+		if _, err := parseWeights(s); err == nil {
+			t.Errorf("%q was accepted", s)
+		}
+	}
+}
+
+func TestChiSquaredPValue(t *testing.T) {
+	for _, test := range []struct {
+		x    float64
+		k    int
+		want float64
+	}{
+		{3.841, 1, 0.05},
+		{5.991, 2, 0.05},
+		{23.209, 10, 0.01},
+		{1, 4, 0.9098},
+		{0, 3, 1},
+	} {
+		if got := chiSquaredPValue(test.x, test.k); math.Abs(got-test.want) > 1e-3 {
+			t.Errorf("chiSquaredPValue(%v, %d) = %v, want %v", test.x, test.k, got, test.want)
+		}
+	}
+}
+
+func TestSplitEvaluate(t *testing.T) {
+	check := splitCheck{by: "version", weights: map[string]float64{"v1": 0.9, "v2": 0.1}, tolerance: 5, alpha: 0.001}
+	for _, test := range []struct {
+		replies  map[string]int
+		failures int
+		failed   int
+	}{
+		{map[string]int{"v1": 903, "v2": 97}, 0, 0},
+		{map[string]int{"v1": 1000}, 0, 3},
+		// within the tolerance, but not a 90/10 split
+		{map[string]int{"v1": 860, "v2": 140}, 0, 1},
+		{map[string]int{"v1": 900, "v2": 90, "v3": 10}, 0, 1},
+		{map[string]int{"v1": 900, "v2": 100}, 3, 1},
+		{nil, 10, 1},
+	} {
+		r := check.evaluate(test.replies, test.failures)
+		if len(r.failed) != test.failed {
+			t.Errorf("%v with %d failures: got %d failures %q, want %d", test.replies, test.failures, len(r.failed), r.failed, test.failed)
+		}
+	}
+}
+
+// splitServer answers 3 requests out of 4 as v1 and the other one as v2
+type splitServer struct {
+	pb.UnimplementedGreeterServer
+	calls *int64
+}
+
+func (s splitServer) backend() *pb.Backend {
+	if atomic.AddInt64(s.calls, 1)%4 == 0 {
+		return &pb.Backend{Pod: "greeter-canary", Version: "v2"}
+	}
+	return &pb.Backend{Pod: "greeter-stable", Version: "v1"}
+}
+
+func (s splitServer) SayHello(ctx context.Context, in *pb.HelloRequest) (*pb.HelloReply, error) {
+	return &pb.HelloReply{Message: "Hello " + in.Name, Backend: s.backend()}, nil
+}
+
+func (s splitServer) SayHelloStream(stream pb.Greeter_SayHelloStreamServer) error {
+	msg, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	return stream.Send(&pb.HelloReply{Message: "Pong", Seq: msg.Seq, Backend: s.backend()})
+}
+
+func TestSplitCheck(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	pb.RegisterGreeterServer(s, splitServer{calls: new(int64)})
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	for _, stream := range []bool{false, true} {
+		check := splitCheck{
+			logger:      kitlog.NewNopLogger(),
+			transport:   transportGRPC,
+			target:      lis.Addr().String(),
+			opts:        client.Options{},
+			dialOpts:    []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())},
+			stream:      stream,
+			requests:    200,
+			concurrency: 4,
+			timeout:     5 * time.Second,
+			by:          "version",
+			tolerance:   5,
+			alpha:       0.001,
+		}
+		check.weights, _ = parseWeights("v1=75,v2=25")
+		r := check.run(context.Background())
+		if len(r.failed) > 0 || r.replies["v1"] != 150 || r.replies["v2"] != 50 {
+			t.Errorf("stream %v: got %v, failed %q", stream, r.replies, r.failed)
+		}
+
+		check.weights, _ = parseWeights("v1=50,v2=50")
+		var out bytes.Buffer
+		printSplitReport(&out, check.run(context.Background()))
+		if !strings.Contains(out.String(), "FAIL: v1 is 25.0 points off its 50.0% share") {
+			t.Errorf("stream %v: got\n%s", stream, out.String())
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
+	"github.com/prune998/goHelloGrpcStream/helloworld/client"
+	pb "github.com/prune998/goHelloGrpcStream/helloworld/helloworld"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Transports of the load test streams
+const (
+	transportGRPC      = "grpc"
+	transportWebSocket = "websocket"
+	transportSSE       = "sse"
+)
+
+// paths of the WebSocket and SSE endpoints of greeter_server
+const (
+	webSocketPath = "/ws/hello"
+	ssePath       = "/sse/hello"
+)
+
+// session is a long-lived stream exchanging Ping and Pong messages with the server
+type session interface {
+	// Send sends a message
+	Send(msg *pb.HelloRequest) error
+	// Recv returns the next reply, or io.EOF when the server ended the session cleanly
+	Recv() (*pb.HelloReply, error)
+	// CloseSend tells the server we are done, so it ends the session
+	CloseSend() error
+	// Close releases the connection
+	Close()
+}
+
+// openSession opens a session to the server over transport, announcing our name so the server can apply per client limits.
+// server is the gRPC address for transportGRPC and the HTTP address otherwise.
+func openSession(ctx context.Context, transport, server, name string, opts client.Options, dialOpts []grpc.DialOption) (session, error) {
+	switch transport {
+	case transportGRPC, "":
+		return openGRPCSession(ctx, server, name, opts, dialOpts)
+	case transportWebSocket:
+		return openWebSocketSession(ctx, server, name, opts)
+	case transportSSE:
+		return openSSESession(ctx, server, name, opts)
+	}
+	return nil, fmt.Errorf("unknown transport %q, use %s, %s or %s", transport, transportGRPC, transportWebSocket, transportSSE)
+}
+
+// grpcSession is a SayHelloStream
+type grpcSession struct {
+	conn   *grpc.ClientConn
+	stream pb.Greeter_SayHelloStreamClient
+}
+
+func openGRPCSession(ctx context.Context, server, name string, opts client.Options, dialOpts []grpc.DialOption) (session, error) {
+	grpcOpts := []grpc.DialOption{
+		grpc.WithUnaryInterceptor(grpc_prometheus.UnaryClientInterceptor),
+		grpc.WithStreamInterceptor(grpc_prometheus.StreamClientInterceptor),
+	}
+	grpcOpts = append(grpcOpts, dialOpts...)
+
+	conn, err := grpc.Dial(server, grpcOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("cant connect to server: %w", err)
+	}
+	stream, err := pb.NewGreeterClient(conn).SayHelloStream(outgoing(ctx, name, opts))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &grpcSession{conn: conn, stream: stream}, nil
+}
+
+func (s *grpcSession) Send(msg *pb.HelloRequest) error {
+	return s.stream.Send(msg)
+}
+
+func (s *grpcSession) Recv() (*pb.HelloReply, error) {
+	return s.stream.Recv()
+}
+
+func (s *grpcSession) CloseSend() error {
+	return s.stream.CloseSend()
+}
+
+func (s *grpcSession) Close() {
+	// streams sharing a connection have no conn of their own
+	if s.conn != nil {
+		s.conn.Close()
+	}
+}
+
+// httpURL returns the URL of path on the server HTTP port, with scheme or its TLS variant
+func httpURL(scheme, server, path string, opts client.Options) string {
+	if opts.TLS {
+		scheme += "s"
+	}
+	return scheme + "://" + client.HTTPHost(server) + path
+}
+
+// requestHeader returns the credentials, custom and client name headers
+func requestHeader(name string, opts client.Options) (http.Header, error) {
+	header, err := opts.CredentialHeaders()
+	if err != nil {
+		return nil, err
+	}
+	header.Set(client.NameHeader, name)
+	return header, nil
+}
+
+// webSocketSession is a WebSocket on the server /ws/hello endpoint
+type webSocketSession struct {
+	conn *websocket.Conn
+}
+
+func openWebSocketSession(ctx context.Context, server, name string, opts client.Options) (session, error) {
+	header, err := requestHeader(name, opts)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &websocket.Dialer{HandshakeTimeout: 10 * time.Second, NetDialContext: opts.DialContext(server)}
+	if opts.TLS {
+		if dialer.TLSClientConfig, err = opts.TLSConfig(); err != nil {
+			return nil, err
+		}
+	}
+	conn, resp, err := dialer.DialContext(ctx, httpURL("ws", server, webSocketPath, opts), header)
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("%w: %s", err, resp.Status)
+		}
+		return nil, err
+	}
+
+	// answer the close of the server, unless we already sent ours, so Recv reports its code
+	conn.SetCloseHandler(func(code int, _ string) error {
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, ""), time.Now().Add(time.Second))
+		return nil
+	})
+
+	// like gRPC streams, the session ends with its context
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	return &webSocketSession{conn: conn}, nil
+}
+
+func (s *webSocketSession) Send(msg *pb.HelloRequest) error {
+	b, err := protojson.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return s.conn.WriteMessage(websocket.TextMessage, b)
+}
+
+func (s *webSocketSession) Recv() (*pb.HelloReply, error) {
+	_, data, err := s.conn.ReadMessage()
+	if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	reply := &pb.HelloReply{}
+	if err := protojson.Unmarshal(data, reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (s *webSocketSession) CloseSend() error {
+	return s.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+}
+
+func (s *webSocketSession) Close() {
+	s.conn.Close()
+}
+
+// sseSession is an event stream on the server /sse/hello endpoint, the messages being sent with POSTs
+type sseSession struct {
+	ctx    context.Context
+	client *http.Client
+	url    string
+	header http.Header
+	body   io.ReadCloser
+	events *bufio.Reader
+}
+
+func openSSESession(ctx context.Context, server, name string, opts client.Options) (session, error) {
+	header, err := requestHeader(name, opts)
+	if err != nil {
+		return nil, err
+	}
+	httpClient, err := opts.HTTPClient(server)
+	if err != nil {
+		return nil, err
+	}
+	s := &sseSession{ctx: ctx, client: httpClient, url: httpURL("http", server, ssePath, opts), header: header}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = header.Clone()
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("could not open the event stream: %s", resp.Status)
+	}
+	s.body = resp.Body
+	s.events = bufio.NewReader(resp.Body)
+
+	// the first event gives the session id to send messages to
+	event, data, err := s.next()
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	if event != "session" {
+		s.Close()
+		return nil, fmt.Errorf("expected a session event, got %s: %s", event, data)
+	}
+	s.url += "/" + data
+	return s, nil
+}
+
+// next reads the next event
+func (s *sseSession) next() (event, data string, err error) {
+	for {
+		line, err := s.events.ReadString('\n')
+		if err != nil {
+			return "", "", err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "" && event != "":
+			return event, data, nil
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data = strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		}
+	}
+}
+
+// do sends a request to the session URL and checks it was accepted
+func (s *sseSession) do(method string, body []byte) error {
+	req, err := http.NewRequestWithContext(s.ctx, method, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header = s.header.Clone()
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func (s *sseSession) Send(msg *pb.HelloRequest) error {
+	b, err := protojson.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return s.do(http.MethodPost, b)
+}
+
+func (s *sseSession) Recv() (*pb.HelloReply, error) {
+	event, data, err := s.next()
+	if err != nil {
+		return nil, err
+	}
+	switch event {
+	case "end":
+		return nil, io.EOF
+	case "error":
+		return nil, errors.New(data)
+	}
+	reply := &pb.HelloReply{}
+	if err := protojson.Unmarshal([]byte(data), reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (s *sseSession) CloseSend() error {
+	return s.do(http.MethodDelete, nil)
+}
+
+func (s *sseSession) Close() {
+	s.body.Close()
+}
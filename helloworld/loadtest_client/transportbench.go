@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/prune998/goHelloGrpcStream/helloworld/client"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// sessionStats counts what happened to the sessions of the clients sharing it
+type sessionStats struct {
+	mu                     sync.Mutex
+	sessions, failures     int
+	survivors, disconnects int
+	pings, pongs           int
+	latencies              []time.Duration
+	// active are the sessions open, peak the most that were at once
+	active, peak int
+	// sequenceErrors counts the messages by sequence error
+	sequenceErrors map[string]int
+	// backends counts the replies by backend
+	backends backendReplies
+}
+
+func (s *sessionStats) opened() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions++
+	s.active++
+	if s.active > s.peak {
+		s.peak = s.active
+	}
+}
+
+func (s *sessionStats) closed() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.active--
+}
+
+func (s *sessionStats) failed() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures++
+}
+
+func (s *sessionStats) survived() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.survivors++
+}
+
+func (s *sessionStats) disconnected() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.disconnects++
+}
+
+func (s *sessionStats) ping() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pings++
+}
+
+func (s *sessionStats) pong(latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pongs++
+	s.latencies = append(s.latencies, latency)
+}
+
+func (s *sessionStats) sequenceError(err string, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sequenceErrors == nil {
+		s.sequenceErrors = map[string]int{}
+	}
+	s.sequenceErrors[err] += n
+}
+
+func (s *sessionStats) reply(b backend) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.backends == nil {
+		s.backends = backendReplies{}
+	}
+	s.backends[b]++
+}
+
+// percentile returns the p-th percentile of the Pong latencies, between 0 and 1
+func (s *sessionStats) percentile(p float64) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.latencies) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), s.latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[int(p*float64(len(sorted)-1))]
+}
+
+// sequenceSummary returns the number of messages with each sequence error
+func (s *sessionStats) sequenceSummary() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var parts []string
+	for _, err := range []string{seqGap, seqReordered, seqDuplicate, seqUnanswered} {
+		parts = append(parts, fmt.Sprintf("%d %s", s.sequenceErrors[err], err))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// transportResult is what one transport did, and cost, during the benchmark
+type transportResult struct {
+	transport string
+	stats     *sessionStats
+	cpu       time.Duration
+	alloc     uint64
+}
+
+// cpuPerSession is the client CPU used by each session
+func (r transportResult) cpuPerSession() time.Duration {
+	if r.stats.sessions == 0 {
+		return 0
+	}
+	return r.cpu / time.Duration(r.stats.sessions)
+}
+
+// allocPerSession is the memory allocated by each session
+func (r transportResult) allocPerSession() float64 {
+	if r.stats.sessions == 0 {
+		return 0
+	}
+	return float64(r.alloc) / float64(r.stats.sessions)
+}
+
+// runTransportBench runs the same ping/pong load once per transport, one after the other,
+// and reports how the sessions survived, their latency and the resources they used
+func runTransportBench(ctx context.Context, logger kitlog.Logger, opts client.Options, dialOpts []grpc.DialOption, transports []string) {
+	var results []transportResult
+	for _, transport := range transports {
+		r := benchTransport(ctx, logger, transport, opts, dialOpts, *clients, *benchDuration)
+		logger.Log("msg", "transport bench done", "transport", r.transport, "sessions", r.stats.sessions,
+			"failures", r.stats.failures, "disconnects", r.stats.disconnects, "pongs", r.stats.pongs, "cpu", r.cpu)
+		results = append(results, r)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	printTransportReport(os.Stdout, results)
+}
+
+// benchTransport runs clients sessions over transport, each sending a Ping every -sleeptime, for duration
+func benchTransport(ctx context.Context, logger kitlog.Logger, transport string, opts client.Options, dialOpts []grpc.DialOption, clients int, duration time.Duration) transportResult {
+	result := transportResult{transport: transport, stats: &sessionStats{}}
+	target := loadTarget(transport)
+
+	ctx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	allocBefore := mem.TotalAlloc
+	cpuBefore := cpuTime()
+
+	jobChan := make(chan int, clients)
+	for i := 0; i < clients; i++ {
+		c := NewClient(strconv.Itoa(i), logger, *debug, transport, withHeaders(opts, i), dialOpts)
+		c.stats = result.stats
+		go c.Start(ctx, jobChan, target, strconv.Itoa(i), i)
+	}
+	for i := 0; i < clients; i++ {
+		<-jobChan
+	}
+
+	result.cpu = cpuTime() - cpuBefore
+	runtime.ReadMemStats(&mem)
+	result.alloc = mem.TotalAlloc - allocBefore
+	return result
+}
+
+// printTransportReport prints one line per transport, with the deltas against the first one
+func printTransportReport(w io.Writer, results []transportResult) {
+	if len(results) == 0 {
+		return
+	}
+	base := results[0]
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TRANSPORT\tSESSIONS\tFAILED\tSURVIVED\tDISCONNECTED\tPINGS\tPONGS\tP50\tP99\tΔ P99\tCPU/SESSION\tΔ CPU/SESSION\tALLOC/SESSION\tΔ ALLOC/SESSION\tSEQUENCE ERRORS")
+	for _, r := range results {
+		s := r.stats
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\t%d\t%v\t%v\t%s\t%v\t%s\t%.0f\t%s\t%s\n",
+			r.transport, s.sessions, s.failures, s.survivors, s.disconnects, s.pings, s.pongs,
+			s.percentile(0.5), s.percentile(0.99), delta(float64(s.percentile(0.99)), float64(base.stats.percentile(0.99))),
+			r.cpuPerSession(), delta(float64(r.cpuPerSession()), float64(base.cpuPerSession())),
+			r.allocPerSession(), delta(r.allocPerSession(), base.allocPerSession()), s.sequenceSummary())
+	}
+	tw.Flush()
+}
+
+// printSummary prints what happened to the sessions of the load test
+func printSummary(w io.Writer, s *sessionStats) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SESSIONS\tFAILED\tSURVIVED\tDISCONNECTED\tPINGS\tPONGS\tP50\tP99\tSEQUENCE ERRORS")
+	fmt.Fprintf(tw, "%d\t%d\t%d\t%d\t%d\t%d\t%v\t%v\t%s\n", s.sessions, s.failures, s.survivors, s.disconnects,
+		s.pings, s.pongs, s.percentile(0.5), s.percentile(0.99), s.sequenceSummary())
+	tw.Flush()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.backends) > 0 {
+		fmt.Fprintln(w)
+		printBackends(w, s.backends)
+	}
+}
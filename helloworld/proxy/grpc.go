@@ -0,0 +1,371 @@
+// Package proxy implements greeter_proxy, a local proxy sitting between the clients and greeter_server
+// to reproduce the timeouts, limits and resets of a service mesh sidecar.
+package proxy
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc/codes"
+)
+
+// Options configures a Proxy. The limits and faults are all disabled by default.
+type Options struct {
+	// Upstream is the address of the gRPC server, like localhost:7788
+	Upstream string
+	// UpstreamTLS enables TLS to the upstream, which is plain HTTP/2 otherwise
+	UpstreamTLS *tls.Config
+
+	// RequestTimeout ends the calls not done in time, streams included, like the Envoy route timeout
+	RequestTimeout time.Duration
+	// MaxStreamDuration ends the calls, streams included, after this duration, like the Envoy max_stream_duration
+	MaxStreamDuration time.Duration
+	// IdleTimeout ends the calls without any message in either direction for this duration, like the Envoy stream_idle_timeout
+	IdleTimeout time.Duration
+	// MaxConcurrentStreams is the HTTP/2 streams limit per downstream connection, where the clients queue their calls
+	MaxConcurrentStreams uint32
+	// MaxStreams rejects the calls over this number of calls in flight, like an Envoy circuit breaker
+	MaxStreams int64
+
+	// ResetRate is the fraction of calls, between 0 and 1, reset with an HTTP/2 RST_STREAM
+	ResetRate float64
+	// ResetAfter delays the resets, so streams are reset in the middle. They are reset before reaching the upstream otherwise.
+	ResetAfter time.Duration
+	// GoAwayRate is the fraction of calls, between 0 and 1, whose connection is sent an HTTP/2 GOAWAY with the response headers
+	GoAwayRate float64
+
+	// Logger is used for the logs. A JSON logger on stdout is used when nil.
+	Logger *logrus.Logger
+}
+
+// why a call was ended by the proxy
+const (
+	endRequestTimeout    = "request_timeout"
+	endMaxStreamDuration = "max_stream_duration"
+	endIdleTimeout       = "idle_timeout"
+	endReset             = "reset"
+)
+
+// endStatus is the gRPC status sent when the proxy ends a call, with the messages of Envoy
+var endStatus = map[string]struct {
+	code codes.Code
+	msg  string
+}{
+	endRequestTimeout:    {codes.Unavailable, "upstream request timeout"},
+	endMaxStreamDuration: {codes.DeadlineExceeded, "upstream max stream duration reached"},
+	endIdleTimeout:       {codes.Unavailable, "stream timeout"},
+}
+
+// Proxy forwards any gRPC call to the upstream, at the HTTP/2 level so it can send RST_STREAM and GOAWAY frames
+type Proxy struct {
+	opts      Options
+	log       *logrus.Entry
+	transport *http2.Transport
+	streams   int64
+
+	mu        sync.Mutex
+	lis       net.Listener
+	srv       *http.Server
+	isStopped bool
+}
+
+// New creates a Proxy. Nothing is listening until Serve is called.
+func New(opts Options) *Proxy {
+	if opts.Logger == nil {
+		opts.Logger = logrus.New()
+		opts.Logger.SetFormatter(&logrus.JSONFormatter{})
+	}
+	p := &Proxy{
+		opts: opts,
+		log: opts.Logger.WithFields(logrus.Fields{
+			"application": "greeter_proxy",
+		}),
+		transport: &http2.Transport{TLSClientConfig: opts.UpstreamTLS},
+	}
+	if opts.UpstreamTLS == nil {
+		p.transport.AllowHTTP = true
+		p.transport.DialTLSContext = func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		}
+	}
+	return p
+}
+
+// Serve accepts connections on lis until Stop is called. HTTP/2 is in clear text (h2c).
+func (p *Proxy) Serve(lis net.Listener) error {
+	srv := &http.Server{Handler: h2c.NewHandler(p, &http2.Server{MaxConcurrentStreams: p.opts.MaxConcurrentStreams})}
+
+	p.mu.Lock()
+	if p.isStopped {
+		p.mu.Unlock()
+		return http.ErrServerClosed
+	}
+	p.lis = lis
+	p.srv = srv
+	p.mu.Unlock()
+
+	p.log.Warnf("proxying gRPC from %v to %s", lis.Addr(), p.opts.Upstream)
+	err := srv.Serve(lis)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Addr returns the address the proxy is bound to, or nil if it is not serving
+func (p *Proxy) Addr() net.Addr {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.lis == nil {
+		return nil
+	}
+	return p.lis.Addr()
+}
+
+// Stop closes the listener and all the connections
+func (p *Proxy) Stop() {
+	p.mu.Lock()
+	p.isStopped = true
+	srv := p.srv
+	p.mu.Unlock()
+
+	if srv != nil {
+		srv.Close()
+	}
+	p.transport.CloseIdleConnections()
+}
+
+// ServeHTTP forwards a gRPC call to the upstream, enforcing the limits and injecting the faults
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 2 || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "greeter_proxy only forwards gRPC", http.StatusUnsupportedMediaType)
+		return
+	}
+	log := p.log.WithFields(logrus.Fields{
+		"method": r.URL.Path,
+		"peer":   r.RemoteAddr,
+	})
+
+	if n := atomic.AddInt64(&p.streams, 1); p.opts.MaxStreams > 0 && n > p.opts.MaxStreams {
+		atomic.AddInt64(&p.streams, -1)
+		PromCallsCounter.WithLabelValues("overflow").Inc()
+		writeStatus(w, codes.Unavailable, "upstream connect error or disconnect/reset before headers. reset reason: overflow")
+		return
+	}
+	defer atomic.AddInt64(&p.streams, -1)
+	PromStreamsGauge.Inc()
+	defer PromStreamsGauge.Dec()
+
+	if p.opts.GoAwayRate > 0 && rand.Float64() < p.opts.GoAwayRate {
+		// the HTTP/2 server sends a GOAWAY along with the response headers
+		PromCallsCounter.WithLabelValues("goaway").Inc()
+		log.Info("sending GOAWAY")
+		w.Header().Set("Connection", "close")
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	c := &call{cancel: cancel}
+	defer c.stop()
+	if p.opts.RequestTimeout > 0 {
+		c.after(p.opts.RequestTimeout, endRequestTimeout)
+	}
+	if p.opts.MaxStreamDuration > 0 {
+		c.after(p.opts.MaxStreamDuration, endMaxStreamDuration)
+	}
+	if p.opts.IdleTimeout > 0 {
+		c.idle = c.after(p.opts.IdleTimeout, endIdleTimeout)
+		c.idleTimeout = p.opts.IdleTimeout
+	}
+	if p.opts.ResetRate > 0 && rand.Float64() < p.opts.ResetRate {
+		if p.opts.ResetAfter <= 0 {
+			p.reset(log, "before reaching the upstream")
+		}
+		c.after(p.opts.ResetAfter, endReset)
+	}
+
+	scheme := "http"
+	if p.opts.UpstreamTLS != nil {
+		scheme = "https"
+	}
+	out := r.Clone(ctx)
+	out.RequestURI = ""
+	out.URL.Scheme = scheme
+	out.URL.Host = p.opts.Upstream
+	out.Body = &activityReader{ReadCloser: r.Body, call: c}
+	resp, err := p.transport.RoundTrip(out)
+	if err != nil {
+		p.end(w, log, c, func() {
+			PromCallsCounter.WithLabelValues("upstream_error").Inc()
+			writeStatus(w, codes.Unavailable, "upstream connect error or disconnect/reset before headers. reset reason: "+err.Error())
+		})
+		return
+	}
+	defer resp.Body.Close()
+	c.setBody(resp.Body)
+
+	for key, values := range resp.Header {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(resp.StatusCode)
+	w.(http.Flusher).Flush()
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			c.active()
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			p.end(w, log, c, func() {
+				// the upstream went away in the middle of the response
+				PromCallsCounter.WithLabelValues("upstream_error").Inc()
+				setTrailerStatus(w, codes.Unavailable, "upstream reset: "+err.Error())
+			})
+			return
+		}
+	}
+	for key, values := range resp.Trailer {
+		w.Header()[http.TrailerPrefix+key] = values
+	}
+	PromCallsCounter.WithLabelValues("ok").Inc()
+}
+
+// end ends a call that failed, with the status of the limit that stopped it if any, or with fail otherwise
+func (p *Proxy) end(w http.ResponseWriter, log *logrus.Entry, c *call, fail func()) {
+	reason := c.reason()
+	if reason == "" {
+		fail()
+		return
+	}
+	if reason == endReset {
+		p.reset(log, "in the middle of the call")
+	}
+	PromCallsCounter.WithLabelValues(reason).Inc()
+	log.Infof("ending the call: %s", reason)
+	st := endStatus[reason]
+	if _, headersSent := w.Header()["Content-Type"]; headersSent {
+		setTrailerStatus(w, st.code, st.msg)
+		return
+	}
+	writeStatus(w, st.code, st.msg)
+}
+
+// reset sends an RST_STREAM, which the HTTP/2 server does when the handler panics with ErrAbortHandler
+func (p *Proxy) reset(log *logrus.Entry, when string) {
+	PromCallsCounter.WithLabelValues(endReset).Inc()
+	log.Infof("resetting the stream %s", when)
+	panic(http.ErrAbortHandler)
+}
+
+// writeStatus ends a call before any response, with a trailers-only response
+func writeStatus(w http.ResponseWriter, code codes.Code, msg string) {
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Grpc-Status", strconv.Itoa(int(code)))
+	w.Header().Set("Grpc-Message", msg)
+	w.WriteHeader(http.StatusOK)
+}
+
+// setTrailerStatus ends a call whose response started, replacing the upstream status
+func setTrailerStatus(w http.ResponseWriter, code codes.Code, msg string) {
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(int(code)))
+	w.Header().Set(http.TrailerPrefix+"Grpc-Message", msg)
+}
+
+// call keeps track of the timers that can end a call, and of the first one that did
+type call struct {
+	cancel      context.CancelFunc
+	mu          sync.Mutex
+	ended       string
+	body        io.Closer
+	timers      []*time.Timer
+	idle        *time.Timer
+	idleTimeout time.Duration
+}
+
+// after ends the call for reason after d
+func (c *call) after(d time.Duration, reason string) *time.Timer {
+	t := time.AfterFunc(d, func() {
+		c.mu.Lock()
+		if c.ended == "" {
+			c.ended = reason
+		}
+		body := c.body
+		c.mu.Unlock()
+		c.cancel()
+		if body != nil {
+			// the upstream stream only watches the context until the response headers
+			body.Close()
+		}
+	})
+	c.mu.Lock()
+	c.timers = append(c.timers, t)
+	c.mu.Unlock()
+	return t
+}
+
+// active pushes the idle timeout back
+func (c *call) active() {
+	if c.idle != nil {
+		c.idle.Reset(c.idleTimeout)
+	}
+}
+
+// setBody sets the response of the upstream, closed when the call ends
+func (c *call) setBody(body io.Closer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.body = body
+	if c.ended != "" {
+		body.Close()
+	}
+}
+
+func (c *call) reason() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ended
+}
+
+func (c *call) stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, t := range c.timers {
+		t.Stop()
+	}
+}
+
+// activityReader tells the call each time a request message goes through
+type activityReader struct {
+	io.ReadCloser
+	call *call
+}
+
+func (r *activityReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.call.active()
+	}
+	return n, err
+}
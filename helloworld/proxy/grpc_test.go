@@ -0,0 +1,221 @@
+package proxy_test
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	pb "github.com/prune998/goHelloGrpcStream/helloworld/helloworld"
+	"github.com/prune998/goHelloGrpcStream/helloworld/proxy"
+	"github.com/prune998/goHelloGrpcStream/helloworld/server"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// startProxy starts a greeter server answering the stream messages, and a proxy in front of it
+func startProxy(t *testing.T, opts proxy.Options) pb.GreeterClient {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	serverOpts := server.DefaultOptions()
+	serverOpts.Reply = true
+	serverOpts.Logger = logger
+	s, err := server.New(serverOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	opts.Upstream = lis.Addr().String()
+	opts.Logger = logger
+	p := proxy.New(opts)
+	plis, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.Serve(plis)
+	t.Cleanup(p.Stop)
+
+	conn, err := grpc.Dial(plis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewGreeterClient(conn)
+}
+
+// wantStatus checks err has the code and a message containing msg
+func wantStatus(t *testing.T, err error, code codes.Code, msg string) {
+	t.Helper()
+	st := status.Convert(err)
+	if st.Code() != code || !strings.Contains(st.Message(), msg) {
+		t.Errorf("got %v, want %v %q", err, code, msg)
+	}
+}
+
+func TestForward(t *testing.T) {
+	c := startProxy(t, proxy.Options{})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var header metadata.MD
+	r, err := c.SayHello(ctx, &pb.HelloRequest{Name: "proxy"}, grpc.Header(&header))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(r.Message, "Hello proxy") {
+		t.Errorf("got message %q", r.Message)
+	}
+
+	stream, err := c.SayHelloStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"one", "two"} {
+		if err := stream.Send(&pb.HelloRequest{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+		if r, err := stream.Recv(); err != nil || r.Message != "Pong "+name {
+			t.Fatalf("got %v, %v", r, err)
+		}
+	}
+	stream.CloseSend()
+	if _, err := stream.Recv(); err != io.EOF {
+		t.Errorf("got %v at the end of the stream, want EOF", err)
+	}
+}
+
+func TestTimeouts(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		opts proxy.Options
+		code codes.Code
+		msg  string
+	}{
+		{"request", proxy.Options{RequestTimeout: 200 * time.Millisecond}, codes.Unavailable, "upstream request timeout"},
+		{"duration", proxy.Options{MaxStreamDuration: 200 * time.Millisecond}, codes.DeadlineExceeded, "upstream max stream duration reached"},
+		{"idle", proxy.Options{IdleTimeout: 200 * time.Millisecond}, codes.Unavailable, "stream timeout"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			c := startProxy(t, test.opts)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			stream, err := c.SayHelloStream(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := stream.Send(&pb.HelloRequest{Name: "one"}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := stream.Recv(); err != nil {
+				t.Fatal(err)
+			}
+			start := time.Now()
+			_, err = stream.Recv()
+			wantStatus(t, err, test.code, test.msg)
+			if d := time.Since(start); d > 2*time.Second {
+				t.Errorf("ended after %v", d)
+			}
+		})
+	}
+}
+
+func TestIdleTimeoutActive(t *testing.T) {
+	c := startProxy(t, proxy.Options{IdleTimeout: 300 * time.Millisecond})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// messages more frequent than the idle timeout keep the stream open
+	ss, err := c.SayHelloServerStream(ctx, &pb.HelloStreamRequest{Name: "busy", Count: 6, IntervalMs: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for {
+		_, err := ss.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("after %d messages: %v", n, err)
+		}
+		n++
+	}
+	if n != 6 {
+		t.Errorf("got %d messages", n)
+	}
+}
+
+func TestMaxStreams(t *testing.T) {
+	c := startProxy(t, proxy.Options{MaxStreams: 1})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := c.SayHelloStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Send(&pb.HelloRequest{Name: "one"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.SayHello(ctx, &pb.HelloRequest{Name: "overflow"})
+	wantStatus(t, err, codes.Unavailable, "overflow")
+}
+
+func TestReset(t *testing.T) {
+	c := startProxy(t, proxy.Options{ResetRate: 1})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := c.SayHello(ctx, &pb.HelloRequest{Name: "reset"})
+	wantStatus(t, err, codes.Internal, "RST_STREAM")
+
+	c = startProxy(t, proxy.Options{ResetRate: 1, ResetAfter: 200 * time.Millisecond})
+	stream, err := c.SayHelloStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Send(&pb.HelloRequest{Name: "one"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatal(err)
+	}
+	_, err = stream.Recv()
+	wantStatus(t, err, codes.Internal, "RST_STREAM")
+}
+
+func TestGoAway(t *testing.T) {
+	c := startProxy(t, proxy.Options{GoAwayRate: 1})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	before := testutil.ToFloat64(proxy.PromCallsCounter.WithLabelValues("goaway"))
+	// the calls succeed, the client reconnecting after each GOAWAY
+	for i := 0; i < 3; i++ {
+		if _, err := c.SayHello(ctx, &pb.HelloRequest{Name: "goaway"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := testutil.ToFloat64(proxy.PromCallsCounter.WithLabelValues("goaway")) - before; n != 3 {
+		t.Errorf("got %v GOAWAY", n)
+	}
+}
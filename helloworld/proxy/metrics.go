@@ -0,0 +1,38 @@
+package proxy
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	PromStreamsGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "greeter_proxy_streams_gauge",
+		Help: "current calls forwarded to the upstream",
+	})
+
+	PromCallsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "greeter_proxy_calls_counter",
+		Help: "calls by how they ended: ok, upstream_error, overflow, request_timeout, max_stream_duration, idle_timeout, reset or goaway",
+	}, []string{"result"})
+
+	PromTCPConnectionsGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "greeter_proxy_tcp_connections_gauge",
+		Help: "current TCP connections forwarded to the upstream",
+	})
+
+	PromTCPBytesCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "greeter_proxy_tcp_bytes_counter",
+		Help: "TCP bytes by direction, upstream or downstream, and result, forwarded or dropped by the black hole",
+	}, []string{"direction", "result"})
+
+	PromTCPResetsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "greeter_proxy_tcp_resets_counter",
+		Help: "TCP connections reset, by reason: bytes, time or api",
+	}, []string{"reason"})
+)
+
+func init() {
+	prometheus.MustRegister(PromStreamsGauge)
+	prometheus.MustRegister(PromCallsCounter)
+	prometheus.MustRegister(PromTCPConnectionsGauge)
+	prometheus.MustRegister(PromTCPBytesCounter)
+	prometheus.MustRegister(PromTCPResetsCounter)
+}
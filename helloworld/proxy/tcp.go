@@ -0,0 +1,437 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"golang.org/x/time/rate"
+)
+
+// paths of the TCP proxy control API
+const (
+	ChaosPath = "/chaos"
+	ResetPath = "/reset"
+)
+
+// Chaos are the network faults of a TCPProxy, all disabled by default.
+// They can be changed while it runs and apply to the open connections, except ResetAfter.
+type Chaos struct {
+	// Latency delays the data forwarded, in each direction
+	Latency time.Duration
+	// Jitter adds a random delay, up to Jitter, to Latency
+	Jitter time.Duration
+	// Bandwidth limits each connection to this many bytes/s, in each direction
+	Bandwidth int
+	// ResetAfterBytes resets the connections after they forwarded this many bytes, in both directions
+	ResetAfterBytes int64
+	// ResetAfter resets the connections opened for this duration. It applies to the new connections only.
+	ResetAfter time.Duration
+	// BlackHole drops all the data, keeping the connections open, like a network partition does
+	BlackHole bool
+	// SlowClose delays passing on a side closing the connection to the other side
+	SlowClose time.Duration
+}
+
+// Set changes a fault, named like the greeter_proxy tcp flags
+func (c *Chaos) Set(key, value string) error {
+	var err error
+	switch key {
+	case "latency":
+		c.Latency, err = time.ParseDuration(value)
+	case "jitter":
+		c.Jitter, err = time.ParseDuration(value)
+	case "bandwidth":
+		c.Bandwidth, err = strconv.Atoi(value)
+	case "resetafterbytes":
+		c.ResetAfterBytes, err = strconv.ParseInt(value, 10, 64)
+	case "resetafter":
+		c.ResetAfter, err = time.ParseDuration(value)
+	case "blackhole":
+		c.BlackHole, err = strconv.ParseBool(value)
+	case "slowclose":
+		c.SlowClose, err = time.ParseDuration(value)
+	default:
+		return fmt.Errorf("unknown fault %q", key)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return nil
+}
+
+// MarshalJSON uses the names of Set, with readable durations
+func (c Chaos) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"latency":         c.Latency.String(),
+		"jitter":          c.Jitter.String(),
+		"bandwidth":       c.Bandwidth,
+		"resetafterbytes": c.ResetAfterBytes,
+		"resetafter":      c.ResetAfter.String(),
+		"blackhole":       c.BlackHole,
+		"slowclose":       c.SlowClose.String(),
+	})
+}
+
+// delay returns the latency of a chunk of data
+func (c Chaos) delay() time.Duration {
+	d := c.Latency
+	if c.Jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(c.Jitter)))
+	}
+	return d
+}
+
+// TCPProxy forwards TCP connections to the upstream, injecting network faults gRPC never sees coming
+type TCPProxy struct {
+	upstream string
+	log      *logrus.Entry
+
+	mu        sync.Mutex
+	chaos     Chaos
+	conns     map[*chaosConn]struct{}
+	lis       net.Listener
+	isStopped bool
+}
+
+// NewTCP creates a TCPProxy to upstream. Nothing is listening until Serve is called.
+func NewTCP(upstream string, chaos Chaos, logger *logrus.Logger) *TCPProxy {
+	if logger == nil {
+		logger = logrus.New()
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	}
+	return &TCPProxy{
+		upstream: upstream,
+		chaos:    chaos,
+		conns:    map[*chaosConn]struct{}{},
+		log: logger.WithFields(logrus.Fields{
+			"application": "greeter_proxy",
+			"mode":        "tcp",
+		}),
+	}
+}
+
+// Serve accepts connections on lis until Stop is called
+func (p *TCPProxy) Serve(lis net.Listener) error {
+	p.mu.Lock()
+	if p.isStopped {
+		p.mu.Unlock()
+		return net.ErrClosed
+	}
+	p.lis = lis
+	p.mu.Unlock()
+
+	p.log.Warnf("proxying TCP from %v to %s", lis.Addr(), p.upstream)
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			p.mu.Lock()
+			stopped := p.isStopped
+			p.mu.Unlock()
+			if stopped {
+				return nil
+			}
+			return err
+		}
+		go p.handle(conn)
+	}
+}
+
+// Addr returns the address the proxy is bound to, or nil if it is not serving
+func (p *TCPProxy) Addr() net.Addr {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.lis == nil {
+		return nil
+	}
+	return p.lis.Addr()
+}
+
+// Stop closes the listener and all the connections
+func (p *TCPProxy) Stop() {
+	p.mu.Lock()
+	p.isStopped = true
+	if p.lis != nil {
+		p.lis.Close()
+	}
+	conns := p.openConns()
+	p.mu.Unlock()
+
+	for _, c := range conns {
+		c.close()
+	}
+}
+
+// Chaos returns the current faults
+func (p *TCPProxy) Chaos() Chaos {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.chaos
+}
+
+// SetChaos replaces the faults
+func (p *TCPProxy) SetChaos(chaos Chaos) {
+	p.mu.Lock()
+	p.chaos = chaos
+	p.mu.Unlock()
+	p.log.Warnf("chaos set to %+v", chaos)
+}
+
+// ResetAll resets the open connections and returns how many there were
+func (p *TCPProxy) ResetAll() int {
+	p.mu.Lock()
+	conns := p.openConns()
+	p.mu.Unlock()
+
+	for _, c := range conns {
+		c.reset("api")
+	}
+	return len(conns)
+}
+
+// openConns must be called with p.mu held
+func (p *TCPProxy) openConns() []*chaosConn {
+	conns := make([]*chaosConn, 0, len(p.conns))
+	for c := range p.conns {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// ControlHandler serves the runtime control API :
+// GET /chaos returns the faults, POST /chaos?latency=100ms&blackhole=true changes some of them,
+// DELETE /chaos removes them all and POST /reset resets the open connections.
+func (p *TCPProxy) ControlHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(ChaosPath, p.serveChaos)
+	mux.HandleFunc(ResetPath, p.serveReset)
+	return mux
+}
+
+func (p *TCPProxy) serveChaos(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost, http.MethodPut:
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		chaos := p.Chaos()
+		for key, values := range r.Form {
+			if err := chaos.Set(key, values[len(values)-1]); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		p.SetChaos(chaos)
+	case http.MethodDelete:
+		p.SetChaos(Chaos{})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p.Chaos())
+}
+
+func (p *TCPProxy) serveReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"reset": p.ResetAll()})
+}
+
+// handle forwards a connection until both sides closed it, or it is reset
+func (p *TCPProxy) handle(down net.Conn) {
+	log := p.log.WithField("peer", down.RemoteAddr().String())
+	up, err := net.DialTimeout("tcp", p.upstream, 10*time.Second)
+	if err != nil {
+		log.Errorf("failed to connect to the upstream: %v", err)
+		down.Close()
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &chaosConn{proxy: p, log: log, down: down, up: up, ctx: ctx, cancel: cancel}
+	p.mu.Lock()
+	p.conns[c] = struct{}{}
+	chaos := p.chaos
+	p.mu.Unlock()
+	PromTCPConnectionsGauge.Inc()
+	defer func() {
+		p.mu.Lock()
+		delete(p.conns, c)
+		p.mu.Unlock()
+		PromTCPConnectionsGauge.Dec()
+		c.close()
+	}()
+
+	if chaos.ResetAfter > 0 {
+		t := time.AfterFunc(chaos.ResetAfter, func() { c.reset("time") })
+		defer t.Stop()
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		c.pipe(up, down, "upstream")
+	}()
+	go func() {
+		defer wg.Done()
+		c.pipe(down, up, "downstream")
+	}()
+	wg.Wait()
+}
+
+// chaosConn is a proxied connection, from the client (down) to the upstream (up)
+type chaosConn struct {
+	proxy  *TCPProxy
+	log    *logrus.Entry
+	down   net.Conn
+	up     net.Conn
+	bytes  int64
+	ctx    context.Context
+	cancel context.CancelFunc
+	once   sync.Once
+}
+
+// chunk is data waiting for its latency
+type chunk struct {
+	data []byte
+	due  time.Time
+}
+
+// pipe forwards src to dst, in direction. The data is read as it comes and written once delayed and within the bandwidth.
+func (c *chaosConn) pipe(dst, src net.Conn, direction string) {
+	chunks := make(chan chunk, 64)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.write(dst, chunks, direction)
+	}()
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			chaos := c.proxy.Chaos()
+			if chaos.ResetAfterBytes > 0 && atomic.AddInt64(&c.bytes, int64(n)) >= chaos.ResetAfterBytes {
+				c.reset("bytes")
+				break
+			}
+			if chaos.BlackHole {
+				PromTCPBytesCounter.WithLabelValues(direction, "dropped").Add(float64(n))
+				continue
+			}
+			data := append([]byte(nil), buf[:n]...)
+			select {
+			case chunks <- chunk{data: data, due: time.Now().Add(chaos.delay())}:
+			case <-c.ctx.Done():
+			}
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				// the other pipe gets the error too
+				c.close()
+			}
+			break
+		}
+	}
+	close(chunks)
+	<-done
+}
+
+// write writes the chunks to dst, then passes on the end of the data
+func (c *chaosConn) write(dst net.Conn, chunks <-chan chunk, direction string) {
+	var limiter *rate.Limiter
+	for ch := range chunks {
+		if !c.sleep(time.Until(ch.due)) {
+			continue
+		}
+		for data := ch.data; len(data) > 0; {
+			n := len(data)
+			if bw := c.proxy.Chaos().Bandwidth; bw > 0 {
+				if limiter == nil || limiter.Limit() != rate.Limit(bw) {
+					// a second worth of burst
+					limiter = rate.NewLimiter(rate.Limit(bw), bw)
+				}
+				if n > bw {
+					n = bw
+				}
+				if err := limiter.WaitN(c.ctx, n); err != nil {
+					break
+				}
+			}
+			if _, err := dst.Write(data[:n]); err != nil {
+				c.close()
+				break
+			}
+			PromTCPBytesCounter.WithLabelValues(direction, "forwarded").Add(float64(n))
+			data = data[n:]
+		}
+	}
+
+	if c.ctx.Err() != nil {
+		return
+	}
+	chaos := c.proxy.Chaos()
+	if chaos.BlackHole || !c.sleep(chaos.SlowClose) {
+		// the close is lost in the black hole too
+		return
+	}
+	if tcp, ok := dst.(*net.TCPConn); ok {
+		tcp.CloseWrite()
+	}
+}
+
+// sleep waits for d, returning false if the connection was closed meanwhile
+func (c *chaosConn) sleep(d time.Duration) bool {
+	if d <= 0 {
+		return c.ctx.Err() == nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-c.ctx.Done():
+		return false
+	}
+}
+
+// reset closes both sides with a TCP RST
+func (c *chaosConn) reset(reason string) {
+	c.once.Do(func() {
+		PromTCPResetsCounter.WithLabelValues(reason).Inc()
+		c.log.Infof("resetting the connection: %s", reason)
+		for _, conn := range []net.Conn{c.down, c.up} {
+			if tcp, ok := conn.(*net.TCPConn); ok {
+				tcp.SetLinger(0)
+			}
+			conn.Close()
+		}
+		c.cancel()
+	})
+}
+
+// close closes both sides
+func (c *chaosConn) close() {
+	c.once.Do(func() {
+		c.down.Close()
+		c.up.Close()
+		c.cancel()
+	})
+}
@@ -0,0 +1,263 @@
+package proxy_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"syscall"
+	"testing"
+	"time"
+
+	pb "github.com/prune998/goHelloGrpcStream/helloworld/helloworld"
+	"github.com/prune998/goHelloGrpcStream/helloworld/proxy"
+	"github.com/prune998/goHelloGrpcStream/helloworld/server"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// startEcho starts a TCP server sending back what it gets, closing once the client closed
+func startEcho(t *testing.T) string {
+	t.Helper()
+	lis, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lis.Close() })
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	return lis.Addr().String()
+}
+
+// startTCP starts a TCP proxy to upstream with chaos, returning it and its address
+func startTCP(t *testing.T, upstream string, chaos proxy.Chaos) (*proxy.TCPProxy, string) {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	p := proxy.NewTCP(upstream, chaos, logger)
+	lis, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.Serve(lis)
+	t.Cleanup(p.Stop)
+	return p, lis.Addr().String()
+}
+
+// dialTCP opens a connection to addr
+func dialTCP(t *testing.T, addr string) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp4", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// echo sends data through conn and reads it back
+func echo(conn net.Conn, data []byte) error {
+	if _, err := conn.Write(data); err != nil {
+		return err
+	}
+	got := make([]byte, len(data))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		return err
+	}
+	if !bytes.Equal(got, data) {
+		return errors.New("got other data back")
+	}
+	return nil
+}
+
+func TestTCPLatency(t *testing.T) {
+	_, addr := startTCP(t, startEcho(t), proxy.Chaos{Latency: 100 * time.Millisecond, Jitter: 10 * time.Millisecond})
+	conn := dialTCP(t, addr)
+
+	start := time.Now()
+	if err := echo(conn, []byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	// delayed on the way there and back
+	if d := time.Since(start); d < 200*time.Millisecond || d > time.Second {
+		t.Errorf("echo took %v", d)
+	}
+}
+
+func TestTCPBandwidth(t *testing.T) {
+	_, addr := startTCP(t, startEcho(t), proxy.Chaos{Bandwidth: 20000})
+	conn := dialTCP(t, addr)
+
+	start := time.Now()
+	if err := echo(conn, make([]byte, 40000)); err != nil {
+		t.Fatal(err)
+	}
+	// the first 20000 bytes are the burst
+	if d := time.Since(start); d < 900*time.Millisecond || d > 2500*time.Millisecond {
+		t.Errorf("echo took %v", d)
+	}
+}
+
+func TestTCPReset(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		chaos proxy.Chaos
+	}{
+		{"bytes", proxy.Chaos{ResetAfterBytes: 10}},
+		{"time", proxy.Chaos{ResetAfter: 100 * time.Millisecond}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, addr := startTCP(t, startEcho(t), test.chaos)
+			conn := dialTCP(t, addr)
+			if err := echo(conn, []byte("ping")); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(200 * time.Millisecond)
+
+			err := echo(conn, []byte("ping pong"))
+			if !errors.Is(err, syscall.ECONNRESET) {
+				t.Errorf("got %v, want a reset", err)
+			}
+		})
+	}
+}
+
+func TestTCPSlowClose(t *testing.T) {
+	_, addr := startTCP(t, startEcho(t), proxy.Chaos{SlowClose: 200 * time.Millisecond})
+	conn := dialTCP(t, addr)
+	if err := echo(conn, []byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	conn.(*net.TCPConn).CloseWrite()
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("got %v, want EOF", err)
+	}
+	// the close is delayed on the way there and back
+	if d := time.Since(start); d < 400*time.Millisecond {
+		t.Errorf("closed after %v", d)
+	}
+}
+
+func TestTCPControl(t *testing.T) {
+	p, addr := startTCP(t, startEcho(t), proxy.Chaos{})
+	control := httptest.NewServer(p.ControlHandler())
+	defer control.Close()
+	conn := dialTCP(t, addr)
+
+	call := func(method, path string) map[string]interface{} {
+		t.Helper()
+		req, _ := http.NewRequest(method, control.URL+path, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s %s: %s", method, path, resp.Status)
+		}
+		got := map[string]interface{}{}
+		if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	got := call(http.MethodPost, proxy.ChaosPath+"?blackhole=true&latency=10ms")
+	if got["blackhole"] != true || got["latency"] != "10ms" {
+		t.Errorf("got chaos %v", got)
+	}
+	// the open connection is black-holed
+	conn.SetDeadline(time.Now().Add(300 * time.Millisecond))
+	var netErr net.Error
+	if err := echo(conn, []byte("lost")); !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("got %v through the black hole, want a timeout", err)
+	}
+
+	got = call(http.MethodDelete, proxy.ChaosPath)
+	if got["blackhole"] != false || got["latency"] != "0s" {
+		t.Errorf("got chaos %v", got)
+	}
+	conn.SetDeadline(time.Now().Add(time.Second))
+	if err := echo(conn, []byte("found")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := call(http.MethodPost, proxy.ResetPath); got["reset"] != 1.0 {
+		t.Errorf("got %v", got)
+	}
+	if err := echo(conn, []byte("ping")); !errors.Is(err, syscall.ECONNRESET) {
+		t.Errorf("got %v, want a reset", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, control.URL+proxy.ChaosPath+"?latency=soon", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("got %s for an invalid latency", resp.Status)
+	}
+}
+
+// TestTCPReconnect checks a gRPC client gets an error when its connection is reset, and reconnects
+func TestTCPReconnect(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	opts := server.DefaultOptions()
+	opts.Logger = logger
+	s, err := server.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	p, addr := startTCP(t, lis.Addr().String(), proxy.Chaos{})
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	c := pb.NewGreeterClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := c.SayHelloStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Send(&pb.HelloRequest{Name: "one"}); err != nil {
+		t.Fatal(err)
+	}
+	if n := p.ResetAll(); n != 1 {
+		t.Errorf("reset %d connections", n)
+	}
+	if _, err := stream.Recv(); err == nil {
+		t.Error("the stream survived the reset")
+	}
+	if _, err := c.SayHello(ctx, &pb.HelloRequest{Name: "again"}, grpc.WaitForReady(true)); err != nil {
+		t.Errorf("no reconnection: %v", err)
+	}
+}
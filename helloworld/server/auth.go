@@ -0,0 +1,212 @@
+package server
+
+import (
+	"crypto/subtle"
+	"crypto/x509"
+	"net/http"
+	"path"
+	"strings"
+
+	grpc_ctxtags "github.com/grpc-ecosystem/go-grpc-middleware/tags"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// APIKeyHeader is the metadata key carrying a static API key
+const APIKeyHeader = "x-api-key"
+
+// AuthOptions configures who may call the server. When no method is configured
+// every call is accepted. Otherwise a call is accepted as soon as one method
+// authenticates it, so API keys, JWTs and client certificates can be mixed.
+type AuthOptions struct {
+	// APIKeys maps each accepted API key to the identity it grants
+	APIKeys map[string]string
+	// JWT verifies the bearer token of the authorization header
+	JWT *JWTVerifier
+	// AllowedSANs are the client certificate SANs accepted over mTLS.
+	// Each entry is a path.Match pattern, like spiffe://cluster.local/ns/*/sa/client
+	AllowedSANs []string
+}
+
+func (a AuthOptions) enabled() bool {
+	return len(a.APIKeys) > 0 || a.JWT != nil || len(a.AllowedSANs) > 0
+}
+
+// identityKey is the context key holding the authenticated identity
+type identityKey struct{}
+
+// Identity returns the identity the caller authenticated with, if any
+func Identity(ctx context.Context) string {
+	id, _ := ctx.Value(identityKey{}).(string)
+	return id
+}
+
+// authenticator checks the credentials of every call, except the health checks
+type authenticator struct {
+	opts AuthOptions
+}
+
+// authenticate returns a context holding the caller identity or an UNAUTHENTICATED error
+func (a *authenticator) authenticate(ctx context.Context, fullMethod string) (context.Context, error) {
+	if !a.opts.enabled() || strings.HasPrefix(fullMethod, "/grpc.health.v1.Health/") {
+		return ctx, nil
+	}
+
+	method, identity, reason := a.check(ctx)
+	if identity == "" {
+		PromAuthCounter.WithLabelValues(fullMethod, method, "", "denied").Inc()
+		return ctx, status.Errorf(codes.Unauthenticated, "authentication failed: %s", reason)
+	}
+
+	PromAuthCounter.WithLabelValues(fullMethod, method, identity, "ok").Inc()
+	grpc_ctxtags.Extract(ctx).Set("auth.method", method).Set("auth.identity", identity)
+	return context.WithValue(ctx, identityKey{}, identity), nil
+}
+
+// check tries every configured method in turn and returns the first one that succeeds,
+// or the reason the last one failed
+func (a *authenticator) check(ctx context.Context) (method, identity, reason string) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	reason = "no credentials"
+
+	if len(a.opts.APIKeys) > 0 {
+		if keys := md.Get(APIKeyHeader); len(keys) > 0 {
+			method = "apikey"
+			reason = "unknown API key"
+			for key, id := range a.opts.APIKeys {
+				if subtle.ConstantTimeCompare([]byte(keys[0]), []byte(key)) == 1 {
+					return method, id, ""
+				}
+			}
+		}
+	}
+
+	if a.opts.JWT != nil {
+		if auth := md.Get("authorization"); len(auth) > 0 {
+			method = "jwt"
+			token := strings.TrimSpace(auth[0])
+			if len(token) > 7 && strings.EqualFold(token[:7], "bearer ") {
+				id, err := a.opts.JWT.Verify(strings.TrimSpace(token[7:]))
+				if err == nil && id != "" {
+					return method, id, ""
+				}
+				reason = "invalid token"
+				if err != nil {
+					reason = err.Error()
+				}
+			} else {
+				reason = "authorization is not a bearer token"
+			}
+		}
+	}
+
+	if len(a.opts.AllowedSANs) > 0 {
+		if p, ok := peer.FromContext(ctx); ok {
+			var sans []string
+			if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(tlsInfo.State.PeerCertificates) > 0 {
+				sans = certSANs(tlsInfo.State.PeerCertificates[0])
+			} else if p.Addr != nil && p.Addr.Network() == loopbackNetwork {
+				sans = md.Get(clientSANsHeader)
+			}
+			if len(sans) > 0 {
+				method = "mtls"
+				if san := a.matchSAN(sans); san != "" {
+					return method, san, ""
+				}
+				reason = "client certificate SAN not allowed"
+			}
+		}
+	}
+
+	return method, "", reason
+}
+
+// certSANs returns the URI, DNS, email and IP SANs of cert
+func certSANs(cert *x509.Certificate) []string {
+	var sans []string
+	for _, u := range cert.URIs {
+		sans = append(sans, u.String())
+	}
+	sans = append(sans, cert.DNSNames...)
+	sans = append(sans, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	return sans
+}
+
+// matchSAN returns the first of sans matching the allow list
+func (a *authenticator) matchSAN(sans []string) string {
+	for _, san := range sans {
+		for _, pattern := range a.opts.AllowedSANs {
+			if ok, _ := path.Match(pattern, san); ok {
+				return san
+			}
+		}
+	}
+	return ""
+}
+
+// clientSANsHeader carries the SANs of the verified client certificate of an HTTP request, from the handlers
+// calling the Greeter over the loopback connection. headerMatcher never takes it from the clients.
+const clientSANsHeader = "x-loopback-client-san"
+
+// clientSANsKey is the context key holding the SANs of the verified client certificate of an HTTP request
+type clientSANsKey struct{}
+
+// withClientSANs keeps the SANs of the verified client certificate of the requests to h,
+// which the handlers forward with clientSANsMetadata so mTLS authenticates them too
+func withClientSANs(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+			r = r.WithContext(context.WithValue(r.Context(), clientSANsKey{}, certSANs(r.TLS.VerifiedChains[0][0])))
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// clientSANsMetadata returns the metadata forwarding the SANs kept by withClientSANs
+func clientSANsMetadata(ctx context.Context) metadata.MD {
+	sans, _ := ctx.Value(clientSANsKey{}).([]string)
+	if len(sans) == 0 {
+		return nil
+	}
+	return metadata.MD{clientSANsHeader: sans}
+}
+
+// UnaryServerInterceptor rejects the unary calls that fail authentication
+func (a *authenticator) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := a.authenticate(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor rejects the streams that fail authentication
+func (a *authenticator) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := a.authenticate(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &identityStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// identityStream carries the authenticated context down to the handler
+type identityStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *identityStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,307 @@
+package server_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prune998/goHelloGrpcStream/helloworld/client"
+	pb "github.com/prune998/goHelloGrpcStream/helloworld/helloworld"
+	"github.com/prune998/goHelloGrpcStream/helloworld/server"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+// signJWT builds a token signed with HS256 when key is a []byte or RS256 when it is an *rsa.PrivateKey
+func signJWT(t *testing.T, key interface{}, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	alg := "HS256"
+	if _, ok := key.(*rsa.PrivateKey); ok {
+		alg = "RS256"
+	}
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		h := sha256.Sum256([]byte(signed))
+		var err error
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, h[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// writeJWKS stores an HMAC secret and an RSA public key in a JWKS file
+func writeJWKS(t *testing.T, secret []byte, rsaKey *rsa.PrivateKey) string {
+	t.Helper()
+	b64 := base64.RawURLEncoding.EncodeToString
+	jwks := map[string]interface{}{"keys": []map[string]string{
+		{"kty": "oct", "kid": "hmac", "k": b64(secret)},
+		{"kty": "RSA", "kid": "rsa", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+	}}
+	b, _ := json.Marshal(jwks)
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(file, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// sayHelloWith starts a server with opts and calls SayHello with the credentials
+// the clients build from their flags
+func sayHelloWith(t *testing.T, opts server.Options, clientOpts client.Options) codes.Code {
+	t.Helper()
+	dialOpts, err := clientOpts.DialOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := startServer(t, opts, dialOpts...)
+	_, err = pb.NewGreeterClient(ts.conn).SayHello(context.Background(), &pb.HelloRequest{Name: "auth"})
+	return status.Code(err)
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	opts := server.DefaultOptions()
+	opts.Auth.APIKeys = map[string]string{"s3cr3t": "alice"}
+	denied := testutil.ToFloat64(server.PromAuthCounter.WithLabelValues("/helloworld.Greeter/SayHello", "apikey", "", "denied"))
+	allowed := testutil.ToFloat64(server.PromAuthCounter.WithLabelValues("/helloworld.Greeter/SayHello", "apikey", "alice", "ok"))
+
+	if code := sayHelloWith(t, opts, client.Options{}); code != codes.Unauthenticated {
+		t.Errorf("without key got %v, want Unauthenticated", code)
+	}
+	if code := sayHelloWith(t, opts, client.Options{APIKey: "wrong"}); code != codes.Unauthenticated {
+		t.Errorf("with wrong key got %v, want Unauthenticated", code)
+	}
+	if code := sayHelloWith(t, opts, client.Options{APIKey: "s3cr3t"}); code != codes.OK {
+		t.Errorf("with key got %v, want OK", code)
+	}
+
+	if got := testutil.ToFloat64(server.PromAuthCounter.WithLabelValues("/helloworld.Greeter/SayHello", "apikey", "", "denied")) - denied; got != 1 {
+		t.Errorf("denied counter increased by %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(server.PromAuthCounter.WithLabelValues("/helloworld.Greeter/SayHello", "apikey", "alice", "ok")) - allowed; got != 1 {
+		t.Errorf("ok counter increased by %v, want 1", got)
+	}
+}
+
+func TestJWTAuth(t *testing.T) {
+	secret := []byte("hmac-secret")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := server.NewJWTVerifier(writeJWKS(t, secret, rsaKey), "issuer", "greeter")
+	if err != nil {
+		t.Fatalf("NewJWTVerifier failed: %v", err)
+	}
+
+	opts := server.DefaultOptions()
+	opts.Auth.JWT = v
+
+	valid := map[string]interface{}{"iss": "issuer", "sub": "bob", "aud": []string{"greeter"}, "exp": time.Now().Add(time.Hour).Unix()}
+	expired := map[string]interface{}{"iss": "issuer", "sub": "bob", "aud": "greeter", "exp": time.Now().Add(-time.Hour).Unix()}
+	wrongAud := map[string]interface{}{"iss": "issuer", "sub": "bob", "aud": "other"}
+
+	for _, tc := range []struct {
+		name  string
+		token string
+		want  codes.Code
+	}{
+		{"hmac", signJWT(t, secret, "hmac", valid), codes.OK},
+		{"rsa", signJWT(t, rsaKey, "rsa", valid), codes.OK},
+		{"expired", signJWT(t, secret, "hmac", expired), codes.Unauthenticated},
+		{"wrong audience", signJWT(t, rsaKey, "rsa", wrongAud), codes.Unauthenticated},
+		{"wrong secret", signJWT(t, []byte("other"), "hmac", valid), codes.Unauthenticated},
+		{"garbage", "not.a.token", codes.Unauthenticated},
+	} {
+		if code := sayHelloWith(t, opts, client.Options{Token: tc.token}); code != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, code, tc.want)
+		}
+	}
+}
+
+// newCert issues a certificate for the given SANs, signed by parent (self-signed when nil)
+func newCert(t *testing.T, parent *tls.Certificate, isCA bool, dnsNames []string, uris ...string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: "test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		DNSNames:              dnsNames,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	for _, u := range uris {
+		parsed, _ := url.Parse(u)
+		tmpl.URIs = append(tmpl.URIs, parsed)
+	}
+
+	signer, signerCert := crypto.Signer(key), tmpl
+	if parent != nil {
+		signer = parent.PrivateKey.(crypto.Signer)
+		signerCert = parent.Leaf
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signerCert, key.Public(), signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// writeCert writes cert and its key as PEM files, for the options taking file names
+func writeCert(t *testing.T, cert tls.Certificate) (certFile, keyFile string) {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestMTLSSANAuth(t *testing.T) {
+	ca := newCert(t, nil, true, nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+
+	opts := server.DefaultOptions()
+	opts.TLSConfig = &tls.Config{
+		Certificates: []tls.Certificate{newCert(t, &ca, false, []string{"bufnet"})},
+		ClientCAs:    pool,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	}
+	opts.Auth.AllowedSANs = []string{"spiffe://cluster.local/ns/*/sa/allowed"}
+
+	for _, tc := range []struct {
+		name string
+		san  string
+		want codes.Code
+	}{
+		{"allowed", "spiffe://cluster.local/ns/default/sa/allowed", codes.OK},
+		{"denied", "spiffe://cluster.local/ns/default/sa/other", codes.Unauthenticated},
+	} {
+		clientCert := newCert(t, &ca, false, nil, tc.san)
+		ts := startServer(t, opts, grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
+			RootCAs:      pool,
+			Certificates: []tls.Certificate{clientCert},
+		})))
+		_, err := pb.NewGreeterClient(ts.conn).SayHello(context.Background(), &pb.HelloRequest{Name: "auth"})
+		if code := status.Code(err); code != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, code, tc.want)
+		}
+	}
+}
+
+func TestMTLSSANAuthHTTP(t *testing.T) {
+	ca := newCert(t, nil, true, nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+
+	opts := server.DefaultOptions()
+	opts.Logger = logrus.New()
+	opts.Logger.SetOutput(io.Discard)
+	opts.TLSConfig = &tls.Config{
+		Certificates: []tls.Certificate{newCert(t, &ca, false, []string{"localhost"})},
+		ClientCAs:    pool,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	}
+	opts.Auth.AllowedSANs = []string{"spiffe://cluster.local/ns/*/sa/allowed"}
+	opts.Listeners = []server.Listener{{Serve: server.ServeBoth, Network: "tcp4", Address: "127.0.0.1:0"}}
+	s, err := server.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Stop)
+
+	for _, tc := range []struct {
+		name string
+		san  string
+		// header is sent by the client, trying to pass for the SANs the handlers forward
+		header string
+		want   int
+	}{
+		{"allowed", "spiffe://cluster.local/ns/default/sa/allowed", "", http.StatusOK},
+		{"denied", "spiffe://cluster.local/ns/default/sa/other", "", http.StatusUnauthorized},
+		{"forged", "", "spiffe://cluster.local/ns/default/sa/allowed", http.StatusUnauthorized},
+	} {
+		cfg := &tls.Config{RootCAs: pool, ServerName: "localhost"}
+		if tc.san != "" {
+			cfg.Certificates = []tls.Certificate{newCert(t, &ca, false, nil, tc.san)}
+		}
+		httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+		req, _ := http.NewRequest(http.MethodPost, "https://"+s.HTTPAddr().String()+"/v1/hello", strings.NewReader(`{"name":"rest"}`))
+		if tc.header != "" {
+			req.Header.Set("Grpc-Metadata-X-Loopback-Client-San", tc.header)
+		}
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.want {
+			t.Errorf("%s: REST got %d, want %d", tc.name, resp.StatusCode, tc.want)
+		}
+	}
+
+	// Connect authenticates with the client certificate too
+	certFile, keyFile := writeCert(t, newCert(t, &ca, false, nil, "spiffe://cluster.local/ns/default/sa/allowed"))
+	c, err := client.Options{Protocol: client.ProtocolConnect, TLS: true, InsecureSkipVerify: true, CertFile: certFile, KeyFile: keyFile}.
+		ConnectGreeterClient(s.HTTPAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.SayHello(context.Background(), &pb.HelloRequest{Name: "connect"}); err != nil {
+		t.Errorf("Connect with an allowed certificate: %v", err)
+	}
+}
@@ -0,0 +1,25 @@
+package server
+
+import (
+	"os"
+
+	pb "github.com/prune998/goHelloGrpcStream/helloworld/helloworld"
+)
+
+// BackendFromEnv identifies the server from the env vars the Kubernetes downward API sets in the deployment:
+// POD_NAME, NODE_NAME, ZONE and VERSION. The pod falls back to the hostname and the version to version.
+func BackendFromEnv(version string) *pb.Backend {
+	backend := &pb.Backend{
+		Pod:     os.Getenv("POD_NAME"),
+		Node:    os.Getenv("NODE_NAME"),
+		Zone:    os.Getenv("ZONE"),
+		Version: os.Getenv("VERSION"),
+	}
+	if backend.Pod == "" {
+		backend.Pod, _ = os.Hostname()
+	}
+	if backend.Version == "" {
+		backend.Version = version
+	}
+	return backend
+}
@@ -0,0 +1,72 @@
+package server_test
+
+import (
+	"os"
+	"testing"
+
+	pb "github.com/prune998/goHelloGrpcStream/helloworld/helloworld"
+	"github.com/prune998/goHelloGrpcStream/helloworld/server"
+	"golang.org/x/net/context"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestBackendFromEnv(t *testing.T) {
+	for _, env := range []string{"POD_NAME", "NODE_NAME", "ZONE", "VERSION"} {
+		t.Setenv(env, "")
+	}
+	hostname, _ := os.Hostname()
+	if got := server.BackendFromEnv("v1"); got.Pod != hostname || got.Node != "" || got.Zone != "" || got.Version != "v1" {
+		t.Errorf("got %v without the downward API", got)
+	}
+
+	t.Setenv("POD_NAME", "greeter-7d9f-x2x")
+	t.Setenv("NODE_NAME", "node-3")
+	t.Setenv("ZONE", "eu-west-1a")
+	t.Setenv("VERSION", "v2")
+	want := &pb.Backend{Pod: "greeter-7d9f-x2x", Node: "node-3", Zone: "eu-west-1a", Version: "v2"}
+	if got := server.BackendFromEnv("v1"); !proto.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestBackendInReplies(t *testing.T) {
+	backend := &pb.Backend{Pod: "greeter-0", Node: "node-1", Zone: "zone-a", Version: "canary"}
+	opts := server.DefaultOptions()
+	opts.Reply = true
+	opts.Backend = backend
+	ts := startServer(t, opts)
+	c := pb.NewGreeterClient(ts.conn)
+
+	r, err := c.SayHello(context.Background(), &pb.HelloRequest{Name: "tester"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(r.Backend, backend) {
+		t.Errorf("unary reply from %v, want %v", r.Backend, backend)
+	}
+
+	stream, err := c.SayHelloStream(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := stream.Send(&pb.HelloRequest{Name: "ping"}); err != nil {
+			t.Fatal(err)
+		}
+		r, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !proto.Equal(r.Backend, backend) {
+			t.Errorf("stream reply %d from %v, want %v", i, r.Backend, backend)
+		}
+	}
+
+	greetings, err := c.SayHelloServerStream(context.Background(), &pb.HelloStreamRequest{Name: "tester", Count: 1, IntervalMs: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, err := greetings.Recv(); err != nil || !proto.Equal(r.Backend, backend) {
+		t.Errorf("server stream reply from %v, %v", r.GetBackend(), err)
+	}
+}
@@ -0,0 +1,52 @@
+package server_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prune998/goHelloGrpcStream/helloworld/compression"
+	pb "github.com/prune998/goHelloGrpcStream/helloworld/helloworld"
+	"github.com/prune998/goHelloGrpcStream/helloworld/server"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+func TestCompression(t *testing.T) {
+	opts := server.DefaultOptions()
+	opts.Reply = true
+	ts := startServer(t, opts)
+	c := pb.NewGreeterClient(ts.conn)
+	name := strings.Repeat("compress me ", 100)
+
+	for _, compressor := range compression.Names {
+		raw := testutil.ToFloat64(server.PromPayloadBytesCounter.WithLabelValues("in", compressor))
+		wire := testutil.ToFloat64(server.PromPayloadWireBytesCounter.WithLabelValues("in", compressor))
+
+		r, err := c.SayHello(context.Background(), &pb.HelloRequest{Name: name}, grpc.UseCompressor(compressor))
+		if err != nil {
+			t.Fatalf("%s: SayHello failed: %v", compressor, err)
+		}
+		if !strings.Contains(r.Message, name) {
+			t.Errorf("%s: unexpected reply %q", compressor, r.Message)
+		}
+
+		stream, err := c.SayHelloStream(context.Background(), grpc.UseCompressor(compressor))
+		if err != nil {
+			t.Fatalf("%s: SayHelloStream failed: %v", compressor, err)
+		}
+		if err := stream.Send(&pb.HelloRequest{Name: name}); err != nil {
+			t.Fatalf("%s: Send failed: %v", compressor, err)
+		}
+		if r, err := stream.Recv(); err != nil || r.Message != "Pong "+name {
+			t.Fatalf("%s: Recv got (%v, %v)", compressor, r, err)
+		}
+		stream.CloseSend()
+
+		gotRaw := testutil.ToFloat64(server.PromPayloadBytesCounter.WithLabelValues("in", compressor)) - raw
+		gotWire := testutil.ToFloat64(server.PromPayloadWireBytesCounter.WithLabelValues("in", compressor)) - wire
+		if gotRaw == 0 || gotWire == 0 || gotWire >= gotRaw {
+			t.Errorf("%s: received %v bytes with %v on the wire, want them compressed", compressor, gotRaw, gotWire)
+		}
+	}
+}
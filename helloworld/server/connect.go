@@ -0,0 +1,140 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"connectrpc.com/connect"
+	pb "github.com/prune998/goHelloGrpcStream/helloworld/helloworld"
+	"github.com/prune998/goHelloGrpcStream/helloworld/helloworld/helloworldconnect"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// connectGreeter serves the Greeter over gRPC-Web and Connect, as well as gRPC, on the HTTP port.
+// It calls the gRPC server through the loopback connection.
+type connectGreeter struct {
+	client pb.GreeterClient
+}
+
+// newConnectHandler returns the path prefix of the Greeter and its handler
+func newConnectHandler(conn *grpc.ClientConn) (string, http.Handler) {
+	return helloworldconnect.NewGreeterHandler(connectGreeter{client: pb.NewGreeterClient(conn)})
+}
+
+func (c connectGreeter) SayHello(ctx context.Context, req *connect.Request[pb.HelloRequest]) (*connect.Response[pb.HelloReply], error) {
+	var header, trailer metadata.MD
+	reply, err := c.client.SayHello(outgoingContext(ctx, req.Header(), req.Peer().Addr), req.Msg, grpc.Header(&header), grpc.Trailer(&trailer))
+	if err != nil {
+		return nil, connectError(err, trailer)
+	}
+	resp := connect.NewResponse(reply)
+	copyMetadata(resp.Header(), header)
+	copyMetadata(resp.Trailer(), trailer)
+	return resp, nil
+}
+
+// SayHelloStream needs HTTP/2, as neither gRPC-Web nor Connect can stream both ways over HTTP/1.1
+func (c connectGreeter) SayHelloStream(ctx context.Context, stream *connect.BidiStream[pb.HelloRequest, pb.HelloReply]) error {
+	ctx, cancel := context.WithCancel(outgoingContext(ctx, stream.RequestHeader(), stream.Peer().Addr))
+	defer cancel()
+	upstream, err := c.client.SayHelloStream(ctx)
+	if err != nil {
+		return connectError(err, nil)
+	}
+
+	go func() {
+		for {
+			msg, err := stream.Receive()
+			if err != nil {
+				upstream.CloseSend()
+				return
+			}
+			if err := upstream.Send(msg); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		reply, err := upstream.Recv()
+		if err == io.EOF {
+			copyMetadata(stream.ResponseTrailer(), upstream.Trailer())
+			return nil
+		}
+		if err != nil {
+			return connectError(err, upstream.Trailer())
+		}
+		if err := stream.Send(reply); err != nil {
+			return err
+		}
+	}
+}
+
+func (c connectGreeter) SayHelloServerStream(ctx context.Context, req *connect.Request[pb.HelloStreamRequest], stream *connect.ServerStream[pb.HelloReply]) error {
+	upstream, err := c.client.SayHelloServerStream(outgoingContext(ctx, req.Header(), req.Peer().Addr), req.Msg)
+	if err != nil {
+		return connectError(err, nil)
+	}
+	for {
+		reply, err := upstream.Recv()
+		if err == io.EOF {
+			copyMetadata(stream.ResponseTrailer(), upstream.Trailer())
+			return nil
+		}
+		if err != nil {
+			return connectError(err, upstream.Trailer())
+		}
+		if err := stream.Send(reply); err != nil {
+			return err
+		}
+	}
+}
+
+// outgoingContext passes the request headers and the client address to the gRPC server, like the REST gateway does
+func outgoingContext(ctx context.Context, header http.Header, remoteAddr string) context.Context {
+	md := metadata.MD{}
+	for key, values := range header {
+		if strings.EqualFold(key, "authorization") {
+			md.Append("authorization", values...)
+			continue
+		}
+		if name, ok := headerMatcher(key); ok {
+			md.Append(name, values...)
+		}
+	}
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		md.Append(ForwardedForHeader, host)
+	}
+	return metadata.NewOutgoingContext(ctx, metadata.Join(md, clientSANsMetadata(ctx)))
+}
+
+// connectError converts a gRPC status, with its details and trailers, to a Connect error
+func connectError(err error, trailer metadata.MD) error {
+	st := status.Convert(err)
+	cerr := connect.NewError(connect.Code(st.Code()), errors.New(st.Message()))
+	for _, detail := range st.Proto().GetDetails() {
+		if d, err := connect.NewErrorDetail(detail); err == nil {
+			cerr.AddDetail(d)
+		}
+	}
+	copyMetadata(cerr.Meta(), trailer)
+	return cerr
+}
+
+// copyMetadata adds the gRPC metadata to the HTTP headers, leaving out the reserved ones
+func copyMetadata(dst http.Header, md metadata.MD) {
+	for key, values := range md {
+		if strings.HasPrefix(key, "grpc-") && key != "grpc-retry-pushback-ms" || key == "content-type" {
+			continue
+		}
+		for _, v := range values {
+			dst.Add(key, v)
+		}
+	}
+}
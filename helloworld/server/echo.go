@@ -0,0 +1,60 @@
+package server
+
+import (
+	"crypto/tls"
+	"sort"
+
+	pb "github.com/prune998/goHelloGrpcStream/helloworld/helloworld"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// redactedHeaders are the metadata carrying credentials, never echoed
+var redactedHeaders = map[string]bool{
+	"authorization": true,
+	APIKeyHeader:    true,
+	"cookie":        true,
+}
+
+// Echo returns what the server saw of the call in ctx: its metadata, peer and security
+func Echo(ctx context.Context) *pb.Echo {
+	echo := &pb.Echo{Peer: PeerAddr(ctx), Identity: Identity(ctx), AuthType: "insecure"}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	for name, values := range md {
+		if redactedHeaders[name] {
+			values = []string{"[redacted]"}
+		}
+		echo.Metadata = append(echo.Metadata, &pb.Header{Name: name, Values: values})
+	}
+	sort.Slice(echo.Metadata, func(i, j int) bool { return echo.Metadata[i].Name < echo.Metadata[j].Name })
+
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return echo
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return echo
+	}
+	state := tlsInfo.State
+	echo.AuthType = tlsInfo.AuthType()
+	echo.TlsVersion = tls.VersionName(state.Version)
+	echo.CipherSuite = tls.CipherSuiteName(state.CipherSuite)
+	echo.ServerName = state.ServerName
+	if len(state.PeerCertificates) > 0 {
+		echo.ClientSubject = state.PeerCertificates[0].Subject.String()
+		echo.ClientSans = certSANs(state.PeerCertificates[0])
+	}
+	return echo
+}
+
+// echo returns Echo(ctx) when the server runs with Options.Echo
+func (s *Server) echo(ctx context.Context) *pb.Echo {
+	if !s.opts.Echo {
+		return nil
+	}
+	return Echo(ctx)
+}
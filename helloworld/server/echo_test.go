@@ -0,0 +1,113 @@
+package server_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"testing"
+
+	pb "github.com/prune998/goHelloGrpcStream/helloworld/helloworld"
+	"github.com/prune998/goHelloGrpcStream/helloworld/server"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+)
+
+// echoed returns the values of the name metadata in echo
+func echoed(echo *pb.Echo, name string) []string {
+	for _, h := range echo.Metadata {
+		if h.Name == name {
+			return h.Values
+		}
+	}
+	return nil
+}
+
+func TestEcho(t *testing.T) {
+	opts := server.DefaultOptions()
+	opts.Echo = true
+	opts.Reply = true
+	ts := startServer(t, opts)
+	c := pb.NewGreeterClient(ts.conn)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-request-id", "42", server.APIKeyHeader, "secret")
+
+	r, err := c.SayHello(ctx, &pb.HelloRequest{Name: "tester"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Echo == nil {
+		t.Fatal("no echo in the reply")
+	}
+	if got := echoed(r.Echo, ":authority"); len(got) != 1 || got[0] != "bufnet" {
+		t.Errorf("got authority %v", got)
+	}
+	if got := echoed(r.Echo, "x-request-id"); len(got) != 1 || got[0] != "42" {
+		t.Errorf("got x-request-id %v", got)
+	}
+	if got := echoed(r.Echo, server.APIKeyHeader); len(got) != 1 || got[0] != "[redacted]" {
+		t.Errorf("got the API key %v", got)
+	}
+	if r.Echo.Peer == "" || r.Echo.AuthType != "insecure" || r.Echo.TlsVersion != "" {
+		t.Errorf("got peer %q, auth %q, tls %q", r.Echo.Peer, r.Echo.AuthType, r.Echo.TlsVersion)
+	}
+
+	// only the first reply of a stream has it
+	stream, err := c.SayHelloStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []bool{true, false} {
+		if err := stream.Send(&pb.HelloRequest{Name: "ping"}); err != nil {
+			t.Fatal(err)
+		}
+		r, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := r.Echo != nil; got != want {
+			t.Errorf("reply %d has echo %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestEchoTLS(t *testing.T) {
+	ca := newCert(t, nil, true, nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+
+	opts := server.DefaultOptions()
+	opts.Echo = true
+	opts.TLSConfig = &tls.Config{
+		Certificates: []tls.Certificate{newCert(t, &ca, false, []string{"bufnet"})},
+		ClientCAs:    pool,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	}
+	ts := startServer(t, opts, grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
+		RootCAs:      pool,
+		Certificates: []tls.Certificate{newCert(t, &ca, false, nil, "spiffe://cluster.local/ns/default/sa/client")},
+		MinVersion:   tls.VersionTLS13,
+	})))
+
+	r, err := pb.NewGreeterClient(ts.conn).SayHello(context.Background(), &pb.HelloRequest{Name: "tester"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	echo := r.Echo
+	if echo.AuthType != "tls" || echo.TlsVersion != "TLS 1.3" || echo.ServerName != "bufnet" || echo.CipherSuite == "" {
+		t.Errorf("got auth %q, version %q, sni %q, cipher %q", echo.AuthType, echo.TlsVersion, echo.ServerName, echo.CipherSuite)
+	}
+	if echo.ClientSubject != "CN=test" || len(echo.ClientSans) != 1 || echo.ClientSans[0] != "spiffe://cluster.local/ns/default/sa/client" {
+		t.Errorf("got client certificate %q, SANs %v", echo.ClientSubject, echo.ClientSans)
+	}
+}
+
+func TestNoEcho(t *testing.T) {
+	ts := startServer(t, server.DefaultOptions())
+	r, err := pb.NewGreeterClient(ts.conn).SayHello(context.Background(), &pb.HelloRequest{Name: "tester"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Echo != nil {
+		t.Errorf("got echo %v without -echo", r.Echo)
+	}
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"math/rand"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Faults configures the errors and delays injected in unary calls,
+// to compare client side retries with the mesh ones
+type Faults struct {
+	// ErrorRate is the fraction of calls, between 0 and 1, failing with ErrorCode
+	ErrorRate float64
+	// ErrorCode is the status returned by the failed calls, UNAVAILABLE when not set
+	ErrorCode codes.Code
+	// Delay is added before handling every call
+	Delay time.Duration
+}
+
+// UnaryServerInterceptor delays and fails the unary calls as configured
+func (f Faults) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if f.Delay > 0 {
+			select {
+			case <-time.After(f.Delay):
+			case <-ctx.Done():
+				return nil, status.FromContextError(ctx.Err()).Err()
+			}
+		}
+		if f.ErrorRate > 0 && rand.Float64() < f.ErrorRate {
+			code := f.ErrorCode
+			if code == codes.OK {
+				code = codes.Unavailable
+			}
+			PromFaultCounter.WithLabelValues(info.FullMethod, code.String()).Inc()
+			return nil, status.Errorf(code, "injected fault")
+		}
+		return handler(ctx, req)
+	}
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"net/http"
+	"net/textproto"
+	"strings"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	pb "github.com/prune998/goHelloGrpcStream/helloworld/helloworld"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// forwardedHeaders are the HTTP headers forwarded as gRPC metadata by the REST gateway
+// and the gRPC-Web and Connect handlers, on top of the Grpc-Metadata-* and standard ones
+var forwardedHeaders = map[string]bool{
+	APIKeyHeader:     true,
+	ClientNameHeader: true,
+}
+
+// gateway translates the REST/JSON calls into gRPC calls to the server
+type gateway struct {
+	mux *runtime.ServeMux
+}
+
+func newGateway(conn *grpc.ClientConn) (*gateway, error) {
+	g := &gateway{
+		mux: runtime.NewServeMux(
+			runtime.WithIncomingHeaderMatcher(headerMatcher),
+			runtime.WithMetadata(func(ctx context.Context, _ *http.Request) metadata.MD { return clientSANsMetadata(ctx) }),
+		),
+	}
+	if err := pb.RegisterGreeterHandler(context.Background(), g.mux, conn); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// ServeHTTP lets the stream endpoint read the NDJSON request body while writing the replies
+func (g *gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, "/stream") {
+		http.NewResponseController(w).EnableFullDuplex()
+	}
+	g.mux.ServeHTTP(w, r)
+}
+
+// headerMatcher returns the metadata key of the HTTP headers passed to the gRPC server
+func headerMatcher(key string) (string, bool) {
+	if forwardedHeaders[strings.ToLower(key)] {
+		return strings.ToLower(key), true
+	}
+	name, ok := runtime.DefaultHeaderMatcher(textproto.CanonicalMIMEHeaderKey(key))
+	if strings.EqualFold(name, clientSANsHeader) {
+		return "", false
+	}
+	return name, ok
+}
+
+// serveOpenAPI serves the OpenAPI document of the REST gateway
+func serveOpenAPI(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(pb.OpenAPI)
+}
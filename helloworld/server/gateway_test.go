@@ -0,0 +1,156 @@
+package server_test
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prune998/goHelloGrpcStream/helloworld/server"
+)
+
+func TestGatewaySayHello(t *testing.T) {
+	opts := server.DefaultOptions()
+	opts.GRPCPort = "1234"
+	ts := startServer(t, opts)
+	web := httptest.NewServer(ts.HTTPHandler())
+	defer web.Close()
+
+	resp, err := http.Post(web.URL+"/v1/hello", "application/json", strings.NewReader(`{"name":"rest"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %v", resp.Status)
+	}
+	var reply struct{ Message string }
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Message != "Hello rest 1234" {
+		t.Errorf("got message %q", reply.Message)
+	}
+}
+
+func TestGatewayRequiredClientCert(t *testing.T) {
+	// the gateway has no client certificate, the loopback connection is in clear text
+	opts := server.DefaultOptions()
+	opts.TLSConfig = &tls.Config{
+		Certificates: []tls.Certificate{newCert(t, nil, false, []string{"localhost"})},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	ts := startServer(t, opts)
+	web := httptest.NewServer(ts.HTTPHandler())
+	defer web.Close()
+
+	resp, err := http.Post(web.URL+"/v1/hello", "application/json", strings.NewReader(`{"name":"rest"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("got status %v", resp.Status)
+	}
+}
+
+func TestGatewayAuth(t *testing.T) {
+	opts := server.DefaultOptions()
+	opts.Auth.APIKeys = map[string]string{"secret": "rest-client"}
+	ts := startServer(t, opts)
+	web := httptest.NewServer(ts.HTTPHandler())
+	defer web.Close()
+
+	for key, want := range map[string]int{"": http.StatusUnauthorized, "secret": http.StatusOK} {
+		req, _ := http.NewRequest(http.MethodPost, web.URL+"/v1/hello", strings.NewReader(`{"name":"rest"}`))
+		if key != "" {
+			req.Header.Set(server.APIKeyHeader, key)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("api key %q: got status %v, want %v", key, resp.StatusCode, want)
+		}
+	}
+}
+
+func TestGatewayStream(t *testing.T) {
+	opts := server.DefaultOptions()
+	opts.Reply = true
+	ts := startServer(t, opts)
+	web := httptest.NewServer(ts.HTTPHandler())
+	defer web.Close()
+
+	body, w := io.Pipe()
+	req, _ := http.NewRequest(http.MethodPost, web.URL+"/v1/hello/stream", body)
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	respc := make(chan *http.Response)
+	errc := make(chan error, 1)
+	go func() {
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			errc <- err
+			return
+		}
+		respc <- resp
+	}()
+
+	// the replies must come while the request is still being sent
+	fmt.Fprintln(w, `{"name":"one"}`)
+	var resp *http.Response
+	select {
+	case resp = <-respc:
+	case err := <-errc:
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	lines := bufio.NewScanner(resp.Body)
+
+	for _, name := range []string{"one", "two"} {
+		if name != "one" {
+			fmt.Fprintf(w, `{"name":%q}`+"\n", name)
+		}
+		if !lines.Scan() {
+			t.Fatalf("no reply for %s: %v", name, lines.Err())
+		}
+		var msg struct {
+			Result struct{ Message string }
+		}
+		if err := json.Unmarshal(lines.Bytes(), &msg); err != nil {
+			t.Fatalf("bad reply %q: %v", lines.Text(), err)
+		}
+		if msg.Result.Message != "Pong "+name {
+			t.Errorf("got message %q, want %q", msg.Result.Message, "Pong "+name)
+		}
+	}
+	w.Close()
+	if lines.Scan() {
+		t.Errorf("unexpected reply after close: %q", lines.Text())
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	ts := startServer(t, server.DefaultOptions())
+	rec := httptest.NewRecorder()
+	ts.HTTPHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+
+	var doc struct {
+		Paths map[string]interface{}
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/v1/hello", "/v1/hello/stream"} {
+		if _, ok := doc.Paths[path]; !ok {
+			t.Errorf("%s is missing from the OpenAPI document", path)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package server
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+)
+
+// jwtLeeway is the clock skew allowed when checking exp and nbf
+const jwtLeeway = 30 * time.Second
+
+// jwk is a single key of a JWKS document. Only RSA and symmetric (oct) keys are supported.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	K   string `json:"k"`
+
+	rsa  *rsa.PublicKey
+	hmac []byte
+}
+
+// JWTVerifier checks HMAC or RSA signed JWTs against the keys of a local JWKS file
+type JWTVerifier struct {
+	keys     []*jwk
+	issuer   string
+	audience string
+}
+
+// NewJWTVerifier loads the keys of the JWKS file. When issuer or audience are set,
+// tokens must carry the matching iss and aud claims.
+func NewJWTVerifier(jwksFile, issuer, audience string) (*JWTVerifier, error) {
+	b, err := os.ReadFile(jwksFile)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []*jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS %s: %w", jwksFile, err)
+	}
+
+	v := &JWTVerifier{issuer: issuer, audience: audience}
+	for _, k := range set.Keys {
+		switch k.Kty {
+		case "RSA":
+			n, err := base64.RawURLEncoding.DecodeString(k.N)
+			if err != nil {
+				return nil, fmt.Errorf("invalid modulus for key %q: %w", k.Kid, err)
+			}
+			e, err := base64.RawURLEncoding.DecodeString(k.E)
+			if err != nil {
+				return nil, fmt.Errorf("invalid exponent for key %q: %w", k.Kid, err)
+			}
+			k.rsa = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "oct":
+			k.hmac, err = base64.RawURLEncoding.DecodeString(k.K)
+			if err != nil {
+				return nil, fmt.Errorf("invalid secret for key %q: %w", k.Kid, err)
+			}
+		default:
+			continue
+		}
+		v.keys = append(v.keys, k)
+	}
+	if len(v.keys) == 0 {
+		return nil, fmt.Errorf("no RSA or oct key found in %s", jwksFile)
+	}
+	return v, nil
+}
+
+// jwtClaims are the registered claims we check
+type jwtClaims struct {
+	Issuer    string          `json:"iss"`
+	Subject   string          `json:"sub"`
+	Audience  json.RawMessage `json:"aud"`
+	ExpiresAt *float64        `json:"exp"`
+	NotBefore *float64        `json:"nbf"`
+}
+
+// hasAudience handles aud being either a string or an array of strings
+func (c *jwtClaims) hasAudience(aud string) bool {
+	var one string
+	if json.Unmarshal(c.Audience, &one) == nil {
+		return one == aud
+	}
+	var many []string
+	if json.Unmarshal(c.Audience, &many) == nil {
+		for _, a := range many {
+			if a == aud {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Verify checks the signature and claims of token and returns its subject,
+// or its issuer when there is no subject
+func (v *JWTVerifier) Verify(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", errors.New("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return "", fmt.Errorf("invalid header: %w", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("invalid signature encoding: %w", err)
+	}
+	if err := v.verifySignature(header.Alg, header.Kid, parts[0]+"."+parts[1], sig); err != nil {
+		return "", err
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return "", fmt.Errorf("invalid claims: %w", err)
+	}
+	now := time.Now()
+	if claims.ExpiresAt != nil && now.After(time.Unix(int64(*claims.ExpiresAt), 0).Add(jwtLeeway)) {
+		return "", errors.New("token is expired")
+	}
+	if claims.NotBefore != nil && now.Add(jwtLeeway).Before(time.Unix(int64(*claims.NotBefore), 0)) {
+		return "", errors.New("token is not valid yet")
+	}
+	if v.issuer != "" && claims.Issuer != v.issuer {
+		return "", fmt.Errorf("unexpected issuer %q", claims.Issuer)
+	}
+	if v.audience != "" && !claims.hasAudience(v.audience) {
+		return "", errors.New("audience does not match")
+	}
+
+	if claims.Subject != "" {
+		return claims.Subject, nil
+	}
+	return claims.Issuer, nil
+}
+
+// verifySignature tries every key matching kid and alg
+func (v *JWTVerifier) verifySignature(alg, kid, signed string, sig []byte) error {
+	var hashAlg crypto.Hash
+	switch alg {
+	case "HS256", "RS256":
+		hashAlg = crypto.SHA256
+	case "HS384", "RS384":
+		hashAlg = crypto.SHA384
+	case "HS512", "RS512":
+		hashAlg = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+
+	for _, k := range v.keys {
+		if kid != "" && k.Kid != "" && k.Kid != kid {
+			continue
+		}
+		if k.Alg != "" && k.Alg != alg {
+			continue
+		}
+		switch {
+		case alg[0] == 'H' && k.hmac != nil:
+			mac := hmac.New(hashFunc(hashAlg), k.hmac)
+			mac.Write([]byte(signed))
+			if hmac.Equal(mac.Sum(nil), sig) {
+				return nil
+			}
+		case alg[0] == 'R' && k.rsa != nil:
+			h := hashAlg.New()
+			h.Write([]byte(signed))
+			if rsa.VerifyPKCS1v15(k.rsa, hashAlg, h.Sum(nil), sig) == nil {
+				return nil
+			}
+		}
+	}
+	return errors.New("invalid signature")
+}
+
+func hashFunc(h crypto.Hash) func() hash.Hash {
+	switch h {
+	case crypto.SHA384:
+		return sha512.New384
+	case crypto.SHA512:
+		return sha512.New
+	default:
+		return sha256.New
+	}
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
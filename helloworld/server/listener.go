@@ -0,0 +1,232 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// What a Listener serves
+const (
+	ServeGRPC = "grpc"
+	ServeHTTP = "http"
+	// ServeBoth serves gRPC and HTTP on the same listener, see ServeSinglePort
+	ServeBoth = "single"
+)
+
+// Listener is an address the server binds, along with what it serves there
+type Listener struct {
+	// Serve is ServeGRPC, ServeHTTP or ServeBoth
+	Serve string
+	// Network is tcp, tcp4, tcp6 or unix
+	Network string
+	// Address is [host]:port, or the socket path for unix
+	Address string
+	// Mode sets the permissions of a unix socket, like 0660. The umask applies when 0.
+	Mode os.FileMode
+	// ReusePort sets SO_REUSEPORT, so several servers can bind the same address
+	ReusePort bool
+	// TLSConfig enables TLS on this listener. gRPC and single port listeners use Options.TLSConfig when nil.
+	TLSConfig *tls.Config
+	// Proxy configures the PROXY protocol on this listener
+	Proxy ProxyProtocol
+}
+
+func (l Listener) String() string {
+	return fmt.Sprintf("%s=%s://%s", l.Serve, l.Network, l.Address)
+}
+
+// ParseListener parses a listener spec, serve=network://address?options, like:
+//
+//	grpc=tcp://:7788
+//	http=tcp4://127.0.0.1:7789
+//	grpc=tcp6://[::1]:7788?reuseport=true
+//	single=unix:///var/run/greeter.sock?mode=0660
+//	grpc=tcp://:7443?cert=server.pem&key=server.key&clientca=ca.pem
+//	http=tcp://:7789?proxy=true&trustedproxies=10.0.0.0/8,192.168.1.10
+func ParseListener(spec string) (Listener, error) {
+	var l Listener
+	serve, rawURL, ok := strings.Cut(spec, "=")
+	if !ok {
+		return l, fmt.Errorf("listener %q is not serve=network://address", spec)
+	}
+	switch serve {
+	case ServeGRPC, ServeHTTP, ServeBoth:
+		l.Serve = serve
+	default:
+		return l, fmt.Errorf("listener %q: serve %s, %s or %s", spec, ServeGRPC, ServeHTTP, ServeBoth)
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return l, fmt.Errorf("listener %q: %w", spec, err)
+	}
+	l.Network = u.Scheme
+	switch l.Network {
+	case "tcp", "tcp4", "tcp6":
+		l.Address = u.Host
+	case "unix":
+		l.Address = u.Host + u.Path
+	default:
+		return l, fmt.Errorf("listener %q: network must be tcp, tcp4, tcp6 or unix", spec)
+	}
+	if l.Address == "" {
+		return l, fmt.Errorf("listener %q has no address", spec)
+	}
+
+	q := u.Query()
+	if mode := q.Get("mode"); mode != "" {
+		m, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil {
+			return l, fmt.Errorf("listener %q: invalid mode: %w", spec, err)
+		}
+		l.Mode = os.FileMode(m)
+	}
+	if reuse := q.Get("reuseport"); reuse != "" {
+		if l.ReusePort, err = strconv.ParseBool(reuse); err != nil {
+			return l, fmt.Errorf("listener %q: invalid reuseport: %w", spec, err)
+		}
+	}
+	if proxy := q.Get("proxy"); proxy != "" {
+		if l.Proxy.Enabled, err = strconv.ParseBool(proxy); err != nil {
+			return l, fmt.Errorf("listener %q: invalid proxy: %w", spec, err)
+		}
+	}
+	if l.Proxy.Trusted, err = ParseTrustedProxies(q.Get("trustedproxies")); err != nil {
+		return l, fmt.Errorf("listener %q: %w", spec, err)
+	}
+	if l.TLSConfig, err = LoadTLSConfig(q.Get("cert"), q.Get("key"), q.Get("clientca")); err != nil {
+		return l, fmt.Errorf("listener %q: %w", spec, err)
+	}
+	return l, nil
+}
+
+// Listen binds the listener address
+func (l Listener) Listen() (net.Listener, error) {
+	lc := net.ListenConfig{}
+	if l.ReusePort {
+		lc.Control = reusePort
+	}
+
+	if l.Network == "unix" {
+		// remove the socket left behind by a previous run
+		if fi, err := os.Stat(l.Address); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(l.Address)
+		}
+	}
+	lis, err := lc.Listen(context.Background(), l.Network, l.Address)
+	if err != nil {
+		return nil, err
+	}
+	if l.Network == "unix" && l.Mode != 0 {
+		if err := os.Chmod(l.Address, l.Mode); err != nil {
+			lis.Close()
+			return nil, err
+		}
+	}
+	return lis, nil
+}
+
+// LoadTLSConfig loads the server certificate, and the client CA when mTLS is wanted.
+// Client certificates are only verified if given so API keys and JWTs can still be used.
+// It returns nil when no file is given.
+func LoadTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	if certFile == "" && keyFile == "" {
+		if clientCAFile != "" {
+			return nil, errors.New("a client CA needs a certificate and a key")
+		}
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	if clientCAFile != "" {
+		pem, err := os.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = x509.NewCertPool()
+		if !cfg.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", clientCAFile)
+		}
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return cfg, nil
+}
+
+// tlsListener tags the connections it accepts with the TLS settings of the listener
+type tlsListener struct {
+	net.Listener
+	config *tls.Config
+}
+
+func (l *tlsListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &listenerConn{Conn: conn, config: l.config}, nil
+}
+
+type listenerConn struct {
+	net.Conn
+	config *tls.Config
+}
+
+// listenerCredentials does the TLS handshake with the settings of the listener a connection
+// comes from, or with the server settings. Connections are in clear text when there is none,
+// and over the loopback.
+type listenerCredentials struct {
+	config *tls.Config
+}
+
+func (c listenerCredentials) credentials(conn net.Conn) credentials.TransportCredentials {
+	config := c.config
+	switch lc := conn.(type) {
+	case *listenerConn:
+		config = lc.config
+	case loopbackConn:
+		config = nil
+	}
+	if config == nil {
+		return insecure.NewCredentials()
+	}
+	return credentials.NewTLS(config)
+}
+
+func (c listenerCredentials) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return c.credentials(conn).ServerHandshake(conn)
+}
+
+func (c listenerCredentials) ClientHandshake(context.Context, string, net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return nil, nil, errors.New("listenerCredentials are for servers only")
+}
+
+func (c listenerCredentials) Info() credentials.ProtocolInfo {
+	if c.config == nil {
+		return insecure.NewCredentials().Info()
+	}
+	return credentials.NewTLS(c.config).Info()
+}
+
+func (c listenerCredentials) Clone() credentials.TransportCredentials {
+	return c
+}
+
+func (c listenerCredentials) OverrideServerName(string) error {
+	return nil
+}
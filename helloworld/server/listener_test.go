@@ -0,0 +1,171 @@
+package server_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prune998/goHelloGrpcStream/helloworld/client"
+	pb "github.com/prune998/goHelloGrpcStream/helloworld/helloworld"
+	"github.com/prune998/goHelloGrpcStream/helloworld/server"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestParseListener(t *testing.T) {
+	for spec, want := range map[string]server.Listener{
+		"grpc=tcp://:7788":           {Serve: server.ServeGRPC, Network: "tcp", Address: ":7788"},
+		"http=tcp4://127.0.0.1:7789": {Serve: server.ServeHTTP, Network: "tcp4", Address: "127.0.0.1:7789"},
+		"grpc=tcp6://[::1]:7788?reuseport=true": {
+			Serve: server.ServeGRPC, Network: "tcp6", Address: "[::1]:7788", ReusePort: true,
+		},
+		"single=unix:///run/greeter.sock?mode=0660": {
+			Serve: server.ServeBoth, Network: "unix", Address: "/run/greeter.sock", Mode: 0660,
+		},
+		"http=tcp://:7789?proxy=true&trustedproxies=10.0.0.0/8,192.168.1.10": {
+			Serve: server.ServeHTTP, Network: "tcp", Address: ":7789", Proxy: server.ProxyProtocol{
+				Enabled: true,
+				Trusted: []*net.IPNet{
+					{IP: net.IPv4(10, 0, 0, 0).To4(), Mask: net.CIDRMask(8, 32)},
+					{IP: net.IPv4(192, 168, 1, 10).To4(), Mask: net.CIDRMask(32, 32)},
+				},
+			},
+		},
+	} {
+		l, err := server.ParseListener(spec)
+		if err != nil {
+			t.Errorf("%s: %v", spec, err)
+			continue
+		}
+		if !reflect.DeepEqual(l, want) {
+			t.Errorf("%s: got %+v, want %+v", spec, l, want)
+		}
+	}
+
+	for _, spec := range []string{
+		"tcp://:7788",
+		"ftp=tcp://:7788",
+		"grpc=udp://:7788",
+		"grpc=unix://",
+		"grpc=unix:///tmp/s?mode=999",
+		"grpc=tcp://:7788?reuseport=maybe",
+		"grpc=tcp://:7788?clientca=ca.pem",
+		"grpc=tcp://:7788?proxy=yes",
+		"grpc=tcp://:7788?trustedproxies=10.0.0.0/33",
+	} {
+		if _, err := server.ParseListener(spec); err == nil {
+			t.Errorf("%s: expected an error", spec)
+		}
+	}
+}
+
+func TestListeners(t *testing.T) {
+	dir := t.TempDir()
+	grpcSocket := filepath.Join(dir, "grpc.sock")
+	httpSocket := filepath.Join(dir, "http.sock")
+	cert := newCert(t, nil, false, []string{"localhost"})
+
+	opts := server.DefaultOptions()
+	opts.Logger = logrus.New()
+	opts.Logger.SetOutput(io.Discard)
+	opts.Listeners = []server.Listener{
+		{Serve: server.ServeGRPC, Network: "tcp4", Address: "127.0.0.1:0", TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}}},
+		{Serve: server.ServeGRPC, Network: "unix", Address: grpcSocket, Mode: 0600},
+		{Serve: server.ServeHTTP, Network: "unix", Address: httpSocket},
+	}
+	s, err := server.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	fi, err := os.Stat(grpcSocket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("socket mode is %v", fi.Mode().Perm())
+	}
+
+	sayHello := func(target string, creds credentials.TransportCredentials) error {
+		conn, err := grpc.Dial(target, grpc.WithTransportCredentials(creds))
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		_, err = pb.NewGreeterClient(conn).SayHello(context.Background(), &pb.HelloRequest{Name: "listener"})
+		return err
+	}
+
+	// the TCP listener has its own TLS settings while the unix socket is in clear text
+	roots := x509.NewCertPool()
+	roots.AddCert(cert.Leaf)
+	tlsCreds := credentials.NewTLS(&tls.Config{RootCAs: roots, ServerName: "localhost"})
+	tcpAddr := s.GRPCAddr().String()
+	if err := sayHello(tcpAddr, tlsCreds); err != nil {
+		t.Errorf("SayHello over TLS failed: %v", err)
+	}
+	if err := sayHello(tcpAddr, insecure.NewCredentials()); err == nil {
+		t.Error("SayHello in clear text on the TLS listener should fail")
+	}
+	if err := sayHello(client.UnixScheme+grpcSocket, insecure.NewCredentials()); err != nil {
+		t.Errorf("SayHello over the unix socket failed: %v", err)
+	}
+
+	// HTTP clients reach unix sockets too
+	httpClient, err := client.Options{}.HTTPClient(client.UnixScheme + httpSocket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := httpClient.Get("http://" + client.HTTPHost(client.UnixScheme+httpSocket) + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), `"status":"OK"`) {
+		t.Errorf("got /healthz %s", body)
+	}
+
+	greeter, err := client.Options{Protocol: client.ProtocolConnect}.ConnectGreeterClient(client.UnixScheme + httpSocket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := greeter.SayHello(context.Background(), &pb.HelloRequest{Name: "connect"}); err != nil {
+		t.Errorf("Connect SayHello over the unix socket failed: %v", err)
+	}
+}
+
+func TestReusePort(t *testing.T) {
+	l := server.Listener{Serve: server.ServeGRPC, Network: "tcp4", Address: "127.0.0.1:0", ReusePort: true}
+	first, err := l.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+
+	l.Address = first.Addr().String()
+	second, err := l.Listen()
+	if err != nil {
+		t.Fatalf("binding %s twice failed: %v", l.Address, err)
+	}
+	second.Close()
+
+	l.ReusePort = false
+	if lis, err := l.Listen(); err == nil {
+		lis.Close()
+		t.Errorf("binding %s twice without reuseport should fail", l.Address)
+	}
+}
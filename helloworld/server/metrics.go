@@ -0,0 +1,73 @@
+package server
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	PromSayHelloReceivedCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "greeter_server_SayHello_received_counter",
+		Help: "Unary SayHello requests received",
+	})
+
+	PromSayHelloStreamReceivedCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "greeter_server_SayHelloStream_received_counter",
+		Help: "SayHelloStream requests received",
+	})
+	PromSayHelloStreamReceivedGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "greeter_server_SayHelloStream_received_gauge",
+		Help: "current SayHelloStream count",
+	})
+
+	PromRejectedCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "greeter_server_rejected_counter",
+		Help: "calls rejected by rate limits and admission control",
+	}, []string{"endpoint", "reason"})
+
+	PromAuthCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "greeter_server_auth_counter",
+		Help: "authentication results per method and identity",
+	}, []string{"endpoint", "method", "identity", "result"})
+
+	PromFaultCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "greeter_server_fault_counter",
+		Help: "errors injected in unary calls",
+	}, []string{"endpoint", "code"})
+
+	PromPayloadBytesCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "greeter_server_payload_bytes_counter",
+		Help: "uncompressed message bytes, by direction and grpc-encoding",
+	}, []string{"direction", "encoding"})
+
+	PromPayloadWireBytesCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "greeter_server_payload_wire_bytes_counter",
+		Help: "message bytes on the wire, after compression and gRPC framing, by direction and grpc-encoding",
+	}, []string{"direction", "encoding"})
+
+	PromProxyHeaderCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "greeter_server_proxy_header_counter",
+		Help: "PROXY protocol headers accepted, by version",
+	}, []string{"version"})
+
+	PromPeerStreamsGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "greeter_server_peer_streams_gauge",
+		Help: "current streams per client address, the real one behind a PROXY protocol load balancer",
+	}, []string{"peer"})
+
+	PromRelayCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "greeter_server_relay_counter",
+		Help: "calls relayed to the upstream Greeter, by endpoint and status code",
+	}, []string{"endpoint", "code"})
+)
+
+func init() {
+	prometheus.MustRegister(PromSayHelloReceivedCounter)
+	prometheus.MustRegister(PromSayHelloStreamReceivedCounter)
+	prometheus.MustRegister(PromSayHelloStreamReceivedGauge)
+	prometheus.MustRegister(PromRejectedCounter)
+	prometheus.MustRegister(PromAuthCounter)
+	prometheus.MustRegister(PromFaultCounter)
+	prometheus.MustRegister(PromPayloadBytesCounter)
+	prometheus.MustRegister(PromPayloadWireBytesCounter)
+	prometheus.MustRegister(PromProxyHeaderCounter)
+	prometheus.MustRegister(PromPeerStreamsGauge)
+	prometheus.MustRegister(PromRelayCounter)
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"sync/atomic"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/stats"
+
+	// accept every compressor the clients may use
+	_ "github.com/prune998/goHelloGrpcStream/helloworld/compression"
+)
+
+// payloadStats is a stats.Handler counting the message bytes, before and after compression
+type payloadStats struct{}
+
+// encodingKey is the context key holding the grpc-encoding of a call.
+// It is only known once the headers are received, after TagRPC.
+type encodingKey struct{}
+
+func (payloadStats) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	encoding := &atomic.Value{}
+	encoding.Store("identity")
+	return context.WithValue(ctx, encodingKey{}, encoding)
+}
+
+func (payloadStats) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	encoding, ok := ctx.Value(encodingKey{}).(*atomic.Value)
+	if !ok {
+		return
+	}
+	switch p := s.(type) {
+	case *stats.InHeader:
+		if p.Compression != "" {
+			encoding.Store(p.Compression)
+		}
+	case *stats.InPayload:
+		PromPayloadBytesCounter.WithLabelValues("in", encoding.Load().(string)).Add(float64(p.Length))
+		PromPayloadWireBytesCounter.WithLabelValues("in", encoding.Load().(string)).Add(float64(p.WireLength))
+	case *stats.OutPayload:
+		PromPayloadBytesCounter.WithLabelValues("out", encoding.Load().(string)).Add(float64(p.Length))
+		PromPayloadWireBytesCounter.WithLabelValues("out", encoding.Load().(string)).Add(float64(p.WireLength))
+	}
+}
+
+func (payloadStats) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (payloadStats) HandleConn(context.Context, stats.ConnStats) {}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/pires/go-proxyproto"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// ForwardedForHeader carries the address of the HTTP clients to the gRPC server over the loopback connection
+const ForwardedForHeader = "x-forwarded-for"
+
+// ProxyProtocol configures the parsing of the PROXY protocol v1 and v2 headers that TCP load balancers
+// send at the start of the connections, so the server knows the address of the real client
+type ProxyProtocol struct {
+	// Enabled reads the header when there is one. Connections without one are served as usual.
+	Enabled bool
+	// Trusted are the networks allowed to send a header. Connections from other sources sending one are closed.
+	// Any source is trusted when empty.
+	Trusted []*net.IPNet
+}
+
+// ParseTrustedProxies parses a comma separated list of networks or addresses, like 10.0.0.0/8,192.168.1.10
+func ParseTrustedProxies(list string) ([]*net.IPNet, error) {
+	var trusted []*net.IPNet
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				bits = 8 * net.IPv4len
+			}
+			entry += "/" + strconv.Itoa(bits)
+		}
+		_, n, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+		}
+		trusted = append(trusted, n)
+	}
+	return trusted, nil
+}
+
+// listener wraps lis so its connections report the client address from the PROXY header
+func (p ProxyProtocol) listener(lis net.Listener) net.Listener {
+	if !p.Enabled {
+		return lis
+	}
+	return &proxyproto.Listener{
+		Listener:       lis,
+		Policy:         p.policy,
+		ValidateHeader: countProxyHeader,
+	}
+}
+
+// policy uses the header of the trusted sources and rejects the others.
+// It never fails as that would stop the listener.
+func (p ProxyProtocol) policy(upstream net.Addr) (proxyproto.Policy, error) {
+	tcpAddr, ok := upstream.(*net.TCPAddr)
+	if len(p.Trusted) == 0 || !ok {
+		// a unix socket is only reachable from the host
+		return proxyproto.USE, nil
+	}
+	for _, n := range p.Trusted {
+		if n.Contains(tcpAddr.IP) {
+			return proxyproto.USE, nil
+		}
+	}
+	return proxyproto.REJECT, nil
+}
+
+func countProxyHeader(h *proxyproto.Header) error {
+	PromProxyHeaderCounter.WithLabelValues(strconv.Itoa(int(h.Version))).Inc()
+	return nil
+}
+
+// PeerAddr returns the address of the client of a call. For the HTTP handlers calling the
+// Greeter over the loopback connection, it is the address of their own client.
+// Behind a load balancer sending the PROXY protocol, it is the address of the real client.
+func PeerAddr(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	if p.Addr.Network() == loopbackNetwork {
+		// the handlers set the address last, after what their client sent
+		md, _ := metadata.FromIncomingContext(ctx)
+		if v := md.Get(ForwardedForHeader); len(v) > 0 {
+			hops := strings.Split(v[len(v)-1], ",")
+			return strings.TrimSpace(hops[len(hops)-1])
+		}
+	}
+	return p.Addr.String()
+}
+
+// peerHost returns the IP of the caller, without the port
+func peerHost(ctx context.Context) string {
+	return hostOf(PeerAddr(ctx))
+}
@@ -0,0 +1,193 @@
+package server_test
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prune998/goHelloGrpcStream/helloworld/client"
+	pb "github.com/prune998/goHelloGrpcStream/helloworld/helloworld"
+	"github.com/prune998/goHelloGrpcStream/helloworld/server"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// startProxied serves gRPC and HTTP on ephemeral ports, reading the PROXY header from trusted sources
+func startProxied(t *testing.T, trusted string) *server.Server {
+	t.Helper()
+	nets, err := server.ParseTrustedProxies(trusted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := server.ProxyProtocol{Enabled: true, Trusted: nets}
+
+	opts := server.DefaultOptions()
+	opts.Reply = true
+	opts.Logger = logrus.New()
+	opts.Logger.SetOutput(io.Discard)
+	opts.Listeners = []server.Listener{
+		{Serve: server.ServeGRPC, Network: "tcp4", Address: "127.0.0.1:0", Proxy: proxy},
+		{Serve: server.ServeHTTP, Network: "tcp4", Address: "127.0.0.1:0", Proxy: proxy},
+	}
+	s, err := server.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Stop)
+	return s
+}
+
+// openStream opens a SayHelloStream and waits for the server to answer, so it shows in the sessions
+func openStream(t *testing.T, ctx context.Context, c pb.GreeterClient) {
+	t.Helper()
+	stream, err := c.SayHelloStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Send(&pb.HelloRequest{Name: "Ping"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProxyProtocol(t *testing.T) {
+	s := startProxied(t, "127.0.0.1")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// gRPC with a v2 header
+	opts := client.Options{ProxyHeader: client.ProxyHeaderV2, ProxySource: "203.0.113.7:4242"}
+	dialOpts, err := opts.DialOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := grpc.Dial(s.GRPCAddr().String(), dialOpts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	openStream(t, ctx, pb.NewGreeterClient(conn))
+
+	// Connect with a v1 header, through the loopback connection
+	opts = client.Options{Protocol: client.ProtocolConnect, ProxyHeader: client.ProxyHeaderV1, ProxySource: "198.51.100.1:5000"}
+	c, err := opts.ConnectGreeterClient(s.HTTPAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	openStream(t, ctx, c)
+
+	resp, err := http.Get("http://" + s.HTTPAddr().String() + server.SessionsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var sessions []server.Session
+	if err := json.NewDecoder(resp.Body).Decode(&sessions); err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 || sessions[0].Peer != "203.0.113.7:4242" || sessions[1].Peer != "198.51.100.1" {
+		t.Errorf("got sessions %+v", sessions)
+	}
+
+	if n := testutil.ToFloat64(server.PromPeerStreamsGauge.WithLabelValues("203.0.113.7")); n != 1 {
+		t.Errorf("got %v streams for 203.0.113.7", n)
+	}
+	if n := testutil.ToFloat64(server.PromProxyHeaderCounter.WithLabelValues("1")); n < 1 {
+		t.Errorf("got %v v1 headers", n)
+	}
+}
+
+func TestProxyProtocolConnectTLS(t *testing.T) {
+	nets, err := server.ParseTrustedProxies("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := server.DefaultOptions()
+	opts.Reply = true
+	opts.Logger = logrus.New()
+	opts.Logger.SetOutput(io.Discard)
+	opts.Listeners = []server.Listener{
+		{Serve: server.ServeGRPC, Network: "tcp4", Address: "127.0.0.1:0"},
+		{
+			Serve: server.ServeHTTP, Network: "tcp4", Address: "127.0.0.1:0",
+			Proxy:     server.ProxyProtocol{Enabled: true, Trusted: nets},
+			TLSConfig: &tls.Config{Certificates: []tls.Certificate{newCert(t, nil, false, []string{"localhost"})}},
+		},
+	}
+	s, err := server.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Stop)
+
+	// the PROXY header is sent before the TLS handshake
+	c, err := client.Options{
+		Protocol: client.ProtocolConnect, TLS: true, InsecureSkipVerify: true,
+		ProxyHeader: client.ProxyHeaderV2, ProxySource: "192.0.2.9:6000",
+	}.ConnectGreeterClient(s.HTTPAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	openStream(t, ctx, c)
+	if n := testutil.ToFloat64(server.PromPeerStreamsGauge.WithLabelValues("192.0.2.9")); n != 1 {
+		t.Errorf("got %v streams for 192.0.2.9", n)
+	}
+}
+
+func TestProxyProtocolUntrusted(t *testing.T) {
+	s := startProxied(t, "10.0.0.0/8")
+
+	sayHello := func(opts client.Options) error {
+		dialOpts, err := opts.DialOptions()
+		if err != nil {
+			return err
+		}
+		conn, err := grpc.Dial(s.GRPCAddr().String(), dialOpts...)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		// the connections are closed as soon as they are open, which gRPC retries forever
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_, err = pb.NewGreeterClient(conn).SayHello(ctx, &pb.HelloRequest{Name: "untrusted"})
+		return err
+	}
+
+	if err := sayHello(client.Options{}); err != nil {
+		t.Errorf("SayHello without a header failed: %v", err)
+	}
+	if err := sayHello(client.Options{ProxyHeader: client.ProxyHeaderV2, ProxySource: "203.0.113.7:4242"}); err == nil {
+		t.Error("SayHello with a header from an untrusted source should fail")
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	nets, err := server.ParseTrustedProxies("10.0.0.0/8, 192.168.1.10,::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"10.0.0.0/8", "192.168.1.10/32", "::1/128"} {
+		if nets[i].String() != want {
+			t.Errorf("got %v, want %s", nets[i], want)
+		}
+	}
+	if _, err := server.ParseTrustedProxies("10.0.0.0/8,nope"); err == nil {
+		t.Error("expected an error")
+	}
+}
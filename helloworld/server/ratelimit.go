@@ -0,0 +1,292 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	pb "github.com/prune998/goHelloGrpcStream/helloworld/helloworld"
+	"golang.org/x/net/context"
+	"golang.org/x/time/rate"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// ClientNameHeader is the metadata key clients use to announce their name.
+// Unary calls fall back on it when the request has no name.
+const ClientNameHeader = "x-client-name"
+
+// Limits configures the admission control of the server.
+// Rates are per second and a zero value disables the limit.
+type Limits struct {
+	UnaryGlobal    float64
+	UnaryPerPeer   float64
+	UnaryPerClient float64
+
+	StreamsGlobal    float64
+	StreamsPerPeer   float64
+	StreamsPerClient float64
+
+	MessagesPerStream float64
+
+	// Burst is the bucket size of every rate limit. When 0 it is the rate rounded up.
+	Burst int
+	// MaxStreams is the maximum number of concurrent streams server-wide
+	MaxStreams int64
+}
+
+// maxStreamsRetryDelay is the retry hint sent when MaxStreams is reached
+const maxStreamsRetryDelay = time.Second
+
+// keyedIdleTimeout is how long a per peer or per client limiter is kept once unused
+const keyedIdleTimeout = 10 * time.Minute
+
+// keyedLimiter holds one rate limiter per key (peer address or client name)
+type keyedLimiter struct {
+	limit rate.Limit
+	burst int
+
+	mu       sync.Mutex
+	limiters map[string]*keyedEntry
+	lastGC   time.Time
+}
+
+type keyedEntry struct {
+	*rate.Limiter
+	lastSeen time.Time
+}
+
+func newKeyedLimiter(r float64, burst int) *keyedLimiter {
+	if r <= 0 {
+		return nil
+	}
+	return &keyedLimiter{
+		limit:    rate.Limit(r),
+		burst:    burstFor(r, burst),
+		limiters: make(map[string]*keyedEntry),
+		lastGC:   time.Now(),
+	}
+}
+
+// get returns the limiter for key, creating it if needed
+func (k *keyedLimiter) get(key string) *rate.Limiter {
+	now := time.Now()
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	// forget about the keys we did not see for a while
+	if now.Sub(k.lastGC) > keyedIdleTimeout {
+		for key, e := range k.limiters {
+			if now.Sub(e.lastSeen) > keyedIdleTimeout {
+				delete(k.limiters, key)
+			}
+		}
+		k.lastGC = now
+	}
+
+	e, ok := k.limiters[key]
+	if !ok {
+		e = &keyedEntry{Limiter: rate.NewLimiter(k.limit, k.burst)}
+		k.limiters[key] = e
+	}
+	e.lastSeen = now
+	return e.Limiter
+}
+
+func burstFor(r float64, burst int) int {
+	if burst > 0 {
+		return burst
+	}
+	return int(math.Ceil(r))
+}
+
+func newGlobalLimiter(r float64, burst int) *rate.Limiter {
+	if r <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(r), burstFor(r, burst))
+}
+
+// limiter enforces Limits through gRPC interceptors
+type limiter struct {
+	limits Limits
+
+	unaryGlobal    *rate.Limiter
+	unaryPerPeer   *keyedLimiter
+	unaryPerClient *keyedLimiter
+
+	streamsGlobal    *rate.Limiter
+	streamsPerPeer   *keyedLimiter
+	streamsPerClient *keyedLimiter
+
+	streams int64
+}
+
+func newLimiter(l Limits) *limiter {
+	return &limiter{
+		limits:           l,
+		unaryGlobal:      newGlobalLimiter(l.UnaryGlobal, l.Burst),
+		unaryPerPeer:     newKeyedLimiter(l.UnaryPerPeer, l.Burst),
+		unaryPerClient:   newKeyedLimiter(l.UnaryPerClient, l.Burst),
+		streamsGlobal:    newGlobalLimiter(l.StreamsGlobal, l.Burst),
+		streamsPerPeer:   newKeyedLimiter(l.StreamsPerPeer, l.Burst),
+		streamsPerClient: newKeyedLimiter(l.StreamsPerClient, l.Burst),
+	}
+}
+
+// reserve takes a token from lim at now, to be given back with CancelAt(now): Cancel does not give back the tokens
+// of the reservations already acted upon. If none is available it returns how long to wait for one.
+func reserve(lim *rate.Limiter, now time.Time) (*rate.Reservation, bool, time.Duration) {
+	r := lim.ReserveN(now, 1)
+	if !r.OK() {
+		return nil, false, maxStreamsRetryDelay
+	}
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return nil, false, delay
+	}
+	return r, true, 0
+}
+
+// allow takes a token from lim. If none is available it returns how long to wait for one.
+func allow(lim *rate.Limiter) (bool, time.Duration) {
+	if lim == nil {
+		return true, 0
+	}
+	_, ok, delay := reserve(lim, time.Now())
+	return ok, delay
+}
+
+// check takes a token from each of the global, per peer and per client limiters. When one of them refuses the call,
+// the tokens taken from the others are given back, so rejected calls do not drain the global budget,
+// and it returns a RESOURCE_EXHAUSTED error.
+func (l *limiter) check(ctx context.Context, endpoint, kind string, global *rate.Limiter, perPeer, perClient *keyedLimiter, client string) error {
+	type step struct {
+		lim    *rate.Limiter
+		reason string
+	}
+	steps := []step{{global, kind + "_global"}}
+	if perPeer != nil {
+		steps = append(steps, step{perPeer.get(peerHost(ctx)), kind + "_peer"})
+	}
+	if perClient != nil && client != "" {
+		steps = append(steps, step{perClient.get(client), kind + "_client"})
+	}
+
+	now := time.Now()
+	var taken []*rate.Reservation
+	for _, s := range steps {
+		if s.lim == nil {
+			continue
+		}
+		r, ok, delay := reserve(s.lim, now)
+		if !ok {
+			for _, t := range taken {
+				t.CancelAt(now)
+			}
+			return rejected(ctx, endpoint, s.reason, delay)
+		}
+		taken = append(taken, r)
+	}
+	return nil
+}
+
+// UnaryServerInterceptor applies the unary requests limits
+func (l *limiter) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		client := clientName(ctx)
+		if in, ok := req.(*pb.HelloRequest); ok && in.Name != "" {
+			client = in.Name
+		}
+		if err := l.check(ctx, info.FullMethod, "unary", l.unaryGlobal, l.unaryPerPeer, l.unaryPerClient, client); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor applies the new streams, concurrent streams and messages limits
+func (l *limiter) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := ss.Context()
+		if err := l.check(ctx, info.FullMethod, "streams", l.streamsGlobal, l.streamsPerPeer, l.streamsPerClient, clientName(ctx)); err != nil {
+			return err
+		}
+
+		if n := atomic.AddInt64(&l.streams, 1); l.limits.MaxStreams > 0 && n > l.limits.MaxStreams {
+			atomic.AddInt64(&l.streams, -1)
+			return rejected(ctx, info.FullMethod, "max_streams", maxStreamsRetryDelay)
+		}
+		defer atomic.AddInt64(&l.streams, -1)
+
+		if l.limits.MessagesPerStream <= 0 {
+			return handler(srv, ss)
+		}
+		ls := &limitedStream{
+			ServerStream: ss,
+			endpoint:     info.FullMethod,
+			lim:          newGlobalLimiter(l.limits.MessagesPerStream, l.limits.Burst),
+		}
+		err := handler(srv, ls)
+		// the handler ends the stream on any receive error, make sure the client knows why
+		if ls.err != nil {
+			return ls.err
+		}
+		return err
+	}
+}
+
+// limitedStream rejects the messages received over the stream rate
+type limitedStream struct {
+	grpc.ServerStream
+	endpoint string
+	lim      *rate.Limiter
+	err      error
+}
+
+func (s *limitedStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	if ok, delay := allow(s.lim); !ok {
+		s.err = rejected(s.Context(), s.endpoint, "messages", delay)
+		return s.err
+	}
+	return nil
+}
+
+// rejected counts the rejection and builds a RESOURCE_EXHAUSTED error with retry hints,
+// both as a RetryInfo detail and as the grpc-retry-pushback-ms trailer
+func rejected(ctx context.Context, endpoint, reason string, delay time.Duration) error {
+	PromRejectedCounter.WithLabelValues(endpoint, reason).Inc()
+
+	if delay < time.Millisecond {
+		delay = time.Millisecond
+	}
+	grpc.SetTrailer(ctx, metadata.Pairs("grpc-retry-pushback-ms", strconv.FormatInt(delay.Milliseconds(), 10)))
+
+	st := status.New(codes.ResourceExhausted, fmt.Sprintf("rate limited (%s), retry in %v", reason, delay))
+	if detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(delay)}); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+// clientName returns the name the client announced in its metadata
+func clientName(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if v := md.Get(ClientNameHeader); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
@@ -0,0 +1,126 @@
+package server_test
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	pb "github.com/prune998/goHelloGrpcStream/helloworld/helloworld"
+	"github.com/prune998/goHelloGrpcStream/helloworld/server"
+	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// expectExhausted checks err is a RESOURCE_EXHAUSTED carrying a retry delay
+func expectExhausted(t *testing.T, err error) {
+	t.Helper()
+	st := status.Convert(err)
+	if st.Code() != codes.ResourceExhausted {
+		t.Fatalf("got %v, want ResourceExhausted", err)
+	}
+	for _, d := range st.Details() {
+		if ri, ok := d.(*errdetails.RetryInfo); ok && ri.RetryDelay.AsDuration() > 0 {
+			return
+		}
+	}
+	t.Errorf("no RetryInfo in %v", st.Details())
+}
+
+func TestUnaryRateLimit(t *testing.T) {
+	opts := server.DefaultOptions()
+	opts.Limits.UnaryPerClient = 0.001
+	ts := startServer(t, opts)
+	c := pb.NewGreeterClient(ts.conn)
+	rejected := testutil.ToFloat64(server.PromRejectedCounter.WithLabelValues("/helloworld.Greeter/SayHello", "unary_client"))
+
+	if _, err := c.SayHello(context.Background(), &pb.HelloRequest{Name: "a"}); err != nil {
+		t.Fatalf("first SayHello failed: %v", err)
+	}
+	var trailer metadata.MD
+	_, err := c.SayHello(context.Background(), &pb.HelloRequest{Name: "a"}, grpc.Trailer(&trailer))
+	expectExhausted(t, err)
+	if len(trailer.Get("grpc-retry-pushback-ms")) != 1 {
+		t.Errorf("missing grpc-retry-pushback-ms in trailer %v", trailer)
+	}
+
+	// another client has its own budget
+	if _, err := c.SayHello(context.Background(), &pb.HelloRequest{Name: "b"}); err != nil {
+		t.Errorf("SayHello from another client failed: %v", err)
+	}
+
+	if got := testutil.ToFloat64(server.PromRejectedCounter.WithLabelValues("/helloworld.Greeter/SayHello", "unary_client")) - rejected; got != 1 {
+		t.Errorf("rejected counter increased by %v, want 1", got)
+	}
+}
+
+func TestRejectedCallsKeepGlobalBudget(t *testing.T) {
+	opts := server.DefaultOptions()
+	opts.Limits.UnaryGlobal = 2
+	opts.Limits.UnaryPerClient = 0.001
+	ts := startServer(t, opts)
+	c := pb.NewGreeterClient(ts.conn)
+
+	if _, err := c.SayHello(context.Background(), &pb.HelloRequest{Name: "noisy"}); err != nil {
+		t.Fatalf("first SayHello failed: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		_, err := c.SayHello(context.Background(), &pb.HelloRequest{Name: "noisy"})
+		expectExhausted(t, err)
+	}
+	// the rejected calls gave back their global token
+	if _, err := c.SayHello(context.Background(), &pb.HelloRequest{Name: "quiet"}); err != nil {
+		t.Errorf("SayHello from another client failed: %v", err)
+	}
+}
+
+func TestMaxStreams(t *testing.T) {
+	opts := server.DefaultOptions()
+	opts.Reply = true
+	opts.Limits.MaxStreams = 1
+	ts := startServer(t, opts)
+	c := pb.NewGreeterClient(ts.conn)
+
+	first, err := c.SayHelloStream(context.Background())
+	if err != nil {
+		t.Fatalf("SayHelloStream failed: %v", err)
+	}
+	if err := first.Send(&pb.HelloRequest{Name: "first"}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if _, err := first.Recv(); err != nil {
+		t.Fatalf("Recv failed: %v", err)
+	}
+
+	second, err := c.SayHelloStream(context.Background())
+	if err != nil {
+		t.Fatalf("SayHelloStream failed: %v", err)
+	}
+	_, err = second.Recv()
+	expectExhausted(t, err)
+}
+
+func TestMessagesRateLimit(t *testing.T) {
+	opts := server.DefaultOptions()
+	opts.Reply = true
+	opts.Limits.MessagesPerStream = 0.001
+	ts := startServer(t, opts)
+
+	stream, err := pb.NewGreeterClient(ts.conn).SayHelloStream(context.Background())
+	if err != nil {
+		t.Fatalf("SayHelloStream failed: %v", err)
+	}
+	if err := stream.Send(&pb.HelloRequest{Name: "one"}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatalf("Recv failed: %v", err)
+	}
+	if err := stream.Send(&pb.HelloRequest{Name: "two"}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	_, err = stream.Recv()
+	expectExhausted(t, err)
+}
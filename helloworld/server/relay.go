@@ -0,0 +1,157 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	pb "github.com/prune998/goHelloGrpcStream/helloworld/helloworld"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// hopByHopHeaders are not relayed, gRPC sets them for each call like the grpc-* ones.
+// Everything else is, trace context (traceparent, tracestate, x-b3-*, x-request-id) and credentials included.
+var hopByHopHeaders = map[string]bool{
+	":authority":   true,
+	"content-type": true,
+	"user-agent":   true,
+	"te":           true,
+}
+
+// relayMetadata returns the metadata to pass along to the next hop, in either direction
+func relayMetadata(md metadata.MD) metadata.MD {
+	out := metadata.MD{}
+	for key, values := range md {
+		if hopByHopHeaders[key] || strings.HasPrefix(key, "grpc-") {
+			continue
+		}
+		out[key] = append([]string(nil), values...)
+	}
+	return out
+}
+
+// relayContext returns the context of the upstream call, with the incoming metadata and deadline.
+// The address of the client is added to x-forwarded-for, unless the loopback connection already did.
+func relayContext(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	out := relayMetadata(md)
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil && p.Addr.Network() != loopbackNetwork {
+		out.Append(ForwardedForHeader, hostOf(p.Addr.String()))
+	}
+	return metadata.NewOutgoingContext(ctx, out)
+}
+
+// relayError returns the upstream error with the name of the relay, keeping its code and details
+func (s *Server) relayError(endpoint string, err error) error {
+	st := status.Convert(err)
+	PromRelayCounter.WithLabelValues(endpoint, st.Code().String()).Inc()
+	p := st.Proto()
+	p.Message = fmt.Sprintf("%s: %s", s.opts.HopName, p.Message)
+	return status.FromProto(p).Err()
+}
+
+// hop appends the relay to the hops of reply
+func (s *Server) hop(ctx context.Context, reply *pb.HelloReply, duration time.Duration) *pb.HelloReply {
+	reply.Hops = append(reply.Hops, &pb.Hop{
+		Name:       s.opts.HopName,
+		Peer:       PeerAddr(ctx),
+		DurationUs: duration.Microseconds(),
+	})
+	return reply
+}
+
+// relaySayHello forwards SayHello to the upstream Greeter
+func (s *Server) relaySayHello(ctx context.Context, in *pb.HelloRequest) (*pb.HelloReply, error) {
+	start := time.Now()
+	var header, trailer metadata.MD
+	reply, err := s.opts.Upstream.SayHello(relayContext(ctx), in, grpc.Header(&header), grpc.Trailer(&trailer))
+	grpc.SetHeader(ctx, relayMetadata(header))
+	grpc.SetTrailer(ctx, relayMetadata(trailer))
+	if err != nil {
+		return nil, s.relayError("SayHello", err)
+	}
+	PromRelayCounter.WithLabelValues("SayHello", "OK").Inc()
+	return s.hop(ctx, reply, time.Since(start)), nil
+}
+
+// relaySayHelloStream forwards each message of the stream to an upstream stream, and each of its replies back
+func (s *Server) relaySayHelloStream(stream pb.Greeter_SayHelloStreamServer, log *logrus.Entry) error {
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	upstream, err := s.opts.Upstream.SayHelloStream(relayContext(ctx))
+	if err != nil {
+		return s.relayError("SayHelloStream", err)
+	}
+
+	// downstream messages are sent upstream by this goroutine, until the client half-closes
+	go func() {
+		for {
+			msg, err := stream.Recv()
+			if err != nil {
+				if err != io.EOF {
+					log.Errorf("Error while receiving from user: %v", err)
+					cancel()
+				}
+				upstream.CloseSend()
+				return
+			}
+			if err := upstream.Send(msg); err != nil {
+				// Recv gets the reason
+				return
+			}
+		}
+	}()
+
+	if header, err := upstream.Header(); err == nil {
+		stream.SetHeader(relayMetadata(header))
+	}
+	for {
+		reply, err := upstream.Recv()
+		if err == io.EOF {
+			stream.SetTrailer(relayMetadata(upstream.Trailer()))
+			PromRelayCounter.WithLabelValues("SayHelloStream", "OK").Inc()
+			return nil
+		}
+		if err != nil {
+			stream.SetTrailer(relayMetadata(upstream.Trailer()))
+			return s.relayError("SayHelloStream", err)
+		}
+		if err := stream.Send(s.hop(ctx, reply, 0)); err != nil {
+			log.Errorf("Error while relaying to user: %v", err)
+			return nil
+		}
+	}
+}
+
+// relaySayHelloServerStream forwards SayHelloServerStream to the upstream Greeter, and its replies back
+func (s *Server) relaySayHelloServerStream(in *pb.HelloStreamRequest, stream pb.Greeter_SayHelloServerStreamServer) error {
+	ctx := stream.Context()
+	upstream, err := s.opts.Upstream.SayHelloServerStream(relayContext(ctx), in)
+	if err != nil {
+		return s.relayError("SayHelloServerStream", err)
+	}
+	if header, err := upstream.Header(); err == nil {
+		stream.SetHeader(relayMetadata(header))
+	}
+	for {
+		reply, err := upstream.Recv()
+		if err == io.EOF {
+			stream.SetTrailer(relayMetadata(upstream.Trailer()))
+			PromRelayCounter.WithLabelValues("SayHelloServerStream", "OK").Inc()
+			return nil
+		}
+		if err != nil {
+			stream.SetTrailer(relayMetadata(upstream.Trailer()))
+			return s.relayError("SayHelloServerStream", err)
+		}
+		if err := stream.Send(s.hop(ctx, reply, 0)); err != nil {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package server_test
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/prune998/goHelloGrpcStream/helloworld/helloworld"
+	"github.com/prune998/goHelloGrpcStream/helloworld/server"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// recordingGreeter is the end of a relay chain, keeping what it got from the last relay
+type recordingGreeter struct {
+	pb.UnimplementedGreeterServer
+	md       metadata.MD
+	deadline time.Time
+	err      error
+}
+
+func (g *recordingGreeter) SayHello(ctx context.Context, in *pb.HelloRequest) (*pb.HelloReply, error) {
+	g.md, _ = metadata.FromIncomingContext(ctx)
+	g.deadline, _ = ctx.Deadline()
+	grpc.SetTrailer(ctx, metadata.Pairs("x-upstream", "end"))
+	if g.err != nil {
+		return nil, g.err
+	}
+	return &pb.HelloReply{Message: "Hello " + in.Name}, nil
+}
+
+// serveGreeter serves g over bufconn and returns a client connection to it
+func serveGreeter(t *testing.T, g pb.GreeterServer) *grpc.ClientConn {
+	t.Helper()
+	srv := grpc.NewServer()
+	pb.RegisterGreeterServer(srv, g)
+	lis := bufconn.Listen(1024 * 1024)
+	go srv.Serve(lis)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		srv.Stop()
+	})
+	return conn
+}
+
+// startChain starts one relay per name in front of upstream, the first one being called by the client
+func startChain(t *testing.T, upstream *grpc.ClientConn, names ...string) pb.GreeterClient {
+	t.Helper()
+	for i := len(names) - 1; i >= 0; i-- {
+		opts := server.DefaultOptions()
+		opts.HopName = names[i]
+		opts.Upstream = pb.NewGreeterClient(upstream)
+		upstream = startServer(t, opts).conn
+	}
+	return pb.NewGreeterClient(upstream)
+}
+
+func TestRelaySayHello(t *testing.T) {
+	end := &recordingGreeter{}
+	c := startChain(t, serveGreeter(t, end), "a", "b")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ctx = metadata.AppendToOutgoingContext(ctx,
+		"traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+		"x-request-id", "42",
+		"x-client-name", "relayed",
+	)
+	var trailer metadata.MD
+	r, err := c.SayHello(ctx, &pb.HelloRequest{Name: "chain"}, grpc.Trailer(&trailer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Message != "Hello chain" {
+		t.Errorf("got message %q", r.Message)
+	}
+	if len(r.Hops) != 2 || r.Hops[0].Name != "b" || r.Hops[1].Name != "a" || r.Hops[1].DurationUs < r.Hops[0].DurationUs {
+		t.Errorf("got hops %v", r.Hops)
+	}
+
+	for key, want := range map[string]string{
+		"traceparent":   "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+		"x-request-id":  "42",
+		"x-client-name": "relayed",
+	} {
+		if got := end.md.Get(key); len(got) != 1 || got[0] != want {
+			t.Errorf("upstream got %s %v, want %s", key, got, want)
+		}
+	}
+	if end.deadline.IsZero() || time.Until(end.deadline) > 5*time.Second {
+		t.Errorf("upstream got deadline %v", end.deadline)
+	}
+	if got := trailer.Get("x-upstream"); len(got) != 1 {
+		t.Errorf("the upstream trailer was not relayed: %v", trailer)
+	}
+}
+
+func TestRelayError(t *testing.T) {
+	end := &recordingGreeter{err: status.Error(codes.NotFound, "nobody here")}
+	c := startChain(t, serveGreeter(t, end), "a", "b")
+
+	_, err := c.SayHello(context.Background(), &pb.HelloRequest{Name: "chain"})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("got %v, want NotFound", err)
+	}
+	if msg := status.Convert(err).Message(); msg != "a: b: nobody here" {
+		t.Errorf("got message %q", msg)
+	}
+}
+
+func TestRelaySayHelloStream(t *testing.T) {
+	opts := server.DefaultOptions()
+	opts.Reply = true
+	c := startChain(t, startServer(t, opts).conn, "a", "b")
+
+	stream, err := c.SayHelloStream(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"one", "two"} {
+		if err := stream.Send(&pb.HelloRequest{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+		r, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Message != "Pong "+name || len(r.Hops) != 2 || r.Hops[0].Name != "b" || r.Hops[1].Name != "a" {
+			t.Errorf("got %v", r)
+		}
+	}
+	stream.CloseSend()
+	if _, err := stream.Recv(); err != io.EOF {
+		t.Errorf("got %v at the end of the stream, want EOF", err)
+	}
+
+	ss, err := c.SayHelloServerStream(context.Background(), &pb.HelloStreamRequest{Name: "server", Count: 2, IntervalMs: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var messages []string
+	for {
+		r, err := ss.Recv()
+		if err != nil {
+			break
+		}
+		messages = append(messages, r.Message)
+	}
+	if strings.Join(messages, ",") != "Hello server 1,Hello server 2" {
+		t.Errorf("got %v", messages)
+	}
+}
@@ -0,0 +1,539 @@
+// Package server implements the helloworld.Greeter service along with its
+// wiring (interceptors, metrics, health and HTTP endpoints) so it can be
+// embedded in greeter_server or started in-process by tests and tools.
+package server
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	grpc_logrus "github.com/grpc-ecosystem/go-grpc-middleware/logging/logrus"
+	grpc_ctxtags "github.com/grpc-ecosystem/go-grpc-middleware/tags"
+	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	pb "github.com/prune998/goHelloGrpcStream/helloworld/helloworld"
+	"github.com/sirupsen/logrus"
+
+	"golang.org/x/net/context"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
+)
+
+// enableHistogram turns on the global gRPC handling time histogram once,
+// as it is not safe to do while another Server is handling calls
+var enableHistogram sync.Once
+
+// Options configures a Server
+type Options struct {
+	// GRPCPort is the port to bind for gRPC. It is also part of the unary reply.
+	GRPCPort string
+	// HTTPPort is the port to bind for /healthz, /metrics, the REST/JSON gateway and gRPC-Web/Connect
+	HTTPPort string
+	// SinglePort serves gRPC and HTTP on GRPCPort only, see ServeSinglePort
+	SinglePort bool
+	// Listeners are bound by Start instead of GRPCPort, HTTPPort and SinglePort when set
+	Listeners []Listener
+	// ProxyProtocol configures the PROXY protocol on GRPCPort and HTTPPort, disabled by default.
+	// Listeners have their own setting.
+	ProxyProtocol ProxyProtocol
+	// Reply makes SayHelloStream answer each message it receives
+	Reply bool
+	// Echo adds what the server saw of the call, metadata, peer and TLS settings, to the unary replies
+	// and the first reply of the streams
+	Echo bool
+	// MaxConcurrentStreams is the HTTP/2 streams limit per connection
+	MaxConcurrentStreams uint32
+	// KeepaliveMinTime is the shortest interval allowed between the client keepalive pings, 5 minutes when 0.
+	// The clients pinging more often are disconnected. It is not enforced on the single port, see ServeSinglePort.
+	KeepaliveMinTime time.Duration
+	// Limits configures rate limits and admission control, all disabled by default
+	Limits Limits
+	// Auth configures the authentication of the callers, disabled by default
+	Auth AuthOptions
+	// Faults configures the errors and delays injected in unary calls, disabled by default
+	Faults Faults
+	// TLSConfig enables TLS on the gRPC port, and the gRPC listeners without their own.
+	// Set ClientCAs to verify client certificates.
+	TLSConfig *tls.Config
+	// Upstream turns the server into a relay, forwarding the calls and stream messages to this Greeter
+	// with their metadata and deadline. The relay appends a Hop to each reply.
+	Upstream pb.GreeterClient
+	// HopName is the name of the relay in the hops, the hostname by default
+	HopName string
+	// Version is reported by /healthz
+	Version string
+	// Backend identifies the server in every reply, BackendFromEnv(Version) when nil
+	Backend *pb.Backend
+	// Logger is used for access and application logs. A JSON logger on stdout is used when nil.
+	Logger *logrus.Logger
+}
+
+// DefaultOptions returns the options greeter_server uses when no flag is given
+func DefaultOptions() Options {
+	hostname, _ := os.Hostname()
+	return Options{
+		GRPCPort:             "7788",
+		HTTPPort:             "7789",
+		MaxConcurrentStreams: 50000,
+		HopName:              hostname,
+		Version:              "no version set",
+	}
+}
+
+// Server is used to implement helloworld.GreeterServer.
+type Server struct {
+	*logrus.Logger
+	pb.UnimplementedGreeterServer
+
+	opts     Options
+	log      *logrus.Entry
+	grpc     *grpc.Server
+	health   *health.Server
+	httpMux  *http.ServeMux
+	loopback *loopback
+	sse      sseSessions
+	sessions sessionList
+
+	// serveLoopback starts serving the loopback connection once, with the first listener
+	serveLoopback sync.Once
+
+	mu        sync.Mutex
+	grpcLis   net.Listener
+	httpLis   net.Listener
+	httpSrvs  []*http.Server
+	isStopped bool
+}
+
+// New creates a Server and registers the Greeter and health services.
+// Nothing is listening until Start or Serve is called.
+func New(opts Options) (*Server, error) {
+	if opts.Logger == nil {
+		opts.Logger = logrus.New()
+		opts.Logger.SetFormatter(&logrus.JSONFormatter{})
+	}
+	if opts.Backend == nil {
+		opts.Backend = BackendFromEnv(opts.Version)
+	}
+
+	s := &Server{
+		Logger: opts.Logger,
+		opts:   opts,
+		log: opts.Logger.WithFields(logrus.Fields{
+			"application": "greeter_server",
+		}),
+		health:  health.NewServer(),
+		httpMux: http.NewServeMux(),
+	}
+
+	// add logrus accesslogs
+	logOpts := []grpc_logrus.Option{
+		grpc_logrus.WithDurationField(func(duration time.Duration) (key string, value interface{}) {
+			return "grpc.time_ns", duration.Nanoseconds()
+		}),
+	}
+
+	// configure the gRPC endpoint to report metrics, logs and increase HTTP2 streams
+	// auth and limiter come last so rejected calls still show in the metrics and access logs
+	enableHistogram.Do(func() { grpc_prometheus.EnableHandlingTimeHistogram() })
+	auth := &authenticator{opts: opts.Auth}
+	limiter := newLimiter(opts.Limits)
+	serverOpts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(
+			grpc_ctxtags.UnaryServerInterceptor(),
+			grpc_prometheus.UnaryServerInterceptor,
+			grpc_logrus.UnaryServerInterceptor(s.log, logOpts...),
+			auth.UnaryServerInterceptor(),
+			limiter.UnaryServerInterceptor(),
+			opts.Faults.UnaryServerInterceptor(),
+		),
+		grpc.ChainStreamInterceptor(
+			grpc_ctxtags.StreamServerInterceptor(),
+			grpc_prometheus.StreamServerInterceptor,
+			grpc_logrus.StreamServerInterceptor(s.log, logOpts...),
+			auth.StreamServerInterceptor(),
+			limiter.StreamServerInterceptor(),
+			s.sessions.StreamServerInterceptor(),
+		),
+	}
+	serverOpts = append(serverOpts, grpc.StatsHandler(payloadStats{}))
+	// each listener can have its own TLS settings
+	serverOpts = append(serverOpts, grpc.Creds(listenerCredentials{config: opts.TLSConfig}))
+	if opts.MaxConcurrentStreams > 0 {
+		serverOpts = append(serverOpts, grpc.MaxConcurrentStreams(opts.MaxConcurrentStreams))
+	}
+	if opts.KeepaliveMinTime > 0 {
+		serverOpts = append(serverOpts, grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             opts.KeepaliveMinTime,
+			PermitWithoutStream: true,
+		}))
+	}
+	s.grpc = grpc.NewServer(serverOpts...)
+	pb.RegisterGreeterServer(s.grpc, s)
+	healthpb.RegisterHealthServer(s.grpc, s.health)
+	grpc_prometheus.Register(s.grpc)
+
+	// healthz basic
+	s.httpMux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		m := map[string]interface{}{"version": opts.Version, "status": "OK", "backend": opts.Backend}
+
+		b, err := json.Marshal(m)
+		if err != nil {
+			http.Error(w, "no valid point for this device_id", 500)
+			return
+		}
+
+		w.Write(b)
+	})
+
+	// prometheus metrics
+	s.httpMux.Handle("/metrics", promhttp.Handler())
+
+	// streams being served, with the address of their client
+	s.httpMux.HandleFunc(SessionsPath, s.serveSessions)
+
+	// REST/JSON gateway, its OpenAPI document and gRPC-Web/Connect, calling the gRPC server in-memory
+	loopback, err := newLoopback()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect the HTTP handlers to the gRPC server: %w", err)
+	}
+	gw, err := newGateway(loopback.conn)
+	if err != nil {
+		loopback.Close()
+		return nil, fmt.Errorf("failed to create the REST gateway: %w", err)
+	}
+	s.loopback = loopback
+	s.httpMux.Handle("/v1/", withClientSANs(gw))
+	s.httpMux.HandleFunc("/openapi.json", serveOpenAPI)
+	connectPath, connectHandler := newConnectHandler(loopback.conn)
+	s.httpMux.Handle(connectPath, withClientSANs(connectHandler))
+	s.httpMux.Handle(WebSocketPath, withClientSANs(http.HandlerFunc(s.serveWebSocket)))
+	s.httpMux.Handle(SSEPath, withClientSANs(http.HandlerFunc(s.serveSSE)))
+	s.httpMux.Handle(SSEPath+"/", withClientSANs(http.HandlerFunc(s.serveSSE)))
+
+	return s, nil
+}
+
+// GRPCServer returns the underlying gRPC server so more services can be registered
+func (s *Server) GRPCServer() *grpc.Server {
+	return s.grpc
+}
+
+// HTTPHandler returns the handler serving /healthz, /metrics, /sessions, the REST/JSON gateway under /v1/,
+// its OpenAPI document on /openapi.json, the Greeter over gRPC-Web, Connect and gRPC,
+// and the stream over WebSocket and Server-Sent Events
+func (s *Server) HTTPHandler() http.Handler {
+	return s.httpMux
+}
+
+// Start binds the listeners and serves them in the background.
+// Without Listeners, the gRPC and HTTP ports are bound on all the addresses. An empty HTTPPort
+// disables the HTTP listener, and with SinglePort only GRPCPort is bound and serves both.
+func (s *Server) Start() error {
+	listeners := s.opts.Listeners
+	if len(listeners) == 0 {
+		listeners = s.opts.portListeners()
+	}
+
+	// bind everything first, so nothing is served when an address is not available
+	bound := make([]net.Listener, 0, len(listeners))
+	for _, l := range listeners {
+		lis, err := l.Listen()
+		if err != nil {
+			for _, b := range bound {
+				b.Close()
+			}
+			return fmt.Errorf("failed to listen on %v: %w", l, err)
+		}
+		bound = append(bound, lis)
+	}
+
+	// the first gRPC and HTTP listeners are the ones GRPCAddr and HTTPAddr report
+	s.mu.Lock()
+	for i, l := range listeners {
+		if s.grpcLis == nil && (l.Serve == ServeGRPC || l.Serve == ServeBoth) {
+			s.grpcLis = bound[i]
+		}
+		if s.httpLis == nil && (l.Serve == ServeHTTP || l.Serve == ServeBoth) {
+			s.httpLis = bound[i]
+		}
+	}
+	s.mu.Unlock()
+
+	for i, l := range listeners {
+		l, lis := l, bound[i]
+		go func() {
+			if err := s.ServeListener(l, lis); err != nil {
+				s.log.Errorf("failed to serve %v: %v", l, err)
+			}
+		}()
+	}
+	return nil
+}
+
+// portListeners returns the listeners of GRPCPort and HTTPPort
+func (o Options) portListeners() []Listener {
+	if o.SinglePort {
+		return []Listener{{Serve: ServeBoth, Network: "tcp", Address: ":" + o.GRPCPort, Proxy: o.ProxyProtocol}}
+	}
+	listeners := []Listener{{Serve: ServeGRPC, Network: "tcp", Address: ":" + o.GRPCPort, Proxy: o.ProxyProtocol}}
+	if o.HTTPPort != "" {
+		listeners = append(listeners, Listener{Serve: ServeHTTP, Network: "tcp", Address: ":" + o.HTTPPort, Proxy: o.ProxyProtocol})
+	}
+	return listeners
+}
+
+// ServeListener serves what l says on lis, usually bound with l.Listen, until Stop is called
+func (s *Server) ServeListener(l Listener, lis net.Listener) error {
+	lis = l.Proxy.listener(lis)
+	switch l.Serve {
+	case ServeGRPC:
+		if l.TLSConfig != nil {
+			lis = &tlsListener{Listener: lis, config: l.TLSConfig}
+		}
+		return s.Serve(lis)
+	case ServeHTTP:
+		return s.serveHTTP(lis, s.httpMux, l.TLSConfig, "listening HTTP (metrics & map)")
+	case ServeBoth:
+		tlsConfig := l.TLSConfig
+		if tlsConfig == nil {
+			tlsConfig = s.opts.TLSConfig
+		}
+		return s.serveSinglePort(lis, tlsConfig)
+	}
+	return fmt.Errorf("listener %v: serve %s, %s or %s", l, ServeGRPC, ServeHTTP, ServeBoth)
+}
+
+// Serve accepts gRPC connections on lis until Stop is called.
+// It can be used with any listener, like a bufconn or an ephemeral port.
+func (s *Server) Serve(lis net.Listener) error {
+	s.mu.Lock()
+	if s.isStopped {
+		s.mu.Unlock()
+		return grpc.ErrServerStopped
+	}
+	if s.grpcLis == nil {
+		s.grpcLis = lis
+	}
+	s.mu.Unlock()
+
+	s.startLoopback()
+	s.setServing()
+	s.log.Warnf("Listening on %s", addrURL(lis.Addr()))
+	return s.grpc.Serve(lis)
+}
+
+// setServing reports the server and the Greeter as serving to health checks
+func (s *Server) setServing() {
+	s.health.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	s.health.SetServingStatus("helloworld.Greeter", healthpb.HealthCheckResponse_SERVING)
+}
+
+// ServeHTTP serves HTTPHandler on lis until Stop is called.
+func (s *Server) ServeHTTP(lis net.Listener) error {
+	return s.serveHTTP(lis, s.httpMux, nil, "listening HTTP (metrics & map)")
+}
+
+// serveHTTP serves handler on lis until Stop is called, logging what it serves with msg. Without tlsConfig
+// HTTP/2 is in clear text (h2c), letting gRPC and Connect clients stream both ways, otherwise TLS is
+// done here and HTTP/2 or HTTP/1.1 is negotiated with ALPN.
+func (s *Server) serveHTTP(lis net.Listener, handler http.Handler, tlsConfig *tls.Config, msg string) error {
+	h2 := &http2.Server{MaxConcurrentStreams: s.opts.MaxConcurrentStreams}
+	srv := &http.Server{}
+	if tlsConfig != nil {
+		srv.Handler = handler
+		if err := http2.ConfigureServer(srv, h2); err != nil {
+			return err
+		}
+		cfg := tlsConfig.Clone()
+		cfg.NextProtos = []string{http2.NextProtoTLS, "http/1.1"}
+		lis = tls.NewListener(lis, cfg)
+	} else {
+		srv.Handler = h2c.NewHandler(handler, h2)
+	}
+
+	s.mu.Lock()
+	if s.isStopped {
+		s.mu.Unlock()
+		return http.ErrServerClosed
+	}
+	if s.httpLis == nil {
+		s.httpLis = lis
+	}
+	s.httpSrvs = append(s.httpSrvs, srv)
+	s.mu.Unlock()
+
+	s.startLoopback()
+
+	s.log.Warnf("%s on %s", msg, addrURL(lis.Addr()))
+	err := srv.Serve(lis)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// addrURL formats addr like network://address
+func addrURL(addr net.Addr) string {
+	return addr.Network() + "://" + addr.String()
+}
+
+// GRPCAddr returns the address the gRPC server is bound to, the first one with several listeners,
+// or nil if it is not serving
+func (s *Server) GRPCAddr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.grpcLis == nil {
+		return nil
+	}
+	return s.grpcLis.Addr()
+}
+
+// HTTPAddr returns the address the HTTP server is bound to, the first one with several listeners,
+// or nil if it is not serving
+func (s *Server) HTTPAddr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.httpLis == nil {
+		return nil
+	}
+	return s.httpLis.Addr()
+}
+
+// Stop closes all the listeners and the opened connections and streams
+func (s *Server) Stop() {
+	s.mu.Lock()
+	s.isStopped = true
+	httpSrvs := s.httpSrvs
+	s.mu.Unlock()
+
+	s.health.Shutdown()
+	s.grpc.Stop()
+	for _, srv := range httpSrvs {
+		srv.Close()
+	}
+	s.loopback.Close()
+}
+
+// startLoopback serves the in-memory connection used by the HTTP handlers
+func (s *Server) startLoopback() {
+	s.serveLoopback.Do(func() {
+		go s.grpc.Serve(s.loopback)
+	})
+}
+
+// SayHello implements helloworld.GreeterServer
+func (s *Server) SayHello(ctx context.Context, in *pb.HelloRequest) (*pb.HelloReply, error) {
+	log := s.WithFields(logrus.Fields{
+		"client":   in.Name,
+		"peer":     PeerAddr(ctx),
+		"identity": Identity(ctx),
+		"port":     s.opts.GRPCPort,
+		"endpoint": "SayHello",
+	})
+	PromSayHelloReceivedCounter.Inc()
+	log.Infof("got request from client %v:%v", in.Name, s.opts.GRPCPort)
+	if s.opts.Upstream != nil {
+		return s.relaySayHello(ctx, in)
+	}
+	return &pb.HelloReply{Message: "Hello " + in.Name + " " + s.opts.GRPCPort, Echo: s.echo(ctx), Backend: s.opts.Backend}, nil
+}
+
+// SayHelloStream implements helloworld.GreeterServer
+func (s *Server) SayHelloStream(stream pb.Greeter_SayHelloStreamServer) error {
+	log := s.WithFields(logrus.Fields{
+		"peer":     PeerAddr(stream.Context()),
+		"identity": Identity(stream.Context()),
+		"port":     s.opts.GRPCPort,
+		"endpoint": "SayHelloStream",
+	})
+	PromSayHelloStreamReceivedCounter.Inc()
+	PromSayHelloStreamReceivedGauge.Inc()
+	defer PromSayHelloStreamReceivedGauge.Dec()
+	log.Info("SayHelloStream called")
+	if s.opts.Upstream != nil {
+		return s.relaySayHelloStream(stream, log)
+	}
+	echo := s.echo(stream.Context())
+	for {
+		msg, err := stream.Recv()
+		if err == io.EOF {
+			log.Errorf("EOF while sending alerts to user: %v", err)
+			break
+		}
+		if err != nil {
+			log.Errorf("Error while sending alerts to user: %v", err)
+			break
+		}
+
+		log.Infof("Reveived Stream message %v", msg.Name)
+
+		// we reply to the message
+		if s.opts.Reply {
+			err = stream.Send(&pb.HelloReply{Message: "Pong " + msg.Name, Seq: msg.Seq, Echo: echo, Backend: s.opts.Backend})
+			echo = nil
+			if err == io.EOF {
+				log.Errorf("EOF while sending alerts to user: %v", err)
+				break
+			}
+			if err != nil {
+				log.Errorf("Error while sending alerts to user: %v", err)
+				break
+			}
+		}
+
+	}
+	return nil
+}
+
+// SayHelloServerStream implements helloworld.GreeterServer
+func (s *Server) SayHelloServerStream(in *pb.HelloStreamRequest, stream pb.Greeter_SayHelloServerStreamServer) error {
+	log := s.WithFields(logrus.Fields{
+		"client":   in.Name,
+		"peer":     PeerAddr(stream.Context()),
+		"identity": Identity(stream.Context()),
+		"port":     s.opts.GRPCPort,
+		"endpoint": "SayHelloServerStream",
+	})
+	interval := time.Duration(in.IntervalMs) * time.Millisecond
+	if interval <= 0 {
+		interval = time.Second
+	}
+	log.Infof("SayHelloServerStream called for %d greetings every %v", in.Count, interval)
+	if s.opts.Upstream != nil {
+		return s.relaySayHelloServerStream(in, stream)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	echo := s.echo(stream.Context())
+	for i := int32(1); in.Count == 0 || i <= in.Count; i++ {
+		if i > 1 {
+			select {
+			case <-ticker.C:
+			case <-stream.Context().Done():
+				log.Infof("client went away after %d greetings", i-1)
+				return nil
+			}
+		}
+		err := stream.Send(&pb.HelloReply{Message: fmt.Sprintf("Hello %s %d", in.Name, i), Echo: echo, Backend: s.opts.Backend})
+		echo = nil
+		if err != nil {
+			log.Errorf("Error while sending greetings to user: %v", err)
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+package server_test
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	pb "github.com/prune998/goHelloGrpcStream/helloworld/helloworld"
+	"github.com/prune998/goHelloGrpcStream/helloworld/server"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// testServer is a Greeter served over an in-memory bufconn listener
+type testServer struct {
+	*server.Server
+	conn *grpc.ClientConn
+}
+
+// startServer starts a Greeter with opts and returns a client connection to it.
+// The connection is plain text unless dialOpts set other transport credentials.
+// Everything is torn down when the test ends.
+func startServer(t *testing.T, opts server.Options, dialOpts ...grpc.DialOption) *testServer {
+	t.Helper()
+
+	if opts.Logger == nil {
+		opts.Logger = logrus.New()
+		opts.Logger.SetOutput(io.Discard)
+	}
+	s, err := server.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lis := bufconn.Listen(1024 * 1024)
+	go s.Serve(lis)
+
+	dialOpts = append([]grpc.DialOption{
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	}, dialOpts...)
+	conn, err := grpc.Dial("bufnet", dialOpts...)
+	if err != nil {
+		t.Fatalf("failed to dial bufnet: %v", err)
+	}
+
+	t.Cleanup(func() {
+		conn.Close()
+		s.Stop()
+	})
+	return &testServer{Server: s, conn: conn}
+}
+
+// waitFor polls cond until it is true or the timeout expires
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSayHello(t *testing.T) {
+	opts := server.DefaultOptions()
+	opts.GRPCPort = "1234"
+	ts := startServer(t, opts)
+
+	before := testutil.ToFloat64(server.PromSayHelloReceivedCounter)
+	r, err := pb.NewGreeterClient(ts.conn).SayHello(context.Background(), &pb.HelloRequest{Name: "tester"})
+	if err != nil {
+		t.Fatalf("SayHello failed: %v", err)
+	}
+	if want := "Hello tester 1234"; r.Message != want {
+		t.Errorf("got message %q, want %q", r.Message, want)
+	}
+	if got := testutil.ToFloat64(server.PromSayHelloReceivedCounter) - before; got != 1 {
+		t.Errorf("SayHello counter increased by %v, want 1", got)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	ts := startServer(t, server.DefaultOptions())
+
+	r, err := healthpb.NewHealthClient(ts.conn).Check(context.Background(), &healthpb.HealthCheckRequest{Service: "helloworld.Greeter"})
+	if err != nil {
+		t.Fatalf("health check failed: %v", err)
+	}
+	if r.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("got status %v, want SERVING", r.Status)
+	}
+}
+
+func TestSayHelloStreamReply(t *testing.T) {
+	opts := server.DefaultOptions()
+	opts.Reply = true
+	ts := startServer(t, opts)
+
+	streams := testutil.ToFloat64(server.PromSayHelloStreamReceivedCounter)
+	gauge := testutil.ToFloat64(server.PromSayHelloStreamReceivedGauge)
+
+	stream, err := pb.NewGreeterClient(ts.conn).SayHelloStream(context.Background())
+	if err != nil {
+		t.Fatalf("SayHelloStream failed: %v", err)
+	}
+	for i, name := range []string{"one", "two", "three"} {
+		if err := stream.Send(&pb.HelloRequest{Name: name, Seq: int64(i + 1)}); err != nil {
+			t.Fatalf("Send(%s) failed: %v", name, err)
+		}
+		r, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("Recv after %s failed: %v", name, err)
+		}
+		if want := "Pong " + name; r.Message != want {
+			t.Errorf("got message %q, want %q", r.Message, want)
+		}
+		if r.Seq != int64(i+1) {
+			t.Errorf("got seq %d for %s, want %d", r.Seq, name, i+1)
+		}
+	}
+
+	if got := testutil.ToFloat64(server.PromSayHelloStreamReceivedCounter) - streams; got != 1 {
+		t.Errorf("stream counter increased by %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(server.PromSayHelloStreamReceivedGauge) - gauge; got != 1 {
+		t.Errorf("stream gauge increased by %v, want 1", got)
+	}
+
+	// client half-close ends the stream cleanly on both sides
+	if err := stream.CloseSend(); err != nil {
+		t.Fatalf("CloseSend failed: %v", err)
+	}
+	if _, err := stream.Recv(); err != io.EOF {
+		t.Errorf("Recv after CloseSend got %v, want io.EOF", err)
+	}
+	waitFor(t, "stream gauge to go back down", func() bool {
+		return testutil.ToFloat64(server.PromSayHelloStreamReceivedGauge) == gauge
+	})
+}
+
+func TestSayHelloServerStream(t *testing.T) {
+	ts := startServer(t, server.DefaultOptions())
+
+	stream, err := pb.NewGreeterClient(ts.conn).SayHelloServerStream(context.Background(),
+		&pb.HelloStreamRequest{Name: "tester", Count: 3, IntervalMs: 1})
+	if err != nil {
+		t.Fatalf("SayHelloServerStream failed: %v", err)
+	}
+	for i := 1; i <= 3; i++ {
+		r, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("Recv %d failed: %v", i, err)
+		}
+		if want := fmt.Sprintf("Hello tester %d", i); r.Message != want {
+			t.Errorf("got message %q, want %q", r.Message, want)
+		}
+	}
+	if _, err := stream.Recv(); err != io.EOF {
+		t.Errorf("Recv after the last greeting got %v, want io.EOF", err)
+	}
+}
+
+func TestSayHelloStreamNoReply(t *testing.T) {
+	ts := startServer(t, server.DefaultOptions())
+
+	stream, err := pb.NewGreeterClient(ts.conn).SayHelloStream(context.Background())
+	if err != nil {
+		t.Fatalf("SayHelloStream failed: %v", err)
+	}
+	for _, name := range []string{"one", "two"} {
+		if err := stream.Send(&pb.HelloRequest{Name: name}); err != nil {
+			t.Fatalf("Send(%s) failed: %v", name, err)
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatalf("CloseSend failed: %v", err)
+	}
+
+	// without -reply the server never answers, so the first thing we get is the end of the stream
+	if r, err := stream.Recv(); err != io.EOF {
+		t.Errorf("Recv got (%v, %v), want io.EOF", r, err)
+	}
+}
+
+func TestSayHelloStreamClientCancel(t *testing.T) {
+	ts := startServer(t, server.DefaultOptions())
+	gauge := testutil.ToFloat64(server.PromSayHelloStreamReceivedGauge)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := pb.NewGreeterClient(ts.conn).SayHelloStream(ctx)
+	if err != nil {
+		t.Fatalf("SayHelloStream failed: %v", err)
+	}
+	if err := stream.Send(&pb.HelloRequest{Name: "cancel"}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	waitFor(t, "stream to be opened on the server", func() bool {
+		return testutil.ToFloat64(server.PromSayHelloStreamReceivedGauge) == gauge+1
+	})
+
+	cancel()
+	if _, err := stream.Recv(); status.Code(err) != codes.Canceled {
+		t.Errorf("Recv after cancel got %v, want Canceled", err)
+	}
+	waitFor(t, "stream gauge to go back down", func() bool {
+		return testutil.ToFloat64(server.PromSayHelloStreamReceivedGauge) == gauge
+	})
+}
+
+func TestSayHelloStreamServerStop(t *testing.T) {
+	ts := startServer(t, server.DefaultOptions())
+	gauge := testutil.ToFloat64(server.PromSayHelloStreamReceivedGauge)
+
+	stream, err := pb.NewGreeterClient(ts.conn).SayHelloStream(context.Background())
+	if err != nil {
+		t.Fatalf("SayHelloStream failed: %v", err)
+	}
+	if err := stream.Send(&pb.HelloRequest{Name: "stop"}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	waitFor(t, "stream to be opened on the server", func() bool {
+		return testutil.ToFloat64(server.PromSayHelloStreamReceivedGauge) == gauge+1
+	})
+
+	ts.Stop()
+	if _, err := stream.Recv(); status.Code(err) != codes.Unavailable {
+		t.Errorf("Recv after server stop got %v, want Unavailable", err)
+	}
+	waitFor(t, "stream gauge to go back down", func() bool {
+		return testutil.ToFloat64(server.PromSayHelloStreamReceivedGauge) == gauge
+	})
+}
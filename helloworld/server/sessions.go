@@ -0,0 +1,112 @@
+package server
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// SessionsPath lists the streams being served, as JSON
+const SessionsPath = "/sessions"
+
+// Session is a stream being served
+type Session struct {
+	ID       uint64    `json:"id"`
+	Method   string    `json:"method"`
+	Peer     string    `json:"peer"`
+	Identity string    `json:"identity,omitempty"`
+	Client   string    `json:"client,omitempty"`
+	Started  time.Time `json:"started"`
+}
+
+// sessionList keeps track of the streams and of how many each peer has opened
+type sessionList struct {
+	mu       sync.Mutex
+	lastID   uint64
+	sessions map[uint64]*Session
+	peers    map[string]int
+}
+
+func (l *sessionList) add(session *Session) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.sessions == nil {
+		l.sessions = map[uint64]*Session{}
+		l.peers = map[string]int{}
+	}
+	l.lastID++
+	session.ID = l.lastID
+	l.sessions[session.ID] = session
+
+	host := hostOf(session.Peer)
+	l.peers[host]++
+	PromPeerStreamsGauge.WithLabelValues(host).Inc()
+}
+
+func (l *sessionList) remove(session *Session) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.sessions, session.ID)
+
+	// forget the peers once gone, so the gauge does not grow forever
+	host := hostOf(session.Peer)
+	if l.peers[host]--; l.peers[host] <= 0 {
+		delete(l.peers, host)
+		PromPeerStreamsGauge.DeleteLabelValues(host)
+		return
+	}
+	PromPeerStreamsGauge.WithLabelValues(host).Dec()
+}
+
+// list returns the sessions, oldest first
+func (l *sessionList) list() []Session {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	list := make([]Session, 0, len(l.sessions))
+	for _, session := range l.sessions {
+		list = append(list, *session)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// StreamServerInterceptor lists the streams while they are served.
+// It comes after authentication and limits so only the accepted streams show.
+func (l *sessionList) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := ss.Context()
+		session := &Session{
+			Method:   info.FullMethod,
+			Peer:     PeerAddr(ctx),
+			Identity: Identity(ctx),
+			Client:   clientName(ctx),
+			Started:  time.Now(),
+		}
+		l.add(session)
+		defer l.remove(session)
+		return handler(srv, ss)
+	}
+}
+
+// Sessions returns the streams being served, oldest first
+func (s *Server) Sessions() []Session {
+	return s.sessions.list()
+}
+
+func (s *Server) serveSessions(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Sessions())
+}
+
+// hostOf returns the address without its port
+func hostOf(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
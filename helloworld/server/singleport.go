@@ -0,0 +1,52 @@
+package server
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc"
+)
+
+// isGRPC tells if r is a gRPC call, as opposed to gRPC-Web, Connect or any other HTTP request
+func isGRPC(r *http.Request) bool {
+	ct := r.Header.Get("Content-Type")
+	return r.ProtoMajor == 2 && (ct == "application/grpc" || strings.HasPrefix(ct, "application/grpc+"))
+}
+
+// singlePortHandler sends the gRPC calls to the gRPC server and everything else to HTTPHandler
+func (s *Server) singlePortHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isGRPC(r) {
+			s.grpc.ServeHTTP(w, r)
+			return
+		}
+		s.httpMux.ServeHTTP(w, r)
+	})
+}
+
+// ServeSinglePort serves gRPC and HTTPHandler on lis until Stop is called.
+// gRPC calls are recognized by their HTTP/2 protocol and application/grpc content-type.
+// Without TLSConfig HTTP/2 is in clear text (h2c), otherwise TLS is done here and
+// HTTP/2 or HTTP/1.1 is negotiated with ALPN.
+// The HTTP/2 server of net/http carries the gRPC calls, so the gRPC transport settings do not apply:
+// only MaxConcurrentStreams is set on it, and KeepaliveMinTime is ignored, any keepalive ping being answered.
+func (s *Server) ServeSinglePort(lis net.Listener) error {
+	return s.serveSinglePort(lis, s.opts.TLSConfig)
+}
+
+func (s *Server) serveSinglePort(lis net.Listener, tlsConfig *tls.Config) error {
+	s.mu.Lock()
+	if s.isStopped {
+		s.mu.Unlock()
+		return grpc.ErrServerStopped
+	}
+	if s.grpcLis == nil {
+		s.grpcLis = lis
+	}
+	s.mu.Unlock()
+
+	s.setServing()
+	return s.serveHTTP(lis, s.singlePortHandler(), tlsConfig, "Listening gRPC and HTTP")
+}
@@ -0,0 +1,103 @@
+package server_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	pb "github.com/prune998/goHelloGrpcStream/helloworld/helloworld"
+	"github.com/prune998/goHelloGrpcStream/helloworld/server"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// startSinglePort serves opts on a single ephemeral port and returns its address
+func startSinglePort(t *testing.T, opts server.Options) string {
+	t.Helper()
+	opts.Logger = logrus.New()
+	opts.Logger.SetOutput(io.Discard)
+	s, err := server.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.ServeSinglePort(lis)
+	t.Cleanup(s.Stop)
+	return lis.Addr().String()
+}
+
+// checkSinglePort makes gRPC calls and HTTP requests on the same port
+func checkSinglePort(t *testing.T, addr string, creds credentials.TransportCredentials, httpClient *http.Client, baseURL string) {
+	t.Helper()
+
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r, err := pb.NewGreeterClient(conn).SayHello(context.Background(), &pb.HelloRequest{Name: "single"})
+	if err != nil {
+		t.Fatalf("SayHello failed: %v", err)
+	}
+	if r.Message != "Hello single 7788" {
+		t.Errorf("got message %q", r.Message)
+	}
+	h, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != nil || h.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("health check got %v, %v", h, err)
+	}
+
+	for path, want := range map[string]string{
+		"/healthz":  `"status":"OK"`,
+		"/metrics":  "greeter_server_SayHello_received_counter",
+		"/v1/hello": `"message":"Hello rest 7788"`,
+	} {
+		var resp *http.Response
+		if path == "/v1/hello" {
+			resp, err = httpClient.Post(baseURL+path, "application/json", strings.NewReader(`{"name":"rest"}`))
+		} else {
+			resp, err = httpClient.Get(baseURL + path)
+		}
+		if err != nil {
+			t.Fatalf("%s failed: %v", path, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if !strings.Contains(string(body), want) {
+			t.Errorf("%s (%s) does not contain %s", path, resp.Proto, want)
+		}
+	}
+}
+
+func TestSinglePort(t *testing.T) {
+	addr := startSinglePort(t, server.DefaultOptions())
+	checkSinglePort(t, addr, insecure.NewCredentials(), http.DefaultClient, "http://"+addr)
+}
+
+func TestSinglePortTLS(t *testing.T) {
+	cert := newCert(t, nil, false, []string{"localhost"})
+	opts := server.DefaultOptions()
+	opts.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	addr := startSinglePort(t, opts)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert.Leaf)
+	clientTLS := &tls.Config{RootCAs: roots, ServerName: "localhost"}
+
+	// HTTP/1.1 and HTTP/2 are both negotiated with ALPN
+	for _, h2 := range []bool{false, true} {
+		httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS.Clone(), ForceAttemptHTTP2: h2}}
+		checkSinglePort(t, addr, credentials.NewTLS(clientTLS.Clone()), httpClient, "https://"+addr)
+	}
+}
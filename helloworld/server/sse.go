@@ -0,0 +1,159 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	pb "github.com/prune998/goHelloGrpcStream/helloworld/helloworld"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// SSEPath is where the Greeter stream is served over Server-Sent Events.
+//
+// A GET opens the stream. Its first event is a "session" event holding the session id,
+// then each reply comes in a "message" event. The stream ends with an "end" event, or an
+// "error" event holding the gRPC code and message.
+// The client sends its messages with a POST of a JSON HelloRequest to SSEPath/<session id>,
+// and half-closes the stream with a DELETE of the same URL.
+const SSEPath = "/sse/hello"
+
+// sseSession is the upstream stream of an opened SSE connection
+type sseSession struct {
+	// mu serializes the messages sent by concurrent POSTs
+	mu     sync.Mutex
+	stream pb.Greeter_SayHelloStreamClient
+}
+
+// sseSessions are the opened SSE connections, by session id
+type sseSessions struct {
+	mu       sync.Mutex
+	sessions map[string]*sseSession
+}
+
+func (s *sseSessions) add(session *sseSession) string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	id := hex.EncodeToString(b)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sessions == nil {
+		s.sessions = map[string]*sseSession{}
+	}
+	s.sessions[id] = session
+	return id
+}
+
+func (s *sseSessions) get(id string) *sseSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sessions[id]
+}
+
+func (s *sseSessions) remove(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+}
+
+// serveSSE opens a SayHelloStream on the loopback connection and sends its replies as events
+func (s *Server) serveSSE(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != SSEPath {
+		s.serveSSEMessage(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "use GET to open the stream", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(outgoingContext(r.Context(), r.Header, r.RemoteAddr))
+	defer cancel()
+	upstream, err := pb.NewGreeterClient(s.loopback.conn).SayHelloStream(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	id := s.sse.add(&sseSession{stream: upstream})
+	defer s.sse.remove(id)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	writeEvent(w, "session", id)
+	flusher.Flush()
+
+	for {
+		reply, err := upstream.Recv()
+		if err == io.EOF {
+			writeEvent(w, "end", "")
+			return
+		}
+		if err != nil {
+			st := status.Convert(err)
+			b, _ := json.Marshal(map[string]string{"code": st.Code().String(), "message": st.Message()})
+			writeEvent(w, "error", string(b))
+			return
+		}
+		b, err := protojson.Marshal(reply)
+		if err != nil {
+			continue
+		}
+		writeEvent(w, "message", string(b))
+		flusher.Flush()
+	}
+}
+
+// serveSSEMessage sends a message on the stream of a session, or half-closes it
+func (s *Server) serveSSEMessage(w http.ResponseWriter, r *http.Request) {
+	session := s.sse.get(strings.TrimPrefix(r.URL.Path, SSEPath+"/"))
+	if session == nil {
+		http.Error(w, "unknown session", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		msg := &pb.HelloRequest{}
+		b, err := io.ReadAll(r.Body)
+		if err == nil {
+			err = protojson.Unmarshal(b, msg)
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid HelloRequest: %v", err), http.StatusBadRequest)
+			return
+		}
+		session.mu.Lock()
+		err = session.stream.Send(msg)
+		session.mu.Unlock()
+		if err != nil {
+			http.Error(w, "the stream is over", http.StatusGone)
+			return
+		}
+	case http.MethodDelete:
+		session.mu.Lock()
+		session.stream.CloseSend()
+		session.mu.Unlock()
+	default:
+		http.Error(w, "use POST to send a message or DELETE to close the stream", http.StatusMethodNotAllowed)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeEvent writes a Server-Sent Event, data being on a single line
+func writeEvent(w io.Writer, event, data string) {
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+}
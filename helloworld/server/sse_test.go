@@ -0,0 +1,118 @@
+package server_test
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	pb "github.com/prune998/goHelloGrpcStream/helloworld/helloworld"
+	"github.com/prune998/goHelloGrpcStream/helloworld/server"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// nextEvent reads the next Server-Sent Event
+func nextEvent(t *testing.T, events *bufio.Scanner) (event, data string) {
+	t.Helper()
+	for events.Scan() {
+		line := events.Text()
+		switch {
+		case line == "" && event != "":
+			return event, data
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+	t.Fatalf("event stream ended: %v", events.Err())
+	return "", ""
+}
+
+func TestSSE(t *testing.T) {
+	opts := server.DefaultOptions()
+	opts.Reply = true
+	ts := startServer(t, opts)
+	web := httptest.NewServer(ts.HTTPHandler())
+	defer web.Close()
+
+	resp, err := http.Get(web.URL + server.SSEPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("got content type %q", ct)
+	}
+	events := bufio.NewScanner(resp.Body)
+	event, id := nextEvent(t, events)
+	if event != "session" || id == "" {
+		t.Fatalf("got %s event %q, want the session", event, id)
+	}
+	sessionURL := web.URL + server.SSEPath + "/" + id
+
+	for _, name := range []string{"one", "two"} {
+		resp, err := http.Post(sessionURL, "application/json", strings.NewReader(`{"name":"`+name+`"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("POST got status %v", resp.Status)
+		}
+		event, data := nextEvent(t, events)
+		var reply pb.HelloReply
+		if err := protojson.Unmarshal([]byte(data), &reply); err != nil {
+			t.Fatal(err)
+		}
+		if want := "Pong " + name; event != "message" || reply.Message != want || reply.Backend.GetPod() == "" {
+			t.Errorf("got %s event %s, want message %s from a backend", event, data, want)
+		}
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, sessionURL, nil)
+	if resp, err := http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	} else {
+		resp.Body.Close()
+	}
+	if event, _ := nextEvent(t, events); event != "end" {
+		t.Errorf("got %s event after DELETE, want end", event)
+	}
+
+	// the session is gone with its stream
+	resp, err = http.Post(sessionURL, "application/json", strings.NewReader(`{"name":"three"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("POST after the end got status %v, want 404", resp.Status)
+	}
+}
+
+func TestSSEError(t *testing.T) {
+	opts := server.DefaultOptions()
+	opts.Auth.APIKeys = map[string]string{"secret": "tester"}
+	ts := startServer(t, opts)
+	web := httptest.NewServer(ts.HTTPHandler())
+	defer web.Close()
+
+	resp, err := http.Get(web.URL + server.SSEPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	events := bufio.NewScanner(resp.Body)
+	event, id := nextEvent(t, events)
+	if event != "session" {
+		t.Fatalf("got %s event, want the session", event)
+	}
+
+	http.Post(web.URL+server.SSEPath+"/"+id, "application/json", strings.NewReader(`{"name":"one"}`))
+	event, data := nextEvent(t, events)
+	if event != "error" || !strings.Contains(data, `"code":"Unauthenticated"`) {
+		t.Errorf("got %s event %s, want an Unauthenticated error", event, data)
+	}
+}
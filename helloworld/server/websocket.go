@@ -0,0 +1,111 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	pb "github.com/prune998/goHelloGrpcStream/helloworld/helloworld"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// WebSocketPath is where the Greeter stream is served over WebSocket.
+// Each text message is a JSON HelloRequest, answered by a JSON HelloReply when the server replies.
+const WebSocketPath = "/ws/hello"
+
+var upgrader = websocket.Upgrader{
+	// the endpoint is meant for tests from anywhere, browsers included
+	CheckOrigin: func(*http.Request) bool { return true },
+}
+
+// serveWebSocket bridges a WebSocket to a SayHelloStream on the loopback connection.
+// The WebSocket is closed with a status matching the gRPC one when the stream ends.
+func (s *Server) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader already replied with an HTTP error
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(outgoingContext(r.Context(), r.Header, r.RemoteAddr))
+	defer cancel()
+	upstream, err := pb.NewGreeterClient(s.loopback.conn).SayHelloStream(ctx)
+	if err != nil {
+		conn.WriteMessage(websocket.CloseMessage, closeMessage(err))
+		return
+	}
+
+	// the replies are written by this goroutine only, until the stream is over
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			reply, err := upstream.Recv()
+			if err != nil {
+				conn.WriteMessage(websocket.CloseMessage, closeMessage(err))
+				// give the client a second to answer the close before the reader gives up
+				conn.SetReadDeadline(time.Now().Add(time.Second))
+				return
+			}
+			b, err := protojson.Marshal(reply)
+			if err != nil {
+				continue
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, b); err != nil {
+				cancel()
+				return
+			}
+		}
+	}()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			// a close from the client is a half-close of the stream, the server ends it
+			upstream.CloseSend()
+			break
+		}
+		msg := &pb.HelloRequest{}
+		if err := protojson.Unmarshal(data, msg); err != nil {
+			cancel()
+			break
+		}
+		if err := upstream.Send(msg); err != nil {
+			break
+		}
+	}
+	<-done
+}
+
+// closeMessage is the WebSocket close frame matching the end of a gRPC stream
+func closeMessage(err error) []byte {
+	if err == io.EOF {
+		return websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	}
+	st := status.Convert(err)
+	code := websocket.CloseInternalServerErr
+	switch st.Code() {
+	case codes.Canceled:
+		code = websocket.CloseNormalClosure
+	case codes.InvalidArgument:
+		code = websocket.CloseUnsupportedData
+	case codes.Unauthenticated, codes.PermissionDenied:
+		code = websocket.ClosePolicyViolation
+	case codes.ResourceExhausted:
+		code = websocket.CloseTryAgainLater
+	case codes.Unavailable:
+		code = websocket.CloseServiceRestart
+	}
+	// the reason of a close frame is at most 123 bytes
+	reason := fmt.Sprintf("%s: %s", st.Code(), st.Message())
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	return websocket.FormatCloseMessage(code, reason)
+}
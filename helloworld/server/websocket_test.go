@@ -0,0 +1,73 @@
+package server_test
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	pb "github.com/prune998/goHelloGrpcStream/helloworld/helloworld"
+	"github.com/prune998/goHelloGrpcStream/helloworld/server"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// dialWebSocket opens a WebSocket to the stream endpoint of ts
+func dialWebSocket(t *testing.T, ts *testServer) *websocket.Conn {
+	t.Helper()
+	web := httptest.NewServer(ts.HTTPHandler())
+	t.Cleanup(web.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(web.URL, "http")+server.WebSocketPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	// do not answer the close of the server, so ReadMessage returns it
+	conn.SetCloseHandler(func(int, string) error { return nil })
+	return conn
+}
+
+func TestWebSocket(t *testing.T) {
+	opts := server.DefaultOptions()
+	opts.Reply = true
+	conn := dialWebSocket(t, startServer(t, opts))
+
+	for _, name := range []string{"one", "two"} {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"name":"`+name+`"}`)); err != nil {
+			t.Fatal(err)
+		}
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var reply pb.HelloReply
+		if err := protojson.Unmarshal(data, &reply); err != nil {
+			t.Fatal(err)
+		}
+		if want := "Pong " + name; reply.Message != want || reply.Backend.GetPod() == "" {
+			t.Errorf("got %s, want %s from a backend", data, want)
+		}
+	}
+
+	// closing our side ends the stream, the server closes normally
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Errorf("got %v, want a normal close", err)
+	}
+}
+
+func TestWebSocketUnauthenticated(t *testing.T) {
+	opts := server.DefaultOptions()
+	opts.Auth.APIKeys = map[string]string{"secret": "tester"}
+	conn := dialWebSocket(t, startServer(t, opts))
+
+	// the stream is rejected once the server gets the first message
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"name":"one"}`))
+	_, _, err := conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Fatalf("got %v, want a policy violation close", err)
+	}
+	if !strings.Contains(err.Error(), "Unauthenticated") {
+		t.Errorf("close reason %q does not give the gRPC code", err)
+	}
+}